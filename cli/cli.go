@@ -5,17 +5,30 @@ import (
 	"blockchain-from-scratch/core/wallet"
 	"blockchain-from-scratch/node"
 	"blockchain-from-scratch/utils"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"math"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
 type CLI struct {
 	Chain *core.Blockchain
+	// Params are the parameters of the network selected with -net or the NETWORK env. var.
+	Params *core.ChainParams
+	// passphrase unlocks the encrypted wallet file for the command run by walletpassphrase
+	passphrase string
 }
 
 func (cli *CLI) Run() {
@@ -32,16 +45,115 @@ func (cli *CLI) Run() {
 	printChainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
 	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
 	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
+	restoreWalletCmd := flag.NewFlagSet("restorewallet", flag.ExitOnError)
+	encryptWalletCmd := flag.NewFlagSet("encryptwallet", flag.ExitOnError)
+	walletPassphraseCmd := flag.NewFlagSet("walletpassphrase", flag.ExitOnError)
+	dumpPrivKeyCmd := flag.NewFlagSet("dumpprivkey", flag.ExitOnError)
+	importPrivKeyCmd := flag.NewFlagSet("importprivkey", flag.ExitOnError)
+	importPubKeyCmd := flag.NewFlagSet("importpubkey", flag.ExitOnError)
+	importAddressCmd := flag.NewFlagSet("importaddress", flag.ExitOnError)
+	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
+	getWalletBalanceCmd := flag.NewFlagSet("getwalletbalance", flag.ExitOnError)
+	listTransactionsCmd := flag.NewFlagSet("listtransactions", flag.ExitOnError)
+	setLabelCmd := flag.NewFlagSet("setlabel", flag.ExitOnError)
+	listLabelsCmd := flag.NewFlagSet("listlabels", flag.ExitOnError)
 	getUTXODetailsCmd := flag.NewFlagSet("getUTXODetails", flag.ExitOnError)
 	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
+	reindexTxCmd := flag.NewFlagSet("reindextx", flag.ExitOnError)
+	getBlockCmd := flag.NewFlagSet("getblock", flag.ExitOnError)
+	supplyCmd := flag.NewFlagSet("supply", flag.ExitOnError)
+	generateCmd := flag.NewFlagSet("generate", flag.ExitOnError)
+	createMultiSigCmd := flag.NewFlagSet("createmultisig", flag.ExitOnError)
+	spendMultiSigCmd := flag.NewFlagSet("spendmultisig", flag.ExitOnError)
+	signMultiSigCmd := flag.NewFlagSet("signmultisig", flag.ExitOnError)
+	finalizeMultiSigCmd := flag.NewFlagSet("finalizemultisig", flag.ExitOnError)
+	anchorCmd := flag.NewFlagSet("anchor", flag.ExitOnError)
+	listAnchorsCmd := flag.NewFlagSet("listanchors", flag.ExitOnError)
+	htlcCreateCmd := flag.NewFlagSet("htlc create", flag.ExitOnError)
+	htlcClaimCmd := flag.NewFlagSet("htlc claim", flag.ExitOnError)
+	htlcRefundCmd := flag.NewFlagSet("htlc refund", flag.ExitOnError)
+	htlcSecretCmd := flag.NewFlagSet("htlc secret", flag.ExitOnError)
 
 	getBalanceAddress := getbalanceCmd.String("address", "", "The address to get balance for")
-	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
+	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send the genesis block reward of the custom chain to")
 	sendFrom := sendCmd.String("from", "", "Source wallet address")
 	sendTo := sendCmd.String("to", "", "Destination wallet address")
 	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
 	sendMine := sendCmd.Bool("mine", false, "Mine immediately on the same node")
+	sendFee := sendCmd.Int("fee", 0, "Fee paid to the miner, on top of the amount")
+	sendLockUntil := sendCmd.Uint("lockuntil", 0, "Lock the coins sent until this block height, or unix time from 500000000 on")
+	sendLockBlocks := sendCmd.Uint("lockblocks", 0, "Lock the coins sent for this number of blocks")
+	createWalletMnemonic := createWalletCmd.Bool("mnemonic", false, "Make an HD wallet deriving its keys from a new mnemonic")
+	restoreWalletMnemonic := restoreWalletCmd.String("mnemonic", "", "Mnemonic of the HD wallet, the words separated by spaces")
+	encryptWalletPassphrase := encryptWalletCmd.String("passphrase", "", "Passphrase encrypting the wallet file")
+	walletPassphrase := walletPassphraseCmd.String("passphrase", "", "Passphrase of the wallet file")
+	dumpPrivKeyAddress := dumpPrivKeyCmd.String("address", "", "Wallet address of the private key")
+	importPrivKey := importPrivKeyCmd.String("key", "", "Private key exported by dumpprivkey")
+	importPrivKeyRescan := importPrivKeyCmd.Bool("rescan", false, "Look for the coins and the transactions of the key in the chain")
+	importPubKey := importPubKeyCmd.String("pubkey", "", "Public key to watch, in hex")
+	importPubKeyRescan := importPubKeyCmd.Bool("rescan", false, "Look for the coins and the transactions of the key in the chain")
+	importAddress := importAddressCmd.String("address", "", "Address to watch, like a multisig address")
+	importAddressRescan := importAddressCmd.Bool("rescan", false, "Index the transactions of the chain again to find the ones of the address")
+	listTransactionsCount := listTransactionsCmd.Int("count", 10, "Number of the most recent transactions to list")
+	listTransactionsAddress := listTransactionsCmd.String("address", "", "Only list the transactions touching this address")
+	setLabelAddress := setLabelCmd.String("address", "", "Address of the wallet or of the address book")
+	setLabel := setLabelCmd.String("label", "", "Label of the address, it is removed when empty")
 	startNodeMiner := startNodeCmd.String("miner", "", "Enable mining mode and send reward to ADDRESS")
+	startNodePort := startNodeCmd.String("port", "", "Port to listen on, the default port of the network if empty")
+	reindexTxDisable := reindexTxCmd.Bool("disable", false, "Drop the transaction index instead of rebuilding it")
+	getBlockHeight := getBlockCmd.Int("height", -1, "Height of the block in the main chain")
+	getBlockHash := getBlockCmd.String("hash", "", "Hash of the block, in hex")
+	generateAddress := generateCmd.String("address", "", "The address to send the block rewards to")
+	generateCount := generateCmd.Int("count", 1, "Number of blocks to mine")
+	createMultiSigRequired := createMultiSigCmd.Int("required", 0, "Number of signatures required to spend")
+	createMultiSigPubKeys := createMultiSigCmd.String("pubkeys", "", "Comma separated public keys in hex, or addresses of the wallet")
+	spendMultiSigScript := spendMultiSigCmd.String("redeemscript", "", "Redeem script of the multisig address, in hex")
+	spendMultiSigTo := spendMultiSigCmd.String("to", "", "Destination wallet address")
+	spendMultiSigAmount := spendMultiSigCmd.Int("amount", 0, "Amount to send")
+	spendMultiSigFee := spendMultiSigCmd.Int("fee", 0, "Fee paid to the miner, on top of the amount")
+	spendMultiSigFile := spendMultiSigCmd.String("file", "", "File to write the unsigned transaction to")
+	signMultiSigFile := signMultiSigCmd.String("file", "", "File of the transaction to sign")
+	signMultiSigAddress := signMultiSigCmd.String("address", "", "Address of the wallet key to sign with")
+	finalizeMultiSigFile := finalizeMultiSigCmd.String("file", "", "File of the signed transaction")
+	finalizeMultiSigMiner := finalizeMultiSigCmd.String("miner", "", "Mine immediately on the same node and send the reward to ADDRESS")
+	anchorFrom := anchorCmd.String("from", "", "Wallet address paying the fee")
+	anchorData := anchorCmd.String("data", "", "Data to anchor in hex, like a document hash")
+	anchorFee := anchorCmd.Int("fee", 0, "Fee paid to the miner")
+	anchorMine := anchorCmd.Bool("mine", false, "Mine immediately on the same node")
+	listAnchorsStart := listAnchorsCmd.Int("start", 0, "Height of the first block")
+	listAnchorsEnd := listAnchorsCmd.Int("end", -1, "Height of the last block, the tip when it is not set")
+	htlcCreateFrom := htlcCreateCmd.String("from", "", "Wallet address funding the contract, it gets the refund")
+	htlcCreateTo := htlcCreateCmd.String("to", "", "Wallet address that can claim the contract with the secret")
+	htlcCreateAmount := htlcCreateCmd.Int("amount", 0, "Amount locked in the contract")
+	htlcCreateFee := htlcCreateCmd.Int("fee", 0, "Fee paid to the miner, on top of the amount")
+	htlcCreateLockTime := htlcCreateCmd.Uint("locktime", 0, "Block height, or unix time from 500000000 on, of the refund")
+	htlcCreateHash := htlcCreateCmd.String("hash", "", "SHA-256 of the secret in hex, a new secret is generated when it is not set")
+	htlcCreateMine := htlcCreateCmd.Bool("mine", false, "Mine immediately on the same node")
+	htlcClaimAddress := htlcClaimCmd.String("address", "", "Wallet address of the recipient of the contract")
+	htlcClaimTxid := htlcClaimCmd.String("txid", "", "Id of the transaction of the contract, in hex")
+	htlcClaimVout := htlcClaimCmd.Int("vout", 0, "Index of the contract output in the transaction")
+	htlcClaimSecret := htlcClaimCmd.String("secret", "", "Secret of the contract, in hex")
+	htlcClaimFee := htlcClaimCmd.Int("fee", 0, "Fee paid to the miner, out of the contract amount")
+	htlcClaimMine := htlcClaimCmd.Bool("mine", false, "Mine immediately on the same node")
+	htlcRefundAddress := htlcRefundCmd.String("address", "", "Wallet address that funded the contract")
+	htlcRefundTxid := htlcRefundCmd.String("txid", "", "Id of the transaction of the contract, in hex")
+	htlcRefundVout := htlcRefundCmd.Int("vout", 0, "Index of the contract output in the transaction")
+	htlcRefundFee := htlcRefundCmd.Int("fee", 0, "Fee paid to the miner, out of the contract amount")
+	htlcRefundMine := htlcRefundCmd.Bool("mine", false, "Mine immediately on the same node")
+	htlcSecretTxid := htlcSecretCmd.String("txid", "", "Id of the transaction claiming a contract, in hex")
+
+	network := os.Getenv("NETWORK")
+	if network == "" {
+		network = core.MainNetParams.Name
+	}
+	for _, cmd := range []*flag.FlagSet{createBlockchainCmd, getbalanceCmd, printChainCmd, sendCmd, createWalletCmd, restoreWalletCmd,
+		encryptWalletCmd, dumpPrivKeyCmd, importPrivKeyCmd, importPubKeyCmd,
+		importAddressCmd, listAddressesCmd, getWalletBalanceCmd, listTransactionsCmd, setLabelCmd, listLabelsCmd,
+		getUTXODetailsCmd, startNodeCmd, reindexTxCmd, getBlockCmd, supplyCmd, generateCmd,
+		createMultiSigCmd, spendMultiSigCmd, signMultiSigCmd, finalizeMultiSigCmd,
+		htlcCreateCmd, htlcClaimCmd, htlcRefundCmd, htlcSecretCmd, anchorCmd, listAnchorsCmd} {
+		cmd.StringVar(&network, "net", network, "Network to use: main, test, regtest or custom")
+	}
 
 	switch os.Args[1] {
 	case "createblockchain":
@@ -69,6 +181,66 @@ func (cli *CLI) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "restorewallet":
+		err := restoreWalletCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "encryptwallet":
+		err := encryptWalletCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "walletpassphrase":
+		err := walletPassphraseCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "dumpprivkey":
+		err := dumpPrivKeyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "importprivkey":
+		err := importPrivKeyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "importpubkey":
+		err := importPubKeyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "importaddress":
+		err := importAddressCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "listaddresses":
+		err := listAddressesCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getwalletbalance":
+		err := getWalletBalanceCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "listtransactions":
+		err := listTransactionsCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "setlabel":
+		err := setLabelCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "listlabels":
+		err := listLabelsCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "getUTXODetails":
 		err := getUTXODetailsCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -79,11 +251,92 @@ func (cli *CLI) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "reindextx":
+		err := reindexTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getblock":
+		err := getBlockCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "supply":
+		err := supplyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "generate":
+		err := generateCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "anchor":
+		err := anchorCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "listanchors":
+		err := listAnchorsCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "htlc":
+		if len(os.Args) < 3 {
+			cli.printUsage()
+			os.Exit(1)
+		}
+		var err error
+		switch os.Args[2] {
+		case "create":
+			err = htlcCreateCmd.Parse(os.Args[3:])
+		case "claim":
+			err = htlcClaimCmd.Parse(os.Args[3:])
+		case "refund":
+			err = htlcRefundCmd.Parse(os.Args[3:])
+		case "secret":
+			err = htlcSecretCmd.Parse(os.Args[3:])
+		default:
+			cli.printUsage()
+			os.Exit(1)
+		}
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createmultisig":
+		err := createMultiSigCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "spendmultisig":
+		err := spendMultiSigCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "signmultisig":
+		err := signMultiSigCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "finalizemultisig":
+		err := finalizeMultiSigCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	default:
 		cli.printUsage()
 		os.Exit(1)
 	}
 
+	params, err := core.ParamsByName(network)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cli.Params = params
+	wallet.SetAddressVersion(params.AddressVersion)
+	wallet.SetScriptAddressVersion(params.ScriptAddressVersion)
+	wallet.SetPrivateKeyVersion(params.PrivateKeyVersion)
+
 	if createBlockchainCmd.Parsed() {
 		if *createBlockchainAddress == "" {
 			createBlockchainCmd.Usage()
@@ -108,16 +361,208 @@ func (cli *CLI) Run() {
 	}
 
 	if sendCmd.Parsed() {
-		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
+		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 || *sendFee < 0 ||
+			*sendLockUntil > math.MaxUint32 || *sendLockBlocks > uint(core.SequenceLockTimeMask) ||
+			(*sendLockUntil > 0 && *sendLockBlocks > 0) {
 			sendCmd.Usage()
 			os.Exit(1)
 		}
 
-		cli.send(*sendFrom, *sendTo, nodeID, *sendAmount, *sendMine)
+		cli.send(*sendFrom, *sendTo, nodeID, *sendAmount, *sendFee, *sendMine, uint32(*sendLockUntil), uint32(*sendLockBlocks))
 	}
 
 	if createWalletCmd.Parsed() {
-		cli.createWallet(nodeID)
+		cli.createWallet(nodeID, *createWalletMnemonic)
+	}
+
+	if restoreWalletCmd.Parsed() {
+		if *restoreWalletMnemonic == "" {
+			restoreWalletCmd.Usage()
+			os.Exit(1)
+		}
+		cli.restoreWallet(nodeID, *restoreWalletMnemonic)
+	}
+
+	if encryptWalletCmd.Parsed() {
+		if *encryptWalletPassphrase == "" {
+			encryptWalletCmd.Usage()
+			os.Exit(1)
+		}
+		cli.encryptWallet(nodeID, *encryptWalletPassphrase)
+	}
+
+	if walletPassphraseCmd.Parsed() {
+		if *walletPassphrase == "" || walletPassphraseCmd.NArg() == 0 {
+			walletPassphraseCmd.Usage()
+			os.Exit(1)
+		}
+		cli.walletPassphrase(nodeID, *walletPassphrase, walletPassphraseCmd.Args())
+	}
+
+	if dumpPrivKeyCmd.Parsed() {
+		if *dumpPrivKeyAddress == "" {
+			dumpPrivKeyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.dumpPrivKey(nodeID, *dumpPrivKeyAddress)
+	}
+
+	if importPrivKeyCmd.Parsed() {
+		if *importPrivKey == "" {
+			importPrivKeyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importPrivKey(nodeID, *importPrivKey, *importPrivKeyRescan)
+	}
+
+	if importPubKeyCmd.Parsed() {
+		if *importPubKey == "" {
+			importPubKeyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importPubKey(nodeID, *importPubKey, *importPubKeyRescan)
+	}
+
+	if importAddressCmd.Parsed() {
+		if *importAddress == "" {
+			importAddressCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importAddress(nodeID, *importAddress, *importAddressRescan)
+	}
+
+	if listAddressesCmd.Parsed() {
+		cli.listAddresses(nodeID)
+	}
+
+	if getWalletBalanceCmd.Parsed() {
+		cli.getWalletBalance(nodeID)
+	}
+
+	if listTransactionsCmd.Parsed() {
+		if *listTransactionsCount <= 0 {
+			listTransactionsCmd.Usage()
+			os.Exit(1)
+		}
+		cli.listTransactions(nodeID, *listTransactionsAddress, *listTransactionsCount)
+	}
+
+	if setLabelCmd.Parsed() {
+		if *setLabelAddress == "" {
+			setLabelCmd.Usage()
+			os.Exit(1)
+		}
+		cli.setLabel(nodeID, *setLabelAddress, *setLabel)
+	}
+
+	if listLabelsCmd.Parsed() {
+		cli.listLabels(nodeID)
+	}
+
+	if reindexTxCmd.Parsed() {
+		cli.reindexTx(nodeID, *reindexTxDisable)
+	}
+
+	if getBlockCmd.Parsed() {
+		if (*getBlockHeight < 0) == (*getBlockHash == "") {
+			getBlockCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getBlock(nodeID, *getBlockHeight, *getBlockHash)
+	}
+
+	if supplyCmd.Parsed() {
+		cli.supply(nodeID)
+	}
+
+	if generateCmd.Parsed() {
+		if *generateAddress == "" || *generateCount <= 0 {
+			generateCmd.Usage()
+			os.Exit(1)
+		}
+		cli.generate(*generateAddress, nodeID, *generateCount)
+	}
+
+	if createMultiSigCmd.Parsed() {
+		if *createMultiSigRequired <= 0 || *createMultiSigPubKeys == "" {
+			createMultiSigCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createMultiSig(nodeID, *createMultiSigRequired, strings.Split(*createMultiSigPubKeys, ","))
+	}
+
+	if spendMultiSigCmd.Parsed() {
+		if *spendMultiSigScript == "" || *spendMultiSigTo == "" || *spendMultiSigAmount <= 0 || *spendMultiSigFee < 0 || *spendMultiSigFile == "" {
+			spendMultiSigCmd.Usage()
+			os.Exit(1)
+		}
+		cli.spendMultiSig(nodeID, *spendMultiSigScript, *spendMultiSigTo, *spendMultiSigAmount, *spendMultiSigFee, *spendMultiSigFile)
+	}
+
+	if signMultiSigCmd.Parsed() {
+		if *signMultiSigFile == "" || *signMultiSigAddress == "" {
+			signMultiSigCmd.Usage()
+			os.Exit(1)
+		}
+		cli.signMultiSig(nodeID, *signMultiSigFile, *signMultiSigAddress)
+	}
+
+	if finalizeMultiSigCmd.Parsed() {
+		if *finalizeMultiSigFile == "" {
+			finalizeMultiSigCmd.Usage()
+			os.Exit(1)
+		}
+		cli.finalizeMultiSig(nodeID, *finalizeMultiSigFile, *finalizeMultiSigMiner)
+	}
+
+	if anchorCmd.Parsed() {
+		if *anchorFrom == "" || *anchorData == "" || *anchorFee < 0 {
+			anchorCmd.Usage()
+			os.Exit(1)
+		}
+		cli.anchor(nodeID, *anchorFrom, *anchorData, *anchorFee, *anchorMine)
+	}
+
+	if listAnchorsCmd.Parsed() {
+		if *listAnchorsStart < 0 {
+			listAnchorsCmd.Usage()
+			os.Exit(1)
+		}
+		cli.listAnchors(nodeID, *listAnchorsStart, *listAnchorsEnd)
+	}
+
+	if htlcCreateCmd.Parsed() {
+		if *htlcCreateFrom == "" || *htlcCreateTo == "" || *htlcCreateAmount <= 0 || *htlcCreateFee < 0 ||
+			*htlcCreateLockTime == 0 || *htlcCreateLockTime > math.MaxUint32 {
+			htlcCreateCmd.Usage()
+			os.Exit(1)
+		}
+		cli.htlcCreate(nodeID, *htlcCreateFrom, *htlcCreateTo, *htlcCreateAmount, *htlcCreateFee,
+			uint32(*htlcCreateLockTime), *htlcCreateHash, *htlcCreateMine)
+	}
+
+	if htlcClaimCmd.Parsed() {
+		if *htlcClaimAddress == "" || *htlcClaimTxid == "" || *htlcClaimSecret == "" || *htlcClaimFee < 0 {
+			htlcClaimCmd.Usage()
+			os.Exit(1)
+		}
+		cli.htlcSpend(nodeID, *htlcClaimAddress, *htlcClaimTxid, *htlcClaimVout, *htlcClaimSecret, *htlcClaimFee, *htlcClaimMine)
+	}
+
+	if htlcRefundCmd.Parsed() {
+		if *htlcRefundAddress == "" || *htlcRefundTxid == "" || *htlcRefundFee < 0 {
+			htlcRefundCmd.Usage()
+			os.Exit(1)
+		}
+		cli.htlcSpend(nodeID, *htlcRefundAddress, *htlcRefundTxid, *htlcRefundVout, "", *htlcRefundFee, *htlcRefundMine)
+	}
+
+	if htlcSecretCmd.Parsed() {
+		if *htlcSecretTxid == "" {
+			htlcSecretCmd.Usage()
+			os.Exit(1)
+		}
+		cli.htlcSecret(nodeID, *htlcSecretTxid)
 	}
 
 	if startNodeCmd.Parsed() {
@@ -126,30 +571,36 @@ func (cli *CLI) Run() {
 			startNodeCmd.Usage()
 			os.Exit(1)
 		}
-		cli.startNode(nodeID, *startNodeMiner)
+		cli.startNode(nodeID, *startNodePort, *startNodeMiner)
 	}
 }
 
 func (cli *CLI) createBlockchain(address, nodeID string) {
-	bc := core.CreateBlockchain(address, nodeID)
-	defer bc.Db.Close()
-	UTXOSet := core.UTXOSet{Blockchain: bc}
-	UTXOSet.Reindex()
+	bc, err := core.CreateBlockchain(cli.Params, address, nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer bc.Close()
 	fmt.Println("Done!")
 }
 
 func (cli *CLI) printChain(nodeID string) {
-	bc := core.NewBlockChain(nodeID)
-	defer bc.Db.Close()
+	bc, err := core.NewBlockChain(cli.Params, nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer bc.Close()
 
 	bci := bc.Iterator()
 
 	for {
-		block := bci.Next()
+		block, err := bci.Next()
+		if err != nil {
+			log.Fatal(err)
+		}
 		//fmt.Printf("============ Block %x ============\n", block.Hash)
 		//fmt.Printf("Prev. block: %x\n", block.PrevBlockHash)
-		pow := core.NewProofOfWork(block)
-		fmt.Printf("PoW: %s\n\n", strconv.FormatBool(pow.Validate()))
+		fmt.Printf("PoW: %s\n\n", strconv.FormatBool(bc.ValidateProofOfWork(block)))
 		//for _, tx := range block.Transactions {
 		//	utils.PrintJsonLog(tx, "printChain")
 		//}
@@ -162,73 +613,868 @@ func (cli *CLI) printChain(nodeID string) {
 	}
 }
 
+func (cli *CLI) getBlock(nodeID string, height int, hash string) {
+	bc, err := core.NewBlockChain(cli.Params, nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer bc.Close()
+
+	var block core.Block
+	if hash != "" {
+		blockHash, err := hex.DecodeString(hash)
+		if err != nil {
+			log.Fatal(err)
+		}
+		block, err = bc.GetBlock(blockHash)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		block, err = bc.GetBlockByHeight(height)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	utils.PrintJsonLog(block, "Block")
+}
+
+func (cli *CLI) supply(nodeID string) {
+	bc, err := core.NewBlockChain(cli.Params, nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer bc.Close()
+
+	height, err := bc.GetBestHeight()
+	if err != nil {
+		log.Fatal(err)
+	}
+	supply, err := bc.Supply()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Height: %d\n", height)
+	fmt.Printf("Block subsidy: %d\n", bc.Params.CalcBlockSubsidy(height+1))
+	fmt.Printf("Issued supply: %d / %d\n", supply, bc.Params.MaxSupply)
+}
+
 func (cli *CLI) getBalance(address, nodeID string) {
 	if !wallet.ValidateAddress(address) {
 		log.Panic("ERROR: Address is not valid")
 	}
-	chain := core.NewBlockChain(nodeID)
+	chain, err := core.NewBlockChain(cli.Params, nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
 	UTXOSet := core.UTXOSet{Blockchain: chain}
-	defer chain.Db.Close()
+	defer chain.Close()
+
+	// The balance of a user's address is simply the sum of all UTXOs they own,
+	// but mining rewards can only be spent once they are mature.
+	hash, isScript, err := wallet.DecodeAddress(address)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var balance core.Balance
+	if isScript {
+		balance, err = UTXOSet.GetScriptBalance(hash)
+	} else {
+		balance, err = UTXOSet.GetBalance(hash)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	logrus.Infof("Balance of '%s': confirmed %d, immature %d, locked %d, spendable %d\n",
+		address, balance.Confirmed, balance.Immature, balance.Locked, balance.Spendable)
+}
+
+func (cli *CLI) generate(address, nodeID string, count int) {
+	if !wallet.ValidateAddress(address) {
+		log.Panic("ERROR: Address is not valid")
+	}
+	chain, err := core.NewBlockChain(cli.Params, nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer chain.Close()
 
-	// The balance of a user's address is simply the sum of all UTXOs they own.
-	balance := 0
-	pubKeyHash := wallet.Base58Decode([]byte(address))
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	utxos := UTXOSet.FindUTXO(pubKeyHash)
-	for _, out := range utxos {
-		balance += out.Value
+	for i := 0; i < count; i++ {
+		cbTx, err := chain.NewCoinbaseTx(address, 0)
+		if err != nil {
+			log.Fatal(err)
+		}
+		_, err = chain.MineBlock([]*core.Transaction{cbTx})
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
-	logrus.Infof("Balance of '%s': %d\n", address, balance)
+	fmt.Printf("Mined %d blocks\n", count)
 }
 
-func (cli *CLI) send(from, to, nodeID string, amount int, mineNow bool) {
+func (cli *CLI) send(from, to, nodeID string, amount, fee int, mineNow bool, lockUntil, lockBlocks uint32) {
 	if !wallet.ValidateAddress(from) || !wallet.ValidateAddress(to) {
 		log.Panic("ERROR: Address is not valid")
 	}
+	out, err := core.NewTXOutput(amount, to)
+	if err != nil {
+		log.Fatal(err)
+	}
+	scriptPubKey := out.ScriptPubKey
+	if lockUntil > 0 || lockBlocks > 0 {
+		pubKeyHash, isScript, err := wallet.DecodeAddress(to)
+		if err != nil || isScript {
+			log.Panic("ERROR: Only the coins sent to a wallet address can be locked")
+		}
+		if lockUntil > 0 {
+			scriptPubKey = core.LockTimeScript(lockUntil, pubKeyHash)
+		} else {
+			scriptPubKey = core.SequenceLockScript(lockBlocks, pubKeyHash)
+		}
+	}
 
-	chain := core.NewBlockChain(nodeID)
+	chain, err := core.NewBlockChain(cli.Params, nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
 	UTXOSet := core.UTXOSet{Blockchain: chain}
-	defer chain.Db.Close()
+	defer chain.Close()
 
-	wallets, err := wallet.NewWallets(nodeID)
+	wallets, err := cli.loadWallets(nodeID)
 	if err != nil {
-		log.Panic(err)
+		log.Fatal(err)
+	}
+	w, err := wallets.GetWallet(from)
+	if err != nil {
+		log.Fatal(err)
 	}
-	wallet := wallets.GetWallet(from)
-
-	tx := core.NewUTXOTransaction(&wallet, to, amount, &UTXOSet)
 
-	if mineNow {
-		cbTx := core.NewCoinbaseTx(from, "")
-		txs := []*core.Transaction{cbTx, tx}
+	// an HD wallet sends the change to a new key of its change chain
+	changeScript := core.PayToPubKeyHashScript(wallet.HashPubKey(w.PublicKey))
+	if wallets.IsHD() {
+		changeOut, err := core.NewTXOutput(0, wallets.NewAddress(wallet.ChangeChain))
+		if err != nil {
+			log.Fatal(err)
+		}
+		changeScript = changeOut.ScriptPubKey
+		err = wallets.SaveToFile(nodeID)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 
-		newBlock := chain.MineBlock(txs)
-		UTXOSet.Update(newBlock)
-	} else {
-		node.SendTxToNode(tx)
+	tx, err := core.NewUTXOTransactionWithChange(&w, scriptPubKey, amount, fee, changeScript, &UTXOSet)
+	if err != nil {
+		log.Fatal(err)
 	}
 
+	cli.submitTx(chain, tx, fee, from, mineNow)
 	fmt.Println("Success!")
 }
 
-func (cli *CLI) createWallet(nodeID string) {
-	wallets, _ := wallet.NewWallets(nodeID)
-	address := wallets.CreateWallet()
-	wallets.SaveToFile(nodeID)
-
-	fmt.Printf("Your new address: %s\n", address)
-}
-
-func (cli *CLI) GetUTXODetails(nodeID string) {
-	chain := core.NewBlockChain(nodeID)
-	UTXOSet := core.UTXOSet{Blockchain: chain}
-	UTXOSet.GetUTXODetails()
-	//UTXOSet.Reindex()
-	//UTXOSet.GetUTXODetails()
+// createWallet adds a key to the wallet file. withMnemonic makes an HD wallet of it first,
+// its keys are derived from a new mnemonic that is printed once.
+func (cli *CLI) createWallet(nodeID string, withMnemonic bool) {
+	// only a missing wallet file starts a new wallet, any other error would overwrite the keys
+	wallets, err := cli.loadWallets(nodeID)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Fatal(err)
+	}
+	if withMnemonic {
+		mnemonic, err := wallet.NewMnemonic()
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = wallets.SetMnemonic(mnemonic)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Mnemonic: %s\n", mnemonic)
+		fmt.Println("Write it down and keep it safe, restorewallet recovers the keys of the wallet from it.")
+	}
+	address, err := wallets.CreateWallet()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = wallets.SaveToFile(nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Your new address: %s\n", address)
+	fmt.Printf("Public key: %x\n", wallets.Wallets[address].PublicKey)
+}
+
+// restoreWallet sets the seed of the mnemonic in the wallet file and scans the UTXO set
+// for the keys of its chains that own coins
+func (cli *CLI) restoreWallet(nodeID, mnemonic string) {
+	wallets, err := cli.loadWallets(nodeID)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Fatal(err)
+	}
+	err = wallets.SetMnemonic(mnemonic)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	chain, err := core.NewBlockChain(cli.Params, nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer chain.Close()
+	UTXOSet := core.UTXOSet{Blockchain: chain}
+	owners, err := UTXOSet.PubKeyHashes()
+	if err != nil {
+		log.Fatal(err)
+	}
+	found, err := wallets.Scan(func(pubKeyHash []byte) (bool, error) {
+		return owners[string(pubKeyHash)], nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	// the transactions of the keys found are indexed at the next listtransactions
+	wallets.ResetHistory()
+	err = wallets.SaveToFile(nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Restored the wallet, %d keys own coins\n", found)
+	fmt.Printf("Next receive key: %d, next change key: %d\n",
+		wallets.NextIndex[wallet.ReceiveChain], wallets.NextIndex[wallet.ChangeChain])
+}
+
+// encryptWallet encrypts the wallet file with the passphrase, an unencrypted wallet file is migrated
+func (cli *CLI) encryptWallet(nodeID, passphrase string) {
+	wallets, err := cli.loadWallets(nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = wallets.Encrypt(passphrase)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = wallets.SaveToFile(nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("The wallet file is encrypted, run the commands using its keys under walletpassphrase.")
+}
+
+// walletPassphrase runs the command of args with the encrypted wallet file of the node unlocked by the passphrase.
+// The key of the wallet file is never written to disk, the wallet is locked again when the command exits.
+func (cli *CLI) walletPassphrase(nodeID, passphrase string, args []string) {
+	wallets, err := wallet.NewWallets(nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = wallets.Unlock(passphrase)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cli.passphrase = passphrase
+	os.Args = append(os.Args[:1], args...)
+	cli.Run()
+}
+
+// loadWallets returns the wallet file of the node, unlocked when the command runs under walletpassphrase.
+// The error of NewWallets is returned as is, so a missing wallet file can be told apart.
+func (cli *CLI) loadWallets(nodeID string) (*wallet.Wallets, error) {
+	wallets, err := wallet.NewWallets(nodeID)
+	if err != nil || !wallets.IsLocked() || cli.passphrase == "" {
+		return wallets, err
+	}
+	return wallets, wallets.Unlock(cli.passphrase)
+}
+
+// dumpPrivKey prints the private key of the wallet address in the format importprivkey reads
+func (cli *CLI) dumpPrivKey(nodeID, address string) {
+	wallets, err := cli.loadWallets(nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	w, err := wallets.GetWallet(address)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(w.EncodePrivateKey())
+}
+
+// importPrivKey adds a private key exported by dumpprivkey to the wallet file.
+// rescan prints the coins of the key found in the UTXO set and indexes the transactions of the wallet again.
+func (cli *CLI) importPrivKey(nodeID, key string, rescan bool) {
+	w, err := wallet.DecodePrivateKey(key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	wallets, err := cli.loadWallets(nodeID)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Fatal(err)
+	}
+	address, err := wallets.ImportWallet(w)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if rescan {
+		wallets.ResetHistory()
+	}
+	err = wallets.SaveToFile(nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Imported address: %s\n", address)
+	if rescan {
+		cli.getBalance(address, nodeID)
+	}
+}
+
+// importPubKey adds the address of a public key to the watch-only addresses of the wallet file.
+// rescan prints the coins of the key found in the UTXO set and indexes the transactions of the wallet again.
+func (cli *CLI) importPubKey(nodeID, pubKeyHex string, rescan bool) {
+	pubKey, err := hex.DecodeString(pubKeyHex)
+	if err != nil {
+		log.Fatal(err)
+	}
+	wallets, err := cli.loadWallets(nodeID)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Fatal(err)
+	}
+	address, err := wallets.ImportPubKey(pubKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if rescan {
+		wallets.ResetHistory()
+	}
+	err = wallets.SaveToFile(nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Watch-only address: %s\n", address)
+	if rescan {
+		cli.getBalance(address, nodeID)
+	}
+}
+
+// importAddress adds an address to the watch-only addresses of the wallet file,
+// rescan indexes the transactions of the wallet again
+func (cli *CLI) importAddress(nodeID, address string, rescan bool) {
+	wallets, err := cli.loadWallets(nodeID)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Fatal(err)
+	}
+	err = wallets.ImportAddress(address)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if rescan {
+		wallets.ResetHistory()
+	}
+	err = wallets.SaveToFile(nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Watch-only address: %s\n", address)
+}
+
+// loadUnlockedWallets returns the wallet file of the node, its addresses cannot be listed while it is locked
+func (cli *CLI) loadUnlockedWallets(nodeID string) *wallet.Wallets {
+	wallets, err := cli.loadWallets(nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if wallets.IsLocked() {
+		log.Fatal(wallet.ErrWalletLocked)
+	}
+	return wallets
+}
+
+func (cli *CLI) listAddresses(nodeID string) {
+	wallets := cli.loadUnlockedWallets(nodeID)
+	for _, address := range wallets.ListAddresses() {
+		kind := "spendable"
+		if address.WatchOnly {
+			kind = "watch-only"
+		}
+		if label, ok := wallets.Labels[address.Address]; ok {
+			fmt.Printf("%s %s %q\n", address.Address, kind, label)
+		} else {
+			fmt.Printf("%s %s\n", address.Address, kind)
+		}
+	}
+}
+
+// listTransactions brings the transaction index of the wallet up to date with the chain
+// and prints its last count transactions touching the address, or all addresses when it is empty, in JSON
+func (cli *CLI) listTransactions(nodeID, address string, count int) {
+	if address != "" && !wallet.ValidateAddress(address) {
+		log.Panic("ERROR: Address is not valid")
+	}
+	wallets := cli.loadUnlockedWallets(nodeID)
+	chain, err := core.NewBlockChain(cli.Params, nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer chain.Close()
+
+	err = chain.SyncWalletHistory(wallets)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = wallets.SaveToFile(nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	height, err := chain.GetBestHeight()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	txs := wallets.ListTransactions(address, count, height)
+	if txs == nil {
+		txs = []wallet.WalletTx{}
+	}
+	out, err := json.MarshalIndent(txs, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(out))
+}
+
+// setLabel names an address of the wallet or of the address book, an empty label removes it
+func (cli *CLI) setLabel(nodeID, address, label string) {
+	wallets, err := cli.loadWallets(nodeID)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Fatal(err)
+	}
+	err = wallets.SetLabel(address, label)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = wallets.SaveToFile(nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// listLabels prints the address book: the labeled addresses, sorted by label
+func (cli *CLI) listLabels(nodeID string) {
+	wallets := cli.loadUnlockedWallets(nodeID)
+	var addresses []string
+	for address := range wallets.Labels {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool {
+		return wallets.Labels[addresses[i]] < wallets.Labels[addresses[j]]
+	})
+	for _, address := range addresses {
+		fmt.Printf("%q %s\n", wallets.Labels[address], address)
+	}
+}
+
+// getWalletBalance prints the balance of every address of the wallet file and their totals,
+// the coins of the watch-only addresses are added up apart
+func (cli *CLI) getWalletBalance(nodeID string) {
+	wallets := cli.loadUnlockedWallets(nodeID)
+	chain, err := core.NewBlockChain(cli.Params, nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer chain.Close()
+	UTXOSet := core.UTXOSet{Blockchain: chain}
+
+	addresses := wallets.ListAddresses()
+	var list []string
+	for _, address := range addresses {
+		list = append(list, address.Address)
+	}
+	balances, err := UTXOSet.GetBalances(list)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var total, watchOnly core.Balance
+	for _, address := range addresses {
+		balance := balances[address.Address]
+		kind := "spendable"
+		if address.WatchOnly {
+			kind = "watch-only"
+			watchOnly.Add(balance)
+		} else {
+			total.Add(balance)
+		}
+		fmt.Printf("%s (%s): confirmed %d, immature %d, locked %d, spendable %d\n", address.Address, kind,
+			balance.Confirmed, balance.Immature, balance.Locked, balance.Spendable)
+	}
+	fmt.Printf("Total: confirmed %d, immature %d, locked %d, spendable %d\n",
+		total.Confirmed, total.Immature, total.Locked, total.Spendable)
+	fmt.Printf("Watch-only total: confirmed %d, immature %d, locked %d, spendable %d\n",
+		watchOnly.Confirmed, watchOnly.Immature, watchOnly.Locked, watchOnly.Spendable)
+}
+
+// createMultiSig prints the address of the script requiring required signatures of the keys.
+// A key is either a public key in hex or the address of a wallet of the node.
+func (cli *CLI) createMultiSig(nodeID string, required int, keys []string) {
+	wallets, err := cli.loadWallets(nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var pubKeys [][]byte
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		if w, ok := wallets.Wallets[key]; ok {
+			pubKeys = append(pubKeys, w.PublicKey)
+			continue
+		}
+		pubKey, err := hex.DecodeString(key)
+		if err != nil {
+			log.Fatalf("ERROR: %s is neither a public key nor an address of the wallet", key)
+		}
+		pubKeys = append(pubKeys, pubKey)
+	}
+
+	script, err := core.MultiSigScript(required, pubKeys)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Multisig address: %s\n", wallet.ScriptAddress(script.Hash()))
+	fmt.Printf("Redeem script: %x\n", []byte(script))
+}
+
+func (cli *CLI) spendMultiSig(nodeID, redeemScript, to string, amount, fee int, file string) {
+	if !wallet.ValidateAddress(to) {
+		log.Panic("ERROR: Address is not valid")
+	}
+	script, err := hex.DecodeString(redeemScript)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	chain, err := core.NewBlockChain(cli.Params, nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	UTXOSet := core.UTXOSet{Blockchain: chain}
+	defer chain.Close()
+
+	tx, err := core.NewMultiSigTx(script, to, amount, fee, &UTXOSet)
+	if err != nil {
+		log.Fatal(err)
+	}
+	saveMultiSigTx(tx, file)
+	fmt.Printf("Unsigned transaction written to %s\n", file)
+}
+
+func (cli *CLI) signMultiSig(nodeID, file, address string) {
+	wallets, err := cli.loadWallets(nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	w, err := wallets.GetWallet(address)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	tx := loadMultiSigTx(file)
+	err = tx.Sign(w.PrivateKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	saveMultiSigTx(tx, file)
+
+	count, required, err := tx.SignatureCount()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Signatures: %d of %d\n", count, required)
+}
+
+func (cli *CLI) finalizeMultiSig(nodeID, file, minerAddress string) {
+	if minerAddress != "" && !wallet.ValidateAddress(minerAddress) {
+		log.Panic("ERROR: Address is not valid")
+	}
+	tx, err := loadMultiSigTx(file).Finalize()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	chain, err := core.NewBlockChain(cli.Params, nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer chain.Close()
+
+	fee, err := chain.TxFee(tx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cli.submitTx(chain, tx, fee, minerAddress, minerAddress != "")
+	fmt.Printf("Transaction %x sent\n", tx.ID)
+}
+
+func (cli *CLI) anchor(nodeID, from, dataHex string, fee int, mineNow bool) {
+	if !wallet.ValidateAddress(from) {
+		log.Panic("ERROR: Address is not valid")
+	}
+	data, err := hex.DecodeString(dataHex)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	chain, err := core.NewBlockChain(cli.Params, nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	UTXOSet := core.UTXOSet{Blockchain: chain}
+	defer chain.Close()
+
+	wallets, err := cli.loadWallets(nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	w, err := wallets.GetWallet(from)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	tx, err := core.NewDataTransaction(&w, data, fee, &UTXOSet)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cli.submitTx(chain, tx, fee, from, mineNow)
+	fmt.Printf("Data anchored by transaction %x\n", tx.ID)
+}
+
+func (cli *CLI) listAnchors(nodeID string, start, end int) {
+	chain, err := core.NewBlockChain(cli.Params, nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer chain.Close()
+
+	anchors, err := chain.FindAnchors(start, end)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, anchor := range anchors {
+		fmt.Printf("Height %d, time %s, transaction %x:%d: %x\n", anchor.Height,
+			time.Unix(anchor.Timestamp, 0).UTC().Format(time.RFC3339), anchor.Txid, anchor.Vout, anchor.Data)
+	}
+	fmt.Printf("%d anchors found\n", len(anchors))
+}
+
+func (cli *CLI) htlcCreate(nodeID, from, to string, amount, fee int, lockTime uint32, secretHash string, mineNow bool) {
+	refund, isScript, err := wallet.DecodeAddress(from)
+	if err != nil || isScript {
+		log.Panic("ERROR: Address is not valid")
+	}
+	recipient, isScript, err := wallet.DecodeAddress(to)
+	if err != nil || isScript {
+		log.Panic("ERROR: Address is not valid")
+	}
+
+	// the party generating the secret keeps it until it claims the contract of the other chain
+	var hash []byte
+	if secretHash == "" {
+		secret := make([]byte, sha256.Size)
+		_, err = rand.Read(secret)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sum := sha256.Sum256(secret)
+		hash = sum[:]
+		fmt.Printf("Secret: %x\n", secret)
+	} else {
+		hash, err = hex.DecodeString(secretHash)
+		if err != nil || len(hash) != sha256.Size {
+			log.Fatal("ERROR: Hash must be 32 bytes in hex")
+		}
+	}
+
+	chain, err := core.NewBlockChain(cli.Params, nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	UTXOSet := core.UTXOSet{Blockchain: chain}
+	defer chain.Close()
+
+	wallets, err := cli.loadWallets(nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	w, err := wallets.GetWallet(from)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	htlc := core.HTLC{SecretHash: hash, Recipient: recipient, Refund: refund, LockTime: lockTime}
+	tx, err := core.NewUTXOTransactionToScript(&w, htlc.Script(), amount, fee, &UTXOSet)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cli.submitTx(chain, tx, fee, from, mineNow)
+
+	fmt.Printf("Secret hash: %x\n", hash)
+	fmt.Printf("Contract: -txid %x -vout 0\n", tx.ID)
+}
+
+// htlcSpend claims the contract output with the secret, or refunds it when the secret is empty
+func (cli *CLI) htlcSpend(nodeID, address, txid string, vout int, secret string, fee int, mineNow bool) {
+	txID, err := hex.DecodeString(txid)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	chain, err := core.NewBlockChain(cli.Params, nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	UTXOSet := core.UTXOSet{Blockchain: chain}
+	defer chain.Close()
+
+	wallets, err := cli.loadWallets(nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	w, err := wallets.GetWallet(address)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var tx *core.Transaction
+	if secret != "" {
+		preimage, err := hex.DecodeString(secret)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tx, err = core.NewHTLCClaimTx(&w, core.OutPoint{Txid: txID, Index: vout}, preimage, fee, &UTXOSet)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		tx, err = core.NewHTLCRefundTx(&w, core.OutPoint{Txid: txID, Index: vout}, fee, &UTXOSet)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	cli.submitTx(chain, tx, fee, address, mineNow)
+	fmt.Printf("Transaction %x sent\n", tx.ID)
+}
+
+func (cli *CLI) htlcSecret(nodeID, txid string) {
+	txID, err := hex.DecodeString(txid)
+	if err != nil {
+		log.Fatal(err)
+	}
+	chain, err := core.NewBlockChain(cli.Params, nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer chain.Close()
+
+	tx, err := chain.FindTransaction(txID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	secret, ok := core.HTLCSecret(&tx)
+	if !ok {
+		log.Fatal("ERROR: Transaction does not claim a contract")
+	}
+	fmt.Printf("Secret: %x\n", secret)
+}
+
+// submitTx mines the transaction on the same node with the reward sent to minerAddress when mineNow is set,
+// otherwise it sends it to the node
+func (cli *CLI) submitTx(chain *core.Blockchain, tx *core.Transaction, fee int, minerAddress string, mineNow bool) {
+	if !mineNow {
+		node.SendTxToNode(cli.Params, tx)
+		return
+	}
+
+	// the miner of the block collects the fee
+	cbTx, err := chain.NewCoinbaseTx(minerAddress, fee)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = chain.MineBlock([]*core.Transaction{cbTx, tx})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func loadMultiSigTx(file string) *core.MultiSigTx {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var tx core.MultiSigTx
+	err = utils.Deserialize(data, &tx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return &tx
+}
+
+func saveMultiSigTx(tx *core.MultiSigTx, file string) {
+	data, err := utils.Serialize(tx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = os.WriteFile(file, data, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (cli *CLI) reindexTx(nodeID string, disable bool) {
+	chain, err := core.NewBlockChain(cli.Params, nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer chain.Close()
+
+	if disable {
+		err = chain.DropTxIndex()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Transaction index is disabled.")
+		return
+	}
+
+	err = chain.ReindexTransactions()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Done! The transaction index is enabled.")
+}
+
+func (cli *CLI) GetUTXODetails(nodeID string) {
+	chain, err := core.NewBlockChain(cli.Params, nodeID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer chain.Close()
+	UTXOSet := core.UTXOSet{Blockchain: chain}
+	err = UTXOSet.GetUTXODetails()
+	if err != nil {
+		log.Fatal(err)
+	}
+	//UTXOSet.Reindex()
+	//UTXOSet.GetUTXODetails()
 }
 
-func (cli *CLI) startNode(nodeID, minerAddress string) {
-	fmt.Printf("Starting node %s\n", nodeID)
+func (cli *CLI) startNode(nodeID, port, minerAddress string) {
+	if port == "" {
+		port = cli.Params.DefaultPort
+	}
+	fmt.Printf("Starting node %s on port %s of the %s network\n", nodeID, port, cli.Params.Name)
 	if len(minerAddress) > 0 {
 		if wallet.ValidateAddress(minerAddress) {
 			fmt.Println("Mining is on. Address to receive rewards: ", minerAddress)
@@ -236,20 +1482,47 @@ func (cli *CLI) startNode(nodeID, minerAddress string) {
 			log.Panic("Wrong miner address!")
 		}
 	}
-	node.StartServer(nodeID, minerAddress)
+	node.StartServer(cli.Params, nodeID, port, minerAddress)
 }
 
 func (cli *CLI) printUsage() {
 	fmt.Println("Usage:")
-	fmt.Println("  createblockchain -address ADDRESS - Create a core and send genesis block reward to ADDRESS")
-	fmt.Println("  createwallet - Generates a new key-pair and saves it into the wallet file")
-	fmt.Println("  getbalance -address ADDRESS - Get balance of ADDRESS")
-	fmt.Println("  listaddresses - Lists all addresses from the wallet file")
+	fmt.Println("  Every command accepts -net NETWORK to select the main, test, regtest or custom network, NETWORK env. var. sets the default")
+	fmt.Println("  createblockchain -address ADDRESS - Create the chain of the custom network and send its genesis block reward to ADDRESS. Refused on the main, test and regtest networks, nodes start from their hard-coded genesis block")
+	fmt.Println("  createwallet -mnemonic - Generates a new key-pair and saves it into the wallet file. The keys of an HD wallet are derived from its seed, -mnemonic makes an HD wallet from a new mnemonic.")
+	fmt.Println("  restorewallet -mnemonic MNEMONIC - Restore the HD wallet of MNEMONIC and find its keys owning coins")
+	fmt.Println("  encryptwallet -passphrase PASSPHRASE - Encrypt the wallet file with PASSPHRASE")
+	fmt.Println("  walletpassphrase -passphrase PASSPHRASE COMMAND [ARGS] - Run a single COMMAND with the encrypted wallet file of the node unlocked, its key is never written to disk")
+	fmt.Println("  dumpprivkey -address ADDRESS - Print the private key of the wallet address ADDRESS, in Base58")
+	fmt.Println("  importprivkey -key KEY -rescan - Add the private key KEY printed by dumpprivkey to the wallet file. Print its coins and index the transactions of the chain again, when -rescan is set.")
+	fmt.Println("  importpubkey -pubkey PUBKEY -rescan - Watch the address of the hex public key PUBKEY, without being able to spend its coins. Print its coins and index the transactions of the chain again, when -rescan is set.")
+	fmt.Println("  anchor -from FROM -data DATA -fee FEE -mine - Anchor the hex DATA, up to 80 bytes, in an unspendable output paid by FROM")
+	fmt.Println("  listanchors -start START -end END - List the data anchored in the blocks from height START to END")
+	fmt.Println("  htlc create -from FROM -to TO -amount AMOUNT -fee FEE -locktime LOCKTIME -hash HASH -mine - Lock AMOUNT in a contract TO can claim with the secret of HASH, or FROM can take back from the block height or unix time LOCKTIME. A secret is generated when -hash is not set.")
+	fmt.Println("  htlc claim -address ADDRESS -txid TXID -vout VOUT -secret SECRET -fee FEE -mine - Claim the contract output VOUT of TXID with SECRET, for the recipient ADDRESS")
+	fmt.Println("  htlc refund -address ADDRESS -txid TXID -vout VOUT -fee FEE -mine - Take back the contract output VOUT of TXID once its lock time is reached, for the funder ADDRESS")
+	fmt.Println("  htlc secret -txid TXID - Print the secret revealed by the transaction TXID claiming a contract")
+	fmt.Println("  createmultisig -required M -pubkeys KEYS - Create an address requiring M signatures of the comma separated public keys or wallet addresses KEYS")
+	fmt.Println("  spendmultisig -redeemscript SCRIPT -to TO -amount AMOUNT -fee FEE -file FILE - Write to FILE an unsigned transaction sending AMOUNT from the multisig address of SCRIPT to TO")
+	fmt.Println("  signmultisig -file FILE -address ADDRESS - Add the signature of the wallet key of ADDRESS to the multisig transaction in FILE")
+	fmt.Println("  finalizemultisig -file FILE -miner ADDRESS - Send the multisig transaction in FILE once it has enough signatures. Mine it on the same node and send the reward to ADDRESS, when -miner is set.")
+	fmt.Println("  getbalance -address ADDRESS - Get the confirmed, immature and spendable balance of ADDRESS")
+	fmt.Println("  generate -address ADDRESS -count COUNT - Mine COUNT blocks on the same node and send the rewards to ADDRESS. Rewards can be spent once mature.")
+	fmt.Println("  getblock -height HEIGHT | -hash HASH - Print the block of the main chain at HEIGHT, or the block with HASH")
+	fmt.Println("  listaddresses - Lists all addresses from the wallet file, spendable and watch-only")
+	fmt.Println("  importaddress -address ADDRESS -rescan - Watch ADDRESS, like a multisig address, without being able to spend its coins. Index the transactions of the chain again, when -rescan is set.")
+	fmt.Println("  getwalletbalance - Get the balance of every address of the wallet file and their total")
+	fmt.Println("  listtransactions -count COUNT -address ADDRESS - Print the COUNT most recent transactions of the wallet in JSON, only the ones touching ADDRESS when it is set")
+	fmt.Println("  setlabel -address ADDRESS -label LABEL - Name ADDRESS, of the wallet or of the address book. An empty LABEL removes it.")
+	fmt.Println("  listlabels - List the address book, the labeled addresses")
 	fmt.Println("  printchain - Print all the blocks of the core")
-	fmt.Println("  reindexutxo - Rebuilds the UTXO set")
+	fmt.Println("  reindextx -disable - Builds and enables the transaction index. Drops it, when -disable is set.")
 	fmt.Println("  getUTXODetails - Get UTXO Set details")
-	fmt.Println("  send -from FROM -to TO -amount AMOUNT -mine - Send AMOUNT of coins from FROM address to TO. Mine on the same node, when -mine is set.")
-	fmt.Println("  startnode -miner ADDRESS - Start a node with ID specified in NODE_ID env. var. -miner enables mining")
+	fmt.Println("  send -from FROM -to TO -amount AMOUNT -fee FEE -mine - Send AMOUNT of coins from FROM address to TO and pay FEE to the miner. Mine on the same node, when -mine is set.")
+	fmt.Println("    -lockuntil LOCKTIME - TO can only spend the coins from the block height LOCKTIME, or the unix time LOCKTIME from 500000000 on")
+	fmt.Println("    -lockblocks BLOCKS - TO can only spend the coins BLOCKS blocks after they are mined")
+	fmt.Println("  supply - Print the amount of coins issued up to the tip")
+	fmt.Println("  startnode -port PORT -miner ADDRESS - Start a node with ID specified in NODE_ID env. var. listening on PORT, the default port of the network (main 3000, test 13000, regtest 23000, custom 33000) makes it the central node. -miner enables mining")
 }
 
 func (cli *CLI) validateArgs() {
@@ -0,0 +1,63 @@
+package core
+
+import (
+	"blockchain-from-scratch/core/wallet"
+	"errors"
+	"fmt"
+)
+
+const (
+	// MaxDataCarrierSize is the maximum size of the data of a null data output, like Bitcoin.
+	// It is enough for a hash and some metadata.
+	MaxDataCarrierSize = 80
+	// maxNullDataScriptSize is the size of a null data script carrying MaxDataCarrierSize bytes:
+	// OP_RETURN OP_PUSHDATA1 <size> <data>
+	maxNullDataScriptSize = MaxDataCarrierSize + 3
+)
+
+var ErrDataTooLarge = errors.New("data output is too large")
+
+// Anchor is data carried by a null data output of the main chain,
+// the block it is in proves that the data existed at the time of the block
+type Anchor struct {
+	Height    int
+	BlockHash []byte
+	Timestamp int64
+	Txid      []byte
+	Vout      int
+	Data      []byte
+}
+
+// NewDataTransaction creates a signed transaction anchoring data in the chain with a null data output.
+// The wallet pays the fee, the rest of its inputs goes back to it.
+func NewDataTransaction(nodeWallet *wallet.Wallet, data []byte, fee int, UTXOSet *UTXOSet) (*Transaction, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: no data to anchor", ErrBadScript)
+	}
+	if len(data) > MaxDataCarrierSize {
+		return nil, fmt.Errorf("%w: %d bytes, the limit is %d", ErrDataTooLarge, len(data), MaxDataCarrierSize)
+	}
+	return NewUTXOTransactionToScript(nodeWallet, NullDataScript(data), 0, fee, UTXOSet)
+}
+
+// FindAnchors returns the data anchored in the blocks of the main chain from height from to height to included,
+// oldest first. A negative to goes up to the tip.
+func (bc *Blockchain) FindAnchors(from, to int) ([]Anchor, error) {
+	var anchors []Anchor
+	err := bc.ForEachBlock(from, to, func(block *Block) error {
+		for _, tx := range block.Transactions {
+			for outIdx, out := range tx.VOut {
+				data, ok := out.ScriptPubKey.NullData()
+				if !ok || len(data) == 0 {
+					continue
+				}
+				anchors = append(anchors, Anchor{block.Height, block.Hash, block.Timestamp, tx.ID, outIdx, data})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return anchors, nil
+}
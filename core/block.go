@@ -1,56 +1,99 @@
 package core
 
 import (
+	"fmt"
 	"github.com/sirupsen/logrus"
-	"log"
 	"time"
 )
 
+const blockVersion = 1
+
+// BlockHeader holds the fields of a block that are hashed by the proof of work.
+// The transactions are committed through MerkleRoot, so a header can be synced and
+// verified without the block body.
+type BlockHeader struct {
+	Version int32
+	// hash of the previous block header
+	PrevBlockHash []byte
+	// root of the Merkle tree of the block transactions
+	MerkleRoot []byte
+	Timestamp  int64
+	// Bits is the compact representation of the target the block hash must be lower than
+	Bits   uint32
+	Nonce  int
+	Height int
+}
+
 // Block represents a block in the core
 type Block struct {
-	Timestamp    int64
+	BlockHeader
+	// Hash is the hash of the block header
+	Hash         []byte
 	Transactions []*Transaction
-	// hash of the previous block header
-	PrevBlockHash []byte
-	Hash          []byte
-	Nonce         int
-	Height        int
 }
 
-func NewBlock(transactions []*Transaction, prevBlockHash []byte, height int) *Block {
-	block := &Block{time.Now().Unix(), transactions, prevBlockHash, []byte{}, 0, height}
+// blockBody is what is stored for a block besides its header
+type blockBody struct {
+	Transactions []*Transaction
+}
+
+func NewBlock(transactions []*Transaction, prevBlockHash []byte, height int, bits uint32) (*Block, error) {
+	block := &Block{
+		BlockHeader: BlockHeader{
+			Version:       blockVersion,
+			PrevBlockHash: prevBlockHash,
+			Timestamp:     time.Now().Unix(),
+			Bits:          bits,
+			Height:        height,
+		},
+		Transactions: transactions,
+	}
+	merkleRoot, err := block.HashTransactions()
+	if err != nil {
+		return nil, err
+	}
+	block.MerkleRoot = merkleRoot
 
 	// use consensus to generate hash and nonce
 	// TODO can be extend more consensus mechanism
-	pow := NewProofOfWork(block)
+	pow := NewProofOfWork(&block.BlockHeader)
 	nonce, hash, err := pow.Run()
 	if err != nil {
-		log.Panic(err)
+		return nil, fmt.Errorf("mine block at height %d: %w", height, err)
 	}
 	block.Hash = hash[:]
 	block.Nonce = nonce
-	return block
+	return block, nil
 }
 
-// NewGenesisBlock when the chain created, init GenesisBlock
-func NewGenesisBlock(coinbase *Transaction) *Block {
+// NewGenesisBlock when the chain created, init GenesisBlock with the easiest difficulty of the network
+func NewGenesisBlock(coinbase *Transaction, bits uint32) (*Block, error) {
 	logrus.Info("No existing blockchain found. Creating a new one...")
-	return NewBlock([]*Transaction{coinbase}, []byte{}, 0)
+	return NewBlock([]*Transaction{coinbase}, []byte{}, 0, bits)
+}
+
+// BlockHash returns the hash of the header, which is the hash of the block
+func (h *BlockHeader) BlockHash() ([]byte, error) {
+	return NewProofOfWork(h).Hash()
 }
 
-// HashTransactions TODO can use Merkle tree hash
+// HashTransactions returns the Merkle root of the block transactions.
 // Bitcoin uses a more elaborate technique: it represents all transactions containing in a block as a Merkle tree
 // and uses the root hash of the tree in the Proof-of-Work system.
 // This approach allows to quickly check if a block contains certain transaction,
 // having only just the root hash and without downloading all the transactions.
-func (b *Block) HashTransactions() []byte {
+func (b *Block) HashTransactions() ([]byte, error) {
 	var txHashs [][]byte
 	// var txHash [32]byte
 
 	for _, tx := range b.Transactions {
-		txHashs = append(txHashs, tx.Serialize())
+		data, err := tx.Serialize()
+		if err != nil {
+			return nil, err
+		}
+		txHashs = append(txHashs, data)
 	}
 	mTres := NewMerkleTree(txHashs)
 	// txHash = sha256.Sum256(bytes.Join(txHashs, []byte{}))
-	return mTres.RootNode.Data
+	return mTres.RootNode.Data, nil
 }
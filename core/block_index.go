@@ -0,0 +1,110 @@
+package core
+
+import (
+	"blockchain-from-scratch/utils"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+const blockIndexBucket = "blockindex"
+const headersBucket = "headers"
+
+// blockIndex is the metadata kept for every known header, in the main chain or in a side branch
+type blockIndex struct {
+	Height int
+	// ChainWork is the total work of the chain up to and including this block
+	ChainWork []byte
+}
+
+func (idx blockIndex) work() *big.Int {
+	return new(big.Int).SetBytes(idx.ChainWork)
+}
+
+func getBlockIndex(tx StoreTx, hash []byte) (*blockIndex, error) {
+	data := tx.Bucket(blockIndexBucket).Get(hash)
+	if data == nil {
+		return nil, errors.New("block index is not found")
+	}
+
+	var idx blockIndex
+	err := utils.Deserialize(data, &idx)
+	if err != nil {
+		return nil, err
+	}
+	return &idx, nil
+}
+
+func putBlockIndex(tx StoreTx, hash []byte, idx *blockIndex) error {
+	data, err := utils.Serialize(idx)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(blockIndexBucket).Put(hash, data)
+}
+
+func getHeader(tx StoreTx, hash []byte) (*BlockHeader, error) {
+	data := tx.Bucket(headersBucket).Get(hash)
+	if data == nil {
+		return nil, fmt.Errorf("%w: %x", ErrHeaderNotFound, hash)
+	}
+
+	var header BlockHeader
+	err := utils.Deserialize(data, &header)
+	if err != nil {
+		return nil, err
+	}
+	return &header, nil
+}
+
+func putHeader(tx StoreTx, hash []byte, header *BlockHeader) error {
+	data, err := utils.Serialize(header)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(headersBucket).Put(hash, data)
+}
+
+// hasBlock reports whether the body of the block is stored, not only its header
+func hasBlock(tx StoreTx, hash []byte) bool {
+	return tx.Bucket(blocksBucket).Get(hash) != nil
+}
+
+// getBlock assembles a block from its header and its body, which are stored in different buckets
+func getBlock(tx StoreTx, hash []byte) (*Block, error) {
+	blockData := tx.Bucket(blocksBucket).Get(hash)
+	if blockData == nil {
+		return nil, fmt.Errorf("%w: %x", ErrBlockNotFound, hash)
+	}
+	header, err := getHeader(tx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	var body blockBody
+	err = utils.Deserialize(blockData, &body)
+	if err != nil {
+		return nil, err
+	}
+	return &Block{*header, hash, body.Transactions}, nil
+}
+
+// putBlock stores the header and the body of the block
+func putBlock(tx StoreTx, block *Block) error {
+	err := putHeader(tx, block.Hash, &block.BlockHeader)
+	if err != nil {
+		return err
+	}
+	data, err := utils.Serialize(blockBody{block.Transactions})
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(blocksBucket).Put(block.Hash, data)
+}
+
+// headerGetter adapts getHeader to the lookups done by the difficulty and median time calculations
+func headerGetter(tx StoreTx) func(hash []byte) (*BlockHeader, error) {
+	return func(hash []byte) (*BlockHeader, error) {
+		return getHeader(tx, hash)
+	}
+}
@@ -1,292 +1,742 @@
 package core
 
 import (
-	"blockchain-from-scratch/utils"
 	"bytes"
 	"crypto/ecdsa"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/sirupsen/logrus"
-	"log"
+	"math/big"
 	"os"
-
-	"github.com/boltdb/bolt"
+	"sort"
 )
 
 const dbFile = "blockchain_%s.db"
 const blocksBucket = "blocks"
-const genesisCoinbaseData = "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks"
 
 type Blockchain struct {
 	//blocks []*Block
 	tip []byte
-	// TODO to support more db
-	Db *bolt.DB
+	// Store is the database of the chain, see Store for the buckets it holds
+	Store Store
+	// Mempool holds the transactions waiting to be mined, it is updated when blocks are connected or disconnected
+	Mempool *Mempool
+	// Params are the consensus rules of the chain
+	Params *ChainParams
 }
 
-// MineBlock mines a new block with the provided transactions
-func (chain *Blockchain) MineBlock(transactions []*Transaction) *Block {
-	for _, tx := range transactions {
-		if !chain.VerifyTransaction(tx) {
-			log.Panic("Error: Invalid transaction")
+// MineBlock mines a new block with the provided transactions, a transaction may spend the outputs of the previous ones
+func (chain *Blockchain) MineBlock(transactions []*Transaction) (*Block, error) {
+	err := chain.Store.View(func(dbTx StoreTx) error {
+		spendHeight, err := nextHeight(dbTx)
+		if err != nil {
+			return err
 		}
+		medianTime, err := medianTimeOf(dbTx, chain.tip)
+		if err != nil {
+			return err
+		}
+		pending := make(map[string]Transaction)
+		spent := make(map[string]bool)
+		for _, tx := range transactions {
+			if tx.IsCoinbase() {
+				continue
+			}
+			_, err = chain.checkNextBlockTx(dbTx, spendHeight, medianTime, tx, pending, spent)
+			if err != nil {
+				return fmt.Errorf("transaction %x is invalid: %w", tx.ID, err)
+			}
+			pending[hex.EncodeToString(tx.ID)] = *tx
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// get last hash from db
+	// get last block header from db
 	var lastHash []byte
-	var lastHeight int
-	err := chain.Db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		lastHash = b.Get([]byte("l"))
-		blockData := b.Get(lastHash)
-		var block Block
-		utils.Deserialize(blockData, &block)
-		lastHeight = block.Height
-		return nil
+	var lastHeader *BlockHeader
+	err = chain.Store.View(func(tx StoreTx) error {
+		lastHash = getTip(tx)
+		var err error
+		lastHeader, err = getHeader(tx, lastHash)
+		return err
 	})
 	if err != nil {
-		log.Panic(err)
+		return nil, err
+	}
+
+	bits, err := chain.CalcNextRequiredBits(lastHeader)
+	if err != nil {
+		return nil, err
 	}
 
 	// create new block and store
-	newBlock := NewBlock(transactions, lastHash, lastHeight+1)
-	err = chain.Db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		err = b.Put(newBlock.Hash, utils.Serialize(newBlock))
-		err = b.Put([]byte("l"), newBlock.Hash)
-		chain.tip = newBlock.Hash
-		return nil
-	})
+	newBlock, err := NewBlock(transactions, lastHash, lastHeader.Height+1, bits)
+	if err != nil {
+		return nil, err
+	}
+	err = chain.AddBlock(newBlock)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 	logrus.Info("New block is mined")
-	return newBlock
+	return newBlock, nil
 }
 
-// AddBlock saves the block into the blockchain
-func (bc *Blockchain) AddBlock(block *Block) {
-	err := bc.Db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(blocksBucket))
-		blockInDb := bucket.Get(block.Hash)
-		if blockInDb != nil {
+// AddBlock saves the block into the blockchain.
+// Every block whose parent is known is stored, so side branches are tracked as well.
+// The main chain is the one with the most accumulated work: if the new block's branch has more work
+// than the current tip, the chain is reorganized to it and the chainstate and mempool are updated.
+func (bc *Blockchain) AddBlock(block *Block) error {
+	var newTip []byte
+	var disconnected, connected []*Block
+
+	err := bc.Store.Update(func(tx StoreTx) error {
+		if hasBlock(tx, block.Hash) {
 			return nil
 		}
 
-		blockData := utils.Serialize(block)
-		err := bucket.Put(block.Hash, blockData)
+		err := validateBlock(tx, bc.Params, block)
 		if err != nil {
-			log.Panic(err)
+			return err
+		}
+		parentIndex, err := getBlockIndex(tx, block.PrevBlockHash)
+		if err != nil {
+			return err
 		}
 
 		// Get last block from chain
-		var lastBlock Block
-		lastHash := bucket.Get([]byte("l"))
-		utils.Deserialize(bucket.Get(lastHash), &lastBlock)
-
-		// if block height higher, update blockchain lastblock
-		if block.Height > lastBlock.Height {
-			err = bucket.Put([]byte("l"), block.Hash)
-			bc.tip = block.Hash
+		lastHash := getTip(tx)
+		extendsTip := bytes.Equal(block.PrevBlockHash, lastHash)
+		if extendsTip {
+			err = checkBlockTransactions(tx, bc.Params, block)
 			if err != nil {
-				log.Panic(err)
+				return err
 			}
 		}
+
+		err = putBlock(tx, block)
+		if err != nil {
+			return err
+		}
+
+		chainWork := new(big.Int).Add(parentIndex.work(), CalcWork(block.Bits))
+		err = putBlockIndex(tx, block.Hash, &blockIndex{block.Height, chainWork.Bytes()})
+		if err != nil {
+			return err
+		}
+
+		tipIndex, err := getBlockIndex(tx, lastHash)
+		if err != nil {
+			return err
+		}
+
+		// only switch to the block's branch if it has more accumulated work
+		if chainWork.Cmp(tipIndex.work()) <= 0 {
+			logrus.Infof("Block %x is stored in a side branch", block.Hash)
+			return nil
+		}
+
+		if extendsTip {
+			err = connectBlock(tx, block)
+			connected = []*Block{block}
+		} else {
+			disconnected, connected, err = reorganize(tx, bc.Params, lastHash, block)
+		}
+		if err != nil {
+			return err
+		}
+
+		err = putTip(tx, block.Hash)
+		if err != nil {
+			return err
+		}
+		newTip = block.Hash
 		return nil
 	})
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
+
+	if newTip != nil {
+		bc.tip = newTip
+		bc.Mempool.applyChainUpdate(disconnected, connected)
+	}
+	return nil
 }
 
-// CreateBlockchain creates a new core DB
-func CreateBlockchain(address, nodeId string) *Blockchain {
-	dbFile := fmt.Sprintf(dbFile, nodeId)
-	if dbExists(dbFile) {
-		fmt.Println("Blockchain already exists.")
-		os.Exit(1)
+// reorganize switches the main chain from oldTip to the branch ending with newTip.
+// It walks both branches back to their common ancestor and returns the blocks that left
+// the main chain (tip first) and the blocks that joined it (ancestor first).
+func reorganize(tx StoreTx, params *ChainParams, oldTip []byte, newTip *Block) ([]*Block, []*Block, error) {
+	var disconnected, connected []*Block
+
+	oldBlock, err := getBlock(tx, oldTip)
+	if err != nil {
+		return nil, nil, err
+	}
+	newBlock := newTip
+
+	for !bytes.Equal(oldBlock.Hash, newBlock.Hash) {
+		if oldBlock.Height >= newBlock.Height {
+			disconnected = append(disconnected, oldBlock)
+			oldBlock, err = getBlock(tx, oldBlock.PrevBlockHash)
+		} else {
+			connected = append([]*Block{newBlock}, connected...)
+			newBlock, err = getBlock(tx, newBlock.PrevBlockHash)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
-	coinbaseTx := NewCoinbaseTx(address, genesisCoinbaseData)
-	//utils.PrintJsonLog(coinbaseTx, "coinbaseTx")
-	genesisBlock := NewGenesisBlock(coinbaseTx)
+	logrus.Infof("Reorganize chain at fork %x: disconnect %d blocks, connect %d blocks",
+		oldBlock.Hash, len(disconnected), len(connected))
+
+	// walk the chainstate back to the fork point with the undo records of the disconnected blocks
+	for _, block := range disconnected {
+		err = disconnectBlock(tx, block)
+		if err != nil {
+			return nil, nil, fmt.Errorf("disconnect block %x: %w", block.Hash, err)
+		}
+	}
+
+	// the transactions of the new branch are verified as its blocks are connected,
+	// if one of them is invalid the whole reorganization is rolled back
+	for _, block := range connected {
+		err = checkBlockTransactions(tx, params, block)
+		if err != nil {
+			return nil, nil, fmt.Errorf("connect block %x: %w", block.Hash, err)
+		}
+		err = connectBlock(tx, block)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return disconnected, connected, nil
+}
+
+// connectBlock applies a block joining the main chain to the chainstate and the indexes
+func connectBlock(tx StoreTx, block *Block) error {
+	err := updateUTXO(tx, block)
+	if err != nil {
+		return err
+	}
+	err = indexHeight(tx, block)
+	if err != nil {
+		return err
+	}
+	return indexTransactions(tx, block)
+}
+
+// disconnectBlock reverts connectBlock for a block leaving the main chain
+func disconnectBlock(tx StoreTx, block *Block) error {
+	err := disconnectUTXO(tx, block)
+	if err != nil {
+		return err
+	}
+	err = unindexHeight(tx, block)
+	if err != nil {
+		return err
+	}
+	return unindexTransactions(tx, block)
+}
+
+// CreateBlockchain creates the db of a custom private chain whose genesis block pays its reward to address,
+// other nodes join it by copying the db file. It returns ErrChainExists if the node already has one.
+// The networks with a hard-coded genesis block start from it, ErrHardCodedGenesis is returned for them,
+// see NewBlockChain.
+func CreateBlockchain(params *ChainParams, address, nodeId string) (*Blockchain, error) {
+	if params.GenesisHash != "" {
+		return nil, fmt.Errorf("%w: %s", ErrHardCodedGenesis, params.Name)
+	}
+	dbFile := dbFileName(params, nodeId)
+	if dbExists(dbFile) {
+		return nil, fmt.Errorf("%w: %s", ErrChainExists, dbFile)
+	}
 
-	var tip []byte
 	// Open a DB file.
-	db, err := bolt.Open(dbFile, 0600, nil)
+	store, err := OpenBoltStore(dbFile)
+	if err != nil {
+		return nil, err
+	}
+	bc, err := CreateBlockchainWithStore(store, params, address)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	return bc, nil
+}
+
+// CreateBlockchainWithStore creates a custom chain with the given parameters in an empty store,
+// its genesis block is mined now and pays its reward to address.
+// Use it with NewMemoryStore to run a chain without a db file.
+// It returns ErrHardCodedGenesis for a network with a hard-coded genesis block.
+func CreateBlockchainWithStore(store Store, params *ChainParams, address string) (*Blockchain, error) {
+	if params.GenesisHash != "" {
+		return nil, fmt.Errorf("%w: %s", ErrHardCodedGenesis, params.Name)
+	}
+	coinbaseTx, err := NewCoinbaseTx(address, params.GenesisCoinbaseData, params.CalcBlockSubsidy(0))
+	if err != nil {
+		return nil, err
+	}
+	//utils.PrintJsonLog(coinbaseTx, "coinbaseTx")
+	genesisBlock, err := NewGenesisBlock(coinbaseTx, params.PowLimitBits())
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
+	return createBlockchain(store, params, genesisBlock)
+}
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		b, err := tx.CreateBucket([]byte(blocksBucket))
+// createBlockchain stores the genesis block and connects it
+func createBlockchain(store Store, params *ChainParams, genesisBlock *Block) (*Blockchain, error) {
+	err := store.Update(func(tx StoreTx) error {
+		err := putNetwork(tx, params)
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
-		err = b.Put(genesisBlock.Hash, utils.Serialize(genesisBlock))
+		err = putBlock(tx, genesisBlock)
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
-		err = b.Put([]byte("l"), genesisBlock.Hash)
+		err = putBlockIndex(tx, genesisBlock.Hash, &blockIndex{0, CalcWork(genesisBlock.Bits).Bytes()})
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
-		tip = genesisBlock.Hash
-		return nil
+		err = connectBlock(tx, genesisBlock)
+		if err != nil {
+			return err
+		}
+		return putTip(tx, genesisBlock.Hash)
 	})
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
-	return &Blockchain{tip, db}
+	return &Blockchain{genesisBlock.Hash, store, NewMempool(), params}, nil
 }
 
-func NewBlockChain(nodeId string) *Blockchain {
-	dbFile := fmt.Sprintf(dbFile, nodeId)
-	if !dbExists(dbFile) {
-		fmt.Println("No existing blockchain found. Create one first.")
-		os.Exit(1)
+// NewBlockChain opens the chain of the node on the network. A node without a db file starts from
+// the hard-coded genesis block of the network, so every node is on the same chain from the start.
+// It returns ErrChainNotFound for a custom network that has no chain yet.
+func NewBlockChain(params *ChainParams, nodeId string) (*Blockchain, error) {
+	dbFile := dbFileName(params, nodeId)
+	if !dbExists(dbFile) && params.GenesisHash == "" {
+		return nil, fmt.Errorf("%w: %s", ErrChainNotFound, dbFile)
 	}
 
-	var tip []byte
 	// Open a DB file.
-	db, err := bolt.Open(dbFile, 0600, nil)
+	store, err := OpenBoltStore(dbFile)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
-	err = db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		tip = b.Get([]byte("l"))
-		return nil
+	bc, err := InitBlockchainWithStore(store, params)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	return bc, nil
+}
+
+// InitBlockchainWithStore opens the chain kept in the store, an empty store is initialized
+// with the hard-coded genesis block of the network
+func InitBlockchainWithStore(store Store, params *ChainParams) (*Blockchain, error) {
+	bc, err := NewBlockchainWithStore(store, params)
+	if !errors.Is(err, ErrChainNotFound) || params.GenesisHash == "" {
+		return bc, err
+	}
+
+	logrus.Infof("No existing blockchain found. Starting from the %s genesis block...", params.Name)
+	genesisBlock, err := params.GenesisBlock()
+	if err != nil {
+		return nil, err
+	}
+	return createBlockchain(store, params, genesisBlock)
+}
+
+// NewBlockchainWithStore opens the chain kept in the store, it must have been created with the same parameters,
+// it returns ErrWrongNetwork if it was created for another network and ErrBadGenesis if it does not start
+// from the hard-coded genesis block of the network
+func NewBlockchainWithStore(store Store, params *ChainParams) (*Blockchain, error) {
+	var tip []byte
+	err := store.Update(func(tx StoreTx) error {
+		tip = getTip(tx)
+		if tip == nil {
+			return ErrChainNotFound
+		}
+		err := checkNetwork(tx, params)
+		if err != nil {
+			return err
+		}
+
+		// chains created before the height index was added get it built once
+		tipHeader, err := getHeader(tx, tip)
+		if err != nil {
+			return err
+		}
+		if _, err = getHashByHeight(tx, tipHeader.Height); err != nil {
+			err = reindexHeights(tx, tip)
+			if err != nil {
+				return err
+			}
+		}
+		return checkGenesis(tx, params)
 	})
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
-	return &Blockchain{tip, db}
+	return &Blockchain{tip, store, NewMempool(), params}, nil
+}
+
+// Close closes the underlying store
+func (bc *Blockchain) Close() error {
+	return bc.Store.Close()
 }
 
-func (chain *Blockchain) FindUTXO() map[string]TXOutputs {
-	UTXO := make(map[string]TXOutputs)
-	spentTXOs := make(map[string][]int)
-	iterator := chain.Iterator()
+// FindUTXO walks the whole chain to collect its unspent outputs, use UTXOSet for fast lookups
+func (chain *Blockchain) FindUTXO() ([]UTXO, error) {
+	var UTXOs []UTXO
+	err := chain.Store.View(func(tx StoreTx) error {
+		var err error
+		UTXOs, err = findUTXO(tx, chain.tip)
+		return err
+	})
+	return UTXOs, err
+}
+
+// findUTXO collects the unspent outputs of the chain ending with tip
+func findUTXO(dbTx StoreTx, tip []byte) ([]UTXO, error) {
+	var UTXOs []UTXO
+	spentTXOs := make(map[string]bool)
+	currentHash := tip
 
 	for {
-		block := iterator.Next()
+		block, err := getBlock(dbTx, currentHash)
+		if err != nil {
+			return nil, err
+		}
 
-		for _, tx := range block.Transactions {
-			txId := hex.EncodeToString(tx.ID)
+		// blocks are visited from the tip, so an output is always seen after the inputs spending it
+		for i := len(block.Transactions) - 1; i >= 0; i-- {
+			tx := block.Transactions[i]
 
-		Outputs:
 			for outIdx, out := range tx.VOut {
-				{
-					// Check if the output was spent
-					if spentTXOs[txId] != nil {
-						for _, spentOut := range spentTXOs[txId] {
-							if spentOut == outIdx {
-								continue Outputs // Skip if the output was spent
-							}
-						}
-					}
-
-					outs := UTXO[txId]
-					outs.Outputs = append(outs.Outputs, out)
-					UTXO[txId] = outs
+				outPoint := OutPoint{tx.ID, outIdx}
+				// Skip if the output was spent or can never be
+				if spentTXOs[outPoint.String()] || out.ScriptPubKey.IsUnspendable() {
+					continue
 				}
+				UTXOs = append(UTXOs, UTXO{outPoint, newUTXOEntry(out, block.Height, tx.IsCoinbase())})
+			}
 
-				if !tx.IsCoinbase() {
-					for _, in := range tx.Vin {
-						inTxId := hex.EncodeToString(in.Txid)
-						spentTXOs[inTxId] = append(spentTXOs[inTxId], in.Vout)
-					}
+			if !tx.IsCoinbase() {
+				for _, in := range tx.Vin {
+					spentTXOs[OutPoint{in.Txid, in.Vout}.String()] = true
 				}
 			}
-
 		}
 		// Break the loop if the genesis block is reached
 		if len(block.PrevBlockHash) == 0 {
 			break
 		}
+		currentHash = block.PrevBlockHash
 	}
-	return UTXO
+	return UTXOs, nil
 }
 
 // Iterator returns a BlockchainIterator to iterate over the blocks of the core
 func (chain *Blockchain) Iterator() *BlockchainIterator {
-	return &BlockchainIterator{chain.tip, chain.Db}
+	return &BlockchainIterator{chain.tip, chain.Store}
 }
 
 func (chain *Blockchain) FindTransaction(ID []byte) (Transaction, error) {
-	iterator := chain.Iterator()
+	var transaction Transaction
+	err := chain.Store.View(func(tx StoreTx) error {
+		found, err := findTransaction(tx, chain.tip, ID)
+		if err != nil {
+			return err
+		}
+		transaction = *found
+		return nil
+	})
+	return transaction, err
+}
+
+// findTransaction looks for a transaction in the chain ending with the block fromHash.
+// When the transaction index is enabled it is used instead of walking the chain, fromHash must then
+// be the last block connected to the main chain.
+func findTransaction(dbTx StoreTx, fromHash []byte, ID []byte) (*Transaction, error) {
+	if txIndexEnabled(dbTx) {
+		return lookupTransaction(dbTx, ID)
+	}
+	currentHash := fromHash
 
 	for {
-		block := iterator.Next()
+		block, err := getBlock(dbTx, currentHash)
+		if err != nil {
+			return nil, err
+		}
 		for _, tx := range block.Transactions {
 			if bytes.Equal(tx.ID, ID) {
-				return *tx, nil
+				return tx, nil
 			}
 		}
 		if len(block.PrevBlockHash) == 0 {
 			break
 		}
+		currentHash = block.PrevBlockHash
 	}
-	return Transaction{}, errors.New("transaction is not found")
+	return nil, fmt.Errorf("%w: %x", ErrTxNotFound, ID)
 }
 
-func (chain *Blockchain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) {
+// SignTransaction signs the inputs of the transaction, the transactions they spend must be in the chain
+func (chain *Blockchain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) error {
 	prevTXs := make(map[string]Transaction)
 
 	for _, vin := range tx.Vin {
 		prevTx, err := chain.FindTransaction(vin.Txid)
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
 		prevTXs[hex.EncodeToString(vin.Txid)] = prevTx
 	}
-	tx.Sign(privKey, prevTXs)
+	return tx.Sign(privKey, prevTXs)
+}
+
+// VerifyTransaction checks the transaction against the current UTXO set:
+// its inputs must be unspent, their unlocking scripts must satisfy the outputs they spend
+// and its lock times must be reached in the next block
+func (chain *Blockchain) VerifyTransaction(tx *Transaction) error {
+	_, err := chain.TxFee(tx)
+	return err
 }
 
-func (chain *Blockchain) VerifyTransaction(tx *Transaction) bool {
+// NewCoinbaseTx creates the coinbase of the next block mined on the tip: it pays the subsidy
+// at that height plus the fees of the block's transactions to the address
+func (bc *Blockchain) NewCoinbaseTx(to string, fees int) (*Transaction, error) {
+	height, err := bc.GetBestHeight()
+	if err != nil {
+		return nil, err
+	}
+	reward, err := addValue(bc.Params.CalcBlockSubsidy(height+1), fees)
+	if err != nil {
+		return nil, err
+	}
+	return NewCoinbaseTx(to, "", reward)
+}
+
+// Supply returns the amount of coins created by the subsidy of the main chain
+func (bc *Blockchain) Supply() (int, error) {
+	height, err := bc.GetBestHeight()
+	if err != nil {
+		return 0, err
+	}
+	return bc.Params.CalcSupply(height), nil
+}
+
+// TxFee verifies the transaction like VerifyTransaction and returns its fee, the value
+// of its inputs minus the value of its outputs. A coinbase has no fee.
+func (chain *Blockchain) TxFee(tx *Transaction) (int, error) {
 	if tx.IsCoinbase() {
-		return true
+		return 0, nil
 	}
 	//utils.PrintJsonLog(tx, "VerifyTransaction")
-	prevTXs := make(map[string]Transaction)
-	for _, vin := range tx.Vin {
-		prevTx, err := chain.FindTransaction(vin.Txid)
+	fee := 0
+	err := chain.Store.View(func(dbTx StoreTx) error {
+		err := CheckTransactionSanity(chain.Params, tx)
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
-		prevTXs[hex.EncodeToString(vin.Txid)] = prevTx
+		// the transaction can at best be mined in the next block
+		spendHeight, err := nextHeight(dbTx)
+		if err != nil {
+			return err
+		}
+		medianTime, err := medianTimeOf(dbTx, chain.tip)
+		if err != nil {
+			return err
+		}
+		err = checkFinalTx(tx, spendHeight, medianTime)
+		if err != nil {
+			return err
+		}
+		fee, err = checkTransactionInputs(dbTx, chain.Params, chain.tip, spendHeight, medianTime, tx, map[string]Transaction{}, map[string]bool{})
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("transaction %x is invalid: %w", tx.ID, err)
 	}
-	return tx.Verify(prevTXs)
+	return fee, nil
 }
 
-// GetBestHeight returns the height of the latest block
-func (bc *Blockchain) GetBestHeight() int {
-	var lastBlock Block
-
-	err := bc.Db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(blocksBucket))
-		lastHash := bucket.Get([]byte("l"))
-		bucketData := bucket.Get(lastHash)
-		utils.Deserialize(bucketData, &lastBlock)
+// AcceptTransaction puts the transaction into the mempool unless it cannot be mined in the next block
+// because of its lock times. Its inputs may spend other transactions of the mempool,
+// so they are only verified when the transaction is mined.
+func (chain *Blockchain) AcceptTransaction(tx *Transaction) error {
+	err := chain.Store.View(func(dbTx StoreTx) error {
+		err := CheckTransactionSanity(chain.Params, tx)
+		if err != nil {
+			return err
+		}
+		spendHeight, err := nextHeight(dbTx)
+		if err != nil {
+			return err
+		}
+		medianTime, err := medianTimeOf(dbTx, chain.tip)
+		if err != nil {
+			return err
+		}
+		err = checkFinalTx(tx, spendHeight, medianTime)
+		if err != nil {
+			return err
+		}
+
+		for inIdx, in := range tx.Vin {
+			// the output of a transaction of the mempool gets at best mined in the next block too
+			coinHeight := spendHeight
+			entry, err := getUTXO(dbTx, OutPoint{in.Txid, in.Vout})
+			if err == nil {
+				coinHeight = entry.Height
+			}
+			err = checkSequenceLock(dbTx, tx, inIdx, coinHeight, spendHeight, medianTime)
+			if err != nil {
+				return err
+			}
+		}
 		return nil
 	})
+	if err != nil {
+		return fmt.Errorf("transaction %x is rejected: %w", tx.ID, err)
+	}
+	chain.Mempool.Add(*tx)
+	return nil
+}
 
+// SelectTransactions picks the transactions of the mempool that can be mined together in the next block
+// and returns them with the sum of their fees. A transaction spending the output of another one of the mempool
+// comes after it, and of two transactions spending the same output only the first one is picked.
+// The transactions that can never be mined, because they are invalid or conflict with a picked one,
+// are removed from the mempool. The ones waiting for their lock times stay in it.
+func (chain *Blockchain) SelectTransactions() ([]*Transaction, int, error) {
+	candidates := chain.Mempool.Transactions()
+	// the order of the mempool is random, pick conflicting transactions the same way every time
+	sort.Slice(candidates, func(i, j int) bool { return bytes.Compare(candidates[i].ID, candidates[j].ID) < 0 })
+
+	var selected []*Transaction
+	var evicted [][]byte
+	fees := 0
+	err := chain.Store.View(func(dbTx StoreTx) error {
+		spendHeight, err := nextHeight(dbTx)
+		if err != nil {
+			return err
+		}
+		medianTime, err := medianTimeOf(dbTx, chain.tip)
+		if err != nil {
+			return err
+		}
+
+		pending := make(map[string]Transaction)
+		spent := make(map[string]bool)
+		// a pass picks the transactions whose inputs are known, the next ones the transactions spending them
+		for len(candidates) > 0 {
+			var waiting []Transaction
+			for _, tx := range candidates {
+				fee, err := chain.checkNextBlockTx(dbTx, spendHeight, medianTime, &tx, pending, spent)
+				switch {
+				case err == nil:
+					fees, err = addValue(fees, fee)
+					if err != nil {
+						return err
+					}
+					selected = append(selected, &tx)
+					pending[hex.EncodeToString(tx.ID)] = tx
+				case errors.Is(err, ErrMissingTxOut):
+					waiting = append(waiting, tx)
+				case errors.Is(err, ErrNonFinalTx), errors.Is(err, ErrSequenceLocked), errors.Is(err, ErrImmatureSpend):
+					logrus.Infof("Keep transaction %x in the mempool: %s", tx.ID, err)
+				default:
+					logrus.Warnf("Evict transaction %x from the mempool: %s", tx.ID, err)
+					evicted = append(evicted, tx.ID)
+				}
+			}
+			if len(waiting) == len(candidates) {
+				// no output they spend is left, they spend ones that are spent or never existed
+				for _, tx := range waiting {
+					logrus.Warnf("Evict transaction %x from the mempool: it spends a missing output", tx.ID)
+					evicted = append(evicted, tx.ID)
+				}
+				break
+			}
+			candidates = waiting
+		}
+		return nil
+	})
 	if err != nil {
-		log.Panic(err)
+		return nil, 0, err
+	}
+	for _, txID := range evicted {
+		chain.Mempool.Remove(txID)
 	}
+	return selected, fees, nil
+}
 
-	return lastBlock.Height
+// checkNextBlockTx checks a transaction of the next block against the chainstate, the previous transactions
+// of the block in pending and the outputs they spend in spent. spent is only updated when the transaction is valid.
+func (chain *Blockchain) checkNextBlockTx(dbTx StoreTx, spendHeight int, medianTime int64, tx *Transaction, pending map[string]Transaction, spent map[string]bool) (int, error) {
+	err := CheckTransactionSanity(chain.Params, tx)
+	if err != nil {
+		return 0, err
+	}
+	err = checkFinalTx(tx, spendHeight, medianTime)
+	if err != nil {
+		return 0, err
+	}
+	for _, in := range tx.Vin {
+		if key := (OutPoint{in.Txid, in.Vout}).String(); spent[key] {
+			return 0, fmt.Errorf("%w: %s", ErrDoubleSpend, key)
+		}
+	}
+	txSpent := make(map[string]bool)
+	fee, err := checkTransactionInputs(dbTx, chain.Params, chain.tip, spendHeight, medianTime, tx, pending, txSpent)
+	if err != nil {
+		return 0, err
+	}
+	for key := range txSpent {
+		spent[key] = true
+	}
+	return fee, nil
+}
+
+// GetBestHeight returns the height of the latest block
+func (bc *Blockchain) GetBestHeight() (int, error) {
+	var lastHeader *BlockHeader
+
+	err := bc.Store.View(func(tx StoreTx) error {
+		lastHash := getTip(tx)
+		var err error
+		lastHeader, err = getHeader(tx, lastHash)
+		return err
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return lastHeader.Height, nil
 }
 
 // GetBlockHashes returns a list of hashes of all the blocks in the chain
-func (bc *Blockchain) GetBlockHashes() [][]byte {
+func (bc *Blockchain) GetBlockHashes() ([][]byte, error) {
 	var blocks [][]byte
 	bci := bc.Iterator()
 
 	for {
-		block := bci.Next()
+		block, err := bci.Next()
+		if err != nil {
+			return nil, err
+		}
 
 		blocks = append(blocks, block.Hash)
 
@@ -295,30 +745,195 @@ func (bc *Blockchain) GetBlockHashes() [][]byte {
 		}
 	}
 
-	return blocks
+	return blocks, nil
 }
 
 // GetBlock finds a block by its hash and returns it
 func (bc *Blockchain) GetBlock(blockHash []byte) (Block, error) {
 	var block Block
 
-	err := bc.Db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
+	err := bc.Store.View(func(tx StoreTx) error {
+		found, err := getBlock(tx, blockHash)
+		if err != nil {
+			return err
+		}
+		block = *found
+		return nil
+	})
+	if err != nil {
+		return block, err
+	}
+
+	return block, nil
+}
+
+// GetHeader finds a block header by its hash, the body of the block may not be known yet
+func (bc *Blockchain) GetHeader(blockHash []byte) (BlockHeader, error) {
+	var header BlockHeader
+
+	err := bc.Store.View(func(tx StoreTx) error {
+		found, err := getHeader(tx, blockHash)
+		if err != nil {
+			return err
+		}
+		header = *found
+		return nil
+	})
+	return header, err
+}
+
+// HasBlock reports whether the full block, not only its header, is stored
+func (bc *Blockchain) HasBlock(blockHash []byte) (bool, error) {
+	found := false
+	err := bc.Store.View(func(tx StoreTx) error {
+		found = hasBlock(tx, blockHash)
+		return nil
+	})
+	return found, err
+}
+
+// AddHeader validates a header received without its body and stores it,
+// so the chain of headers can be synced and checked before the blocks are downloaded
+func (bc *Blockchain) AddHeader(header *BlockHeader, hash []byte) error {
+	return bc.Store.Update(func(tx StoreTx) error {
+		if _, err := getHeader(tx, hash); err == nil {
+			return nil
+		}
+
+		err := CheckBlockHeader(bc.Params, header, hash)
+		if err != nil {
+			return err
+		}
+		err = validateHeaderContext(tx, bc.Params, header)
+		if err != nil {
+			return err
+		}
+
+		parentIndex, err := getBlockIndex(tx, header.PrevBlockHash)
+		if err != nil {
+			return err
+		}
+		err = putHeader(tx, hash, header)
+		if err != nil {
+			return err
+		}
+		chainWork := new(big.Int).Add(parentIndex.work(), CalcWork(header.Bits))
+		return putBlockIndex(tx, hash, &blockIndex{header.Height, chainWork.Bytes()})
+	})
+}
 
-		blockData := b.Get(blockHash)
+// GetHeaders returns the headers of the main chain that follow the first locator hash found in it,
+// oldest first and at most max of them. Locator hashes are ordered from the tip of the requester.
+func (bc *Blockchain) GetHeaders(locator [][]byte, max int) ([]BlockHeader, [][]byte, error) {
+	var headers []BlockHeader
+	var hashes [][]byte
 
-		if blockData == nil {
-			return errors.New("Block is not found.")
+	err := bc.Store.View(func(tx StoreTx) error {
+		known := make(map[string]bool)
+		for _, hash := range locator {
+			known[hex.EncodeToString(hash)] = true
 		}
 
-		utils.Deserialize(blockData, &block)
+		currentHash := getTip(tx)
+		for {
+			if known[hex.EncodeToString(currentHash)] {
+				break
+			}
+			header, err := getHeader(tx, currentHash)
+			if err != nil {
+				return err
+			}
+			headers = append([]BlockHeader{*header}, headers...)
+			hashes = append([][]byte{currentHash}, hashes...)
+			if len(header.PrevBlockHash) == 0 {
+				break
+			}
+			currentHash = header.PrevBlockHash
+		}
 		return nil
 	})
 	if err != nil {
-		return block, err
+		return nil, nil, err
 	}
 
-	return block, nil
+	if len(headers) > max {
+		headers, hashes = headers[:max], hashes[:max]
+	}
+	return headers, hashes, nil
+}
+
+// BlockLocator returns hashes of the main chain from the tip back to genesis, dense near the tip
+// and exponentially sparser further back, so a peer can find the fork point in a few lookups
+func (bc *Blockchain) BlockLocator() ([][]byte, error) {
+	var locator [][]byte
+
+	err := bc.Store.View(func(tx StoreTx) error {
+		currentHash := getTip(tx)
+		step := 1
+		for {
+			locator = append(locator, currentHash)
+			if len(locator) >= 10 {
+				step *= 2
+			}
+
+			// walk back step blocks, the genesis block always ends the locator
+			for i := 0; i < step; i++ {
+				header, err := getHeader(tx, currentHash)
+				if err != nil {
+					return err
+				}
+				if len(header.PrevBlockHash) == 0 {
+					if i > 0 {
+						locator = append(locator, currentHash)
+					}
+					return nil
+				}
+				currentHash = header.PrevBlockHash
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return locator, nil
+}
+
+// CalcNextRequiredBits returns the compact target that a block built on top of prev must use
+func (bc *Blockchain) CalcNextRequiredBits(prev *BlockHeader) (uint32, error) {
+	var bits uint32
+	err := bc.Store.View(func(tx StoreTx) error {
+		var err error
+		bits, err = calcNextRequiredBits(bc.Params, prev, headerGetter(tx))
+		return err
+	})
+	return bits, err
+}
+
+// ValidateProofOfWork checks that the block uses the difficulty expected at its height
+// and that its hash satisfies it
+func (bc *Blockchain) ValidateProofOfWork(block *Block) bool {
+	var prev *BlockHeader
+	if len(block.PrevBlockHash) != 0 {
+		parent, err := bc.GetHeader(block.PrevBlockHash)
+		if err != nil {
+			return false
+		}
+		prev = &parent
+	}
+
+	expectedBits, err := bc.CalcNextRequiredBits(prev)
+	if err != nil {
+		return false
+	}
+	return NewProofOfWork(&block.BlockHeader).Validate(expectedBits)
+}
+
+// dbFileName returns the db file of the node, the main network keeps the name used before networks existed
+func dbFileName(params *ChainParams, nodeId string) string {
+	if params.Name == MainNetParams.Name {
+		return fmt.Sprintf(dbFile, nodeId)
+	}
+	return fmt.Sprintf(dbFile, params.Name+"_"+nodeId)
 }
 
 func dbExists(dbFile string) bool {
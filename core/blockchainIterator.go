@@ -1,28 +1,23 @@
 package core
 
-import (
-	"blockchain-from-scratch/utils"
-	"github.com/boltdb/bolt"
-	"log"
-)
-
 type BlockchainIterator struct {
 	currentHash []byte
-	db          *bolt.DB
+	store       Store
 }
 
-func (i *BlockchainIterator) Next() *Block {
+// Next returns the current block and moves the iterator to its parent
+func (i *BlockchainIterator) Next() (*Block, error) {
 	var block *Block
 
-	err := i.db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(blocksBucket))
-		utils.Deserialize(bucket.Get(i.currentHash), &block)
-		return nil
+	err := i.store.View(func(tx StoreTx) error {
+		var err error
+		block, err = getBlock(tx, i.currentHash)
+		return err
 	})
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
 	i.currentHash = block.PrevBlockHash
-	return block
+	return block, nil
 }
@@ -0,0 +1,123 @@
+package core
+
+import (
+	"math/big"
+)
+
+// the adjustment is limited to a factor of 4 in each direction to avoid huge swings
+const retargetAdjustmentFactor = 4
+
+// CompactToBig converts a compact representation of a 256-bit target to a big.Int.
+// The compact format is the same "nBits" format Bitcoin stores in block headers:
+// the high byte is an exponent (number of bytes of the target) and the low 3 bytes are the mantissa.
+//
+//	target = mantissa * 256^(exponent-3)
+func CompactToBig(compact uint32) *big.Int {
+	mantissa := compact & 0x007fffff
+	isNegative := compact&0x00800000 != 0
+	exponent := uint(compact >> 24)
+
+	var bn *big.Int
+	if exponent <= 3 {
+		mantissa >>= 8 * (3 - exponent)
+		bn = big.NewInt(int64(mantissa))
+	} else {
+		bn = big.NewInt(int64(mantissa))
+		bn.Lsh(bn, 8*(exponent-3))
+	}
+
+	if isNegative {
+		bn = bn.Neg(bn)
+	}
+	return bn
+}
+
+// BigToCompact converts a target to its compact representation, see CompactToBig.
+func BigToCompact(n *big.Int) uint32 {
+	if n.Sign() == 0 {
+		return 0
+	}
+
+	var mantissa uint32
+	exponent := uint(len(n.Bytes()))
+	if exponent <= 3 {
+		mantissa = uint32(n.Bits()[0])
+		mantissa <<= 8 * (3 - exponent)
+	} else {
+		tn := new(big.Int).Set(n)
+		mantissa = uint32(tn.Rsh(tn, 8*(exponent-3)).Bits()[0])
+	}
+
+	// the 0x00800000 bit is the sign bit, so if it is already set,
+	// divide the mantissa by 256 and increase the exponent
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	compact := uint32(exponent<<24) | mantissa
+	if n.Sign() < 0 {
+		compact |= 0x00800000
+	}
+	return compact
+}
+
+// calcNextRequiredBits calculates the compact target a block built on top of prev must use.
+// The difficulty only changes every RetargetInterval blocks: the time it took to mine the last
+// interval is compared with the expected timespan and the previous target is scaled accordingly.
+// getHeader is used to look up the first block of the interval by walking back the parents of prev.
+func calcNextRequiredBits(params *ChainParams, prev *BlockHeader, getHeader func(hash []byte) (*BlockHeader, error)) (uint32, error) {
+	// the genesis block uses the easiest difficulty
+	if prev == nil || params.NoRetargeting {
+		return params.PowLimitBits(), nil
+	}
+
+	height := prev.Height + 1
+	if height%params.RetargetInterval != 0 {
+		return prev.Bits, nil
+	}
+
+	// find the first block of the interval
+	first := prev
+	for i := 0; i < params.RetargetInterval-1; i++ {
+		parent, err := getHeader(first.PrevBlockHash)
+		if err != nil {
+			return 0, err
+		}
+		first = parent
+	}
+
+	targetTimespan := params.targetTimespan()
+	actualTimespan := prev.Timestamp - first.Timestamp
+	minTimespan := targetTimespan / retargetAdjustmentFactor
+	maxTimespan := targetTimespan * retargetAdjustmentFactor
+	if actualTimespan < minTimespan {
+		actualTimespan = minTimespan
+	} else if actualTimespan > maxTimespan {
+		actualTimespan = maxTimespan
+	}
+
+	// newTarget = oldTarget * actualTimespan / targetTimespan
+	newTarget := CompactToBig(prev.Bits)
+	newTarget.Mul(newTarget, big.NewInt(actualTimespan))
+	newTarget.Div(newTarget, big.NewInt(targetTimespan))
+	if newTarget.Cmp(params.PowLimit) > 0 {
+		newTarget.Set(params.PowLimit)
+	}
+
+	return BigToCompact(newTarget), nil
+}
+
+// CalcWork returns the expected number of hashes needed to mine a block with the given compact target.
+// Fork choice compares the sum of the work of all blocks of a branch, not its length:
+//
+//	work = 2^256 / (target + 1)
+func CalcWork(bits uint32) *big.Int {
+	target := CompactToBig(bits)
+	if target.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+
+	denominator := new(big.Int).Add(target, big.NewInt(1))
+	return new(big.Int).Div(new(big.Int).Lsh(big.NewInt(1), 256), denominator)
+}
@@ -0,0 +1,20 @@
+package core
+
+import "errors"
+
+// Errors returned by the Blockchain and UTXOSet APIs, they are wrapped with details
+// so callers should match them with errors.Is
+var (
+	ErrChainExists       = errors.New("blockchain already exists")
+	ErrChainNotFound     = errors.New("no existing blockchain found, create one first")
+	ErrBlockNotFound     = errors.New("block is not found")
+	ErrHeaderNotFound    = errors.New("block header is not found")
+	ErrTxNotFound        = errors.New("transaction is not found")
+	ErrUTXONotFound      = errors.New("output is not found in the UTXO set")
+	ErrInsufficientFunds = errors.New("not enough funds")
+	ErrUnknownNetwork    = errors.New("unknown network")
+	ErrWrongNetwork      = errors.New("blockchain belongs to another network")
+	ErrNoGenesis         = errors.New("network has no hard-coded genesis block")
+	ErrBadGenesis        = errors.New("genesis block does not match the network")
+	ErrHardCodedGenesis  = errors.New("network has a hard-coded genesis block, it cannot be created")
+)
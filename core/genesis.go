@@ -0,0 +1,68 @@
+package core
+
+import (
+	"blockchain-from-scratch/core/wallet"
+	"encoding/hex"
+	"fmt"
+)
+
+// checkGenesis returns ErrBadGenesis if the chain does not start from the hard-coded genesis block of the network.
+// The genesis block of a custom network is the one its db was created with.
+func checkGenesis(tx StoreTx, params *ChainParams) error {
+	if params.GenesisHash == "" {
+		return nil
+	}
+	hash, err := getHashByHeight(tx, 0)
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(hash) != params.GenesisHash {
+		return fmt.Errorf("%w: %x, want %s", ErrBadGenesis, hash, params.GenesisHash)
+	}
+	return nil
+}
+
+// GenesisBlock returns the hard-coded genesis block of the network, every node builds the same one
+// from the parameters so they all start on the same chain. The reward pays a key hash derived from
+// the coinbase message, nobody holds its private key. Custom networks have no hard-coded genesis,
+// ErrNoGenesis is returned for them.
+func (p *ChainParams) GenesisBlock() (*Block, error) {
+	if p.GenesisHash == "" {
+		return nil, fmt.Errorf("%w: %s", ErrNoGenesis, p.Name)
+	}
+
+	txin := TxInput{[]byte{}, -1, Script(p.GenesisCoinbaseData), SequenceFinal}
+	txout := TxOutput{p.CalcBlockSubsidy(0), PayToPubKeyHashScript(wallet.HashPubKey([]byte(p.GenesisCoinbaseData)))}
+	coinbase := &Transaction{nil, []TxInput{txin}, []TxOutput{txout}, 0}
+	var err error
+	coinbase.ID, err = coinbase.Hash()
+	if err != nil {
+		return nil, err
+	}
+
+	block := &Block{
+		BlockHeader: BlockHeader{
+			Version:       blockVersion,
+			PrevBlockHash: []byte{},
+			Timestamp:     p.GenesisTimestamp,
+			Bits:          p.PowLimitBits(),
+			Nonce:         p.GenesisNonce,
+			Height:        0,
+		},
+		Transactions: []*Transaction{coinbase},
+	}
+	block.MerkleRoot, err = block.HashTransactions()
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := block.BlockHash()
+	if err != nil {
+		return nil, err
+	}
+	if hex.EncodeToString(hash) != p.GenesisHash {
+		return nil, fmt.Errorf("%w: %x, want %s", ErrBadGenesis, hash, p.GenesisHash)
+	}
+	block.Hash = hash
+	return block, nil
+}
@@ -0,0 +1,108 @@
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// heightIndexBucket maps the height of every block of the main chain to its hash.
+// Keys are big endian so iterating the bucket visits the blocks from genesis to the tip.
+const heightIndexBucket = "heightindex"
+
+func heightKey(height int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(height))
+	return key
+}
+
+func getHashByHeight(tx StoreTx, height int) ([]byte, error) {
+	hash := tx.Bucket(heightIndexBucket).Get(heightKey(height))
+	if hash == nil {
+		return nil, fmt.Errorf("%w: no block at height %d", ErrBlockNotFound, height)
+	}
+	return hash, nil
+}
+
+// indexHeight records a block connected to the main chain
+func indexHeight(tx StoreTx, block *Block) error {
+	return tx.Bucket(heightIndexBucket).Put(heightKey(block.Height), block.Hash)
+}
+
+// unindexHeight removes a block disconnected from the main chain
+func unindexHeight(tx StoreTx, block *Block) error {
+	return tx.Bucket(heightIndexBucket).Delete(heightKey(block.Height))
+}
+
+// reindexHeights rebuilds the height index from the headers of the chain ending with tip
+func reindexHeights(tx StoreTx, tip []byte) error {
+	err := tx.DeleteBucket(heightIndexBucket)
+	if err != nil {
+		return err
+	}
+	b := tx.Bucket(heightIndexBucket)
+
+	currentHash := tip
+	for {
+		header, err := getHeader(tx, currentHash)
+		if err != nil {
+			return err
+		}
+		err = b.Put(heightKey(header.Height), currentHash)
+		if err != nil {
+			return err
+		}
+		if len(header.PrevBlockHash) == 0 {
+			return nil
+		}
+		currentHash = header.PrevBlockHash
+	}
+}
+
+// GetBlockByHeight returns the block of the main chain at the given height
+func (bc *Blockchain) GetBlockByHeight(height int) (Block, error) {
+	var block Block
+	err := bc.Store.View(func(tx StoreTx) error {
+		hash, err := getHashByHeight(tx, height)
+		if err != nil {
+			return err
+		}
+		found, err := getBlock(tx, hash)
+		if err != nil {
+			return err
+		}
+		block = *found
+		return nil
+	})
+	return block, err
+}
+
+// ForEachBlock calls fn for the blocks of the main chain from height from to height to included,
+// oldest first. A negative to goes up to the tip. Iteration stops at the first error returned by fn.
+// The blocks are read in a single transaction, so fn must not modify the chain.
+func (bc *Blockchain) ForEachBlock(from, to int, fn func(block *Block) error) error {
+	return bc.Store.View(func(tx StoreTx) error {
+		tipHeader, err := getHeader(tx, getTip(tx))
+		if err != nil {
+			return err
+		}
+		if to < 0 || to > tipHeader.Height {
+			to = tipHeader.Height
+		}
+
+		for height := from; height <= to; height++ {
+			hash, err := getHashByHeight(tx, height)
+			if err != nil {
+				return err
+			}
+			block, err := getBlock(tx, hash)
+			if err != nil {
+				return err
+			}
+			err = fn(block)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,151 @@
+package core
+
+import (
+	"blockchain-from-scratch/core/wallet"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrBadSecret    = errors.New("secret does not match the hash of the contract")
+	ErrNotHTLCParty = errors.New("key is not the one of the contract party")
+)
+
+// HTLC is a hash time-locked contract: the recipient can spend the output by revealing the secret
+// whose SHA-256 is SecretHash, or the refund owner can take it back once LockTime is reached.
+// Two of them locked with the same hash on two chains make an atomic swap: claiming one reveals
+// the secret that claims the other.
+type HTLC struct {
+	SecretHash []byte
+	// Recipient is the public key hash of the party claiming the output with the secret
+	Recipient []byte
+	// Refund is the public key hash of the party taking the output back after LockTime
+	Refund []byte
+	// LockTime is a block height, or a unix time from LockTimeThreshold on
+	LockTime uint32
+}
+
+// Script returns the locking script of the contract:
+//
+//	OP_IF
+//		OP_SHA256 <secretHash> OP_EQUALVERIFY OP_DUP OP_HASH160 <recipient>
+//	OP_ELSE
+//		<lockTime> OP_CHECKLOCKTIMEVERIFY OP_DROP OP_DUP OP_HASH160 <refund>
+//	OP_ENDIF
+//	OP_EQUALVERIFY OP_CHECKSIG
+//
+// It is claimed with <sig> <pubKey> <secret> OP_1 and refunded with <sig> <pubKey> OP_0.
+func (h HTLC) Script() Script {
+	return NewScriptBuilder().
+		AddOp(OpIf).
+		AddOp(OpSHA256).AddData(h.SecretHash).AddOp(OpEqualVerify).AddOp(OpDup).AddOp(OpHash160).AddData(h.Recipient).
+		AddOp(OpElse).
+		AddInt64(int64(h.LockTime)).AddOp(OpCheckLockTimeVerify).AddOp(OpDrop).AddOp(OpDup).AddOp(OpHash160).AddData(h.Refund).
+		AddOp(OpEndIf).
+		AddOp(OpEqualVerify).AddOp(OpCheckSig).Script()
+}
+
+// ParseHTLCScript returns the contract of an HTLCClass script
+func ParseHTLCScript(script Script) (*HTLC, error) {
+	ops, err := parseScript(script)
+	if err != nil {
+		return nil, err
+	}
+	if len(ops) != 17 || len(ops[2].data) != sha256.Size || len(ops[6].data) != 20 || len(ops[13].data) != 20 || !ops[8].isPush() {
+		return nil, fmt.Errorf("%w: %s is not a hash time-locked contract", ErrBadScript, script)
+	}
+	lockTime, err := lockNum(ops[8])
+	if err != nil || lockTime > math.MaxUint32 {
+		return nil, fmt.Errorf("%w: %s is not a hash time-locked contract", ErrBadScript, script)
+	}
+
+	h := &HTLC{ops[2].data, ops[6].data, ops[13].data, uint32(lockTime)}
+	// the opcodes around the values must be the ones of the template
+	if !bytes.Equal(h.Script(), script) {
+		return nil, fmt.Errorf("%w: %s is not a hash time-locked contract", ErrBadScript, script)
+	}
+	return h, nil
+}
+
+// NewHTLCClaimTx creates the transaction spending the contract output with the secret,
+// paying its value minus the fee to the recipient. The wallet must hold the key of the recipient.
+func NewHTLCClaimTx(nodeWallet *wallet.Wallet, outPoint OutPoint, secret []byte, fee int, UTXOSet *UTXOSet) (*Transaction, error) {
+	return newHTLCSpend(nodeWallet, outPoint, secret, fee, UTXOSet)
+}
+
+// NewHTLCRefundTx creates the transaction taking the contract output back once its lock time is reached,
+// paying its value minus the fee to the refund owner. The wallet must hold the key of the refund owner.
+func NewHTLCRefundTx(nodeWallet *wallet.Wallet, outPoint OutPoint, fee int, UTXOSet *UTXOSet) (*Transaction, error) {
+	return newHTLCSpend(nodeWallet, outPoint, nil, fee, UTXOSet)
+}
+
+// newHTLCSpend claims the contract output when secret is set, otherwise it refunds it
+func newHTLCSpend(nodeWallet *wallet.Wallet, outPoint OutPoint, secret []byte, fee int, UTXOSet *UTXOSet) (*Transaction, error) {
+	entry, err := UTXOSet.GetUTXO(outPoint)
+	if err != nil {
+		return nil, err
+	}
+	h, err := ParseHTLCScript(entry.ScriptPubKey)
+	if err != nil {
+		return nil, err
+	}
+	if fee < 0 || fee > entry.Value {
+		return nil, fmt.Errorf("%w: fee %d for an output of %d", ErrBadTxOutValue, fee, entry.Value)
+	}
+
+	claim := secret != nil
+	owner, sequence, lockTime := h.Refund, SequenceFinal-1, h.LockTime
+	if claim {
+		if hash := sha256.Sum256(secret); !bytes.Equal(hash[:], h.SecretHash) {
+			return nil, fmt.Errorf("%w: %x", ErrBadSecret, h.SecretHash)
+		}
+		owner, sequence, lockTime = h.Recipient, SequenceFinal, 0
+	}
+	pubKeyHash := wallet.HashPubKey(nodeWallet.PublicKey)
+	if !bytes.Equal(pubKeyHash, owner) {
+		return nil, fmt.Errorf("%w: %x", ErrNotHTLCParty, pubKeyHash)
+	}
+
+	logrus.Infof("Spend HTLC %s to '%s' with fee %d, claim %t", outPoint, nodeWallet.GetAddress(), fee, claim)
+	tx := &Transaction{
+		nil,
+		[]TxInput{{outPoint.Txid, outPoint.Index, nil, sequence}},
+		[]TxOutput{{entry.Value - fee, PayToPubKeyHashScript(pubKeyHash)}},
+		lockTime,
+	}
+	tx.ID, err = tx.Hash()
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := tx.signInput(0, entry.ScriptPubKey, nodeWallet.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	b := NewScriptBuilder().AddData(sig).AddData(nodeWallet.PublicKey)
+	if claim {
+		b.AddData(secret).AddSmallInt(1)
+	} else {
+		b.AddSmallInt(0)
+	}
+	tx.Vin[0].ScriptSig = b.Script()
+	return tx, nil
+}
+
+// HTLCSecret returns the secret revealed by an input of the transaction claiming a contract,
+// it is how the other party of an atomic swap learns it.
+func HTLCSecret(tx *Transaction) ([]byte, bool) {
+	for _, vin := range tx.Vin {
+		ops, err := parseScript(vin.ScriptSig)
+		if err != nil || len(ops) != 4 || ops[3].opcode != Op1 || len(ops[2].data) == 0 {
+			continue
+		}
+		return ops[2].data, true
+	}
+	return nil, false
+}
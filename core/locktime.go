@@ -0,0 +1,118 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+// LockTimeThreshold splits lock times like Bitcoin: below it they are block heights, from it on unix times
+const LockTimeThreshold = 500000000
+
+// Sequence values of the inputs, their meaning follows BIP 68 without the transaction version
+const (
+	// SequenceFinal disables the relative lock of the input,
+	// when every input has it the lock time of the transaction is disabled as well
+	SequenceFinal uint32 = 0xffffffff
+	// SequenceLockTimeDisabled disables the relative lock of the input
+	SequenceLockTimeDisabled uint32 = 1 << 31
+	// SequenceLockTimeIsSeconds makes the relative lock a time in units of 512 seconds instead of a number of blocks
+	SequenceLockTimeIsSeconds uint32 = 1 << 22
+	// SequenceLockTimeMask keeps the value of the relative lock
+	SequenceLockTimeMask uint32 = 0x0000ffff
+	// SequenceLockTimeGranularity converts a relative lock time to seconds, it is shifted left by it
+	SequenceLockTimeGranularity = 9
+)
+
+var (
+	ErrNonFinalTx     = errors.New("transaction lock time is not reached")
+	ErrSequenceLocked = errors.New("transaction input relative lock is not reached")
+)
+
+// IsFinal reports whether the transaction can be included in a block at height, medianTime is the
+// median time past of the previous block. Like Bitcoin, the lock time only applies when an input is not final.
+func (tx *Transaction) IsFinal(height int, medianTime int64) bool {
+	if tx.LockTime == 0 || lockTimeReached(tx.LockTime, height, medianTime) {
+		return true
+	}
+	for _, in := range tx.Vin {
+		if in.Sequence != SequenceFinal {
+			return false
+		}
+	}
+	return true
+}
+
+// lockTimeReached reports whether a block at height, after blocks of median time medianTime, is past lockTime
+func lockTimeReached(lockTime uint32, height int, medianTime int64) bool {
+	if lockTime < LockTimeThreshold {
+		return int64(lockTime) < int64(height)
+	}
+	return int64(lockTime) < medianTime
+}
+
+// checkFinalTx returns ErrNonFinalTx if the transaction cannot be included in a block at height
+func checkFinalTx(tx *Transaction, height int, medianTime int64) error {
+	if !tx.IsFinal(height, medianTime) {
+		return fmt.Errorf("%w: transaction %x is locked until %d", ErrNonFinalTx, tx.ID, tx.LockTime)
+	}
+	return nil
+}
+
+// sequenceLockReached reports whether the relative lock sequence of an input spending an output created
+// at coinHeight is reached by a block at spendHeight, after blocks of median time medianTime.
+// A time lock starts at the median time past of the block before the output's one.
+// The height index must cover the chain up to spendHeight-1.
+func sequenceLockReached(dbTx StoreTx, sequence uint32, coinHeight, spendHeight int, medianTime int64) (bool, error) {
+	if sequence&SequenceLockTimeDisabled != 0 {
+		return true, nil
+	}
+	lock := int64(sequence & SequenceLockTimeMask)
+	if sequence&SequenceLockTimeIsSeconds == 0 {
+		return int64(spendHeight) >= int64(coinHeight)+lock, nil
+	}
+
+	hash, err := getHashByHeight(dbTx, max(coinHeight-1, 0))
+	if err != nil {
+		return false, err
+	}
+	coinTime, err := medianTimeOf(dbTx, hash)
+	if err != nil {
+		return false, err
+	}
+	return medianTime >= coinTime+lock<<SequenceLockTimeGranularity, nil
+}
+
+// checkSequenceLock returns ErrSequenceLocked if input inIdx of the transaction cannot be included in a block at spendHeight
+func checkSequenceLock(dbTx StoreTx, tx *Transaction, inIdx, coinHeight, spendHeight int, medianTime int64) error {
+	in := tx.Vin[inIdx]
+	reached, err := sequenceLockReached(dbTx, in.Sequence, coinHeight, spendHeight, medianTime)
+	if err != nil {
+		return err
+	}
+	if !reached {
+		return fmt.Errorf("%w: input %d of transaction %x with sequence %x spends an output of height %d",
+			ErrSequenceLocked, inIdx, tx.ID, in.Sequence, coinHeight)
+	}
+	return nil
+}
+
+// medianTimeOf returns the median time past of the block, the time a block on top of it is compared to
+func medianTimeOf(dbTx StoreTx, hash []byte) (int64, error) {
+	header, err := getHeader(dbTx, hash)
+	if err != nil {
+		return 0, err
+	}
+	return calcMedianTimePast(header, headerGetter(dbTx))
+}
+
+// timeLockReached reports whether the time lock of the locking script of an unspent output,
+// if it has one, is reached by a block at spendHeight after blocks of median time medianTime
+func timeLockReached(dbTx StoreTx, entry *UTXOEntry, spendHeight int, medianTime int64) (bool, error) {
+	if lockTime, ok := entry.ScriptPubKey.LockTime(); ok {
+		return lockTimeReached(lockTime, spendHeight, medianTime), nil
+	}
+	if sequence, ok := entry.ScriptPubKey.SequenceLock(); ok {
+		return sequenceLockReached(dbTx, sequence, entry.Height, spendHeight, medianTime)
+	}
+	return true, nil
+}
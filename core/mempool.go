@@ -0,0 +1,102 @@
+package core
+
+import (
+	"encoding/hex"
+	"sync"
+)
+
+// Mempool holds the transactions that are waiting to be mined into a block.
+// It is kept in sync with the chain: transactions are removed when a block containing them (or a
+// conflicting spend) is connected, and put back when their block is disconnected by a reorganization.
+type Mempool struct {
+	mu  sync.RWMutex
+	txs map[string]Transaction
+}
+
+func NewMempool() *Mempool {
+	return &Mempool{txs: make(map[string]Transaction)}
+}
+
+// Add puts a transaction into the pool
+func (m *Mempool) Add(tx Transaction) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.txs[hex.EncodeToString(tx.ID)] = tx
+}
+
+// Get returns the transaction with the given id, if it is in the pool
+func (m *Mempool) Get(txID []byte) (Transaction, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	tx, ok := m.txs[hex.EncodeToString(txID)]
+	return tx, ok
+}
+
+// Has reports whether the transaction with the given id is in the pool
+func (m *Mempool) Has(txID []byte) bool {
+	_, ok := m.Get(txID)
+	return ok
+}
+
+// Remove drops a transaction from the pool
+func (m *Mempool) Remove(txID []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.txs, hex.EncodeToString(txID))
+}
+
+// Transactions returns a snapshot of all the transactions in the pool
+func (m *Mempool) Transactions() []Transaction {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	txs := make([]Transaction, 0, len(m.txs))
+	for _, tx := range m.txs {
+		txs = append(txs, tx)
+	}
+	return txs
+}
+
+// Len returns the number of transactions in the pool
+func (m *Mempool) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.txs)
+}
+
+// applyChainUpdate puts the transactions of disconnected blocks back into the pool and removes
+// every transaction that was mined in a connected block or that spends the same outputs.
+func (m *Mempool) applyChainUpdate(disconnected, connected []*Block) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, block := range disconnected {
+		for _, tx := range block.Transactions {
+			if !tx.IsCoinbase() {
+				m.txs[hex.EncodeToString(tx.ID)] = *tx
+			}
+		}
+	}
+
+	for _, block := range connected {
+		spent := make(map[string]bool)
+		for _, tx := range block.Transactions {
+			delete(m.txs, hex.EncodeToString(tx.ID))
+			if tx.IsCoinbase() {
+				continue
+			}
+			for _, in := range tx.Vin {
+				spent[OutPoint{in.Txid, in.Vout}.String()] = true
+			}
+		}
+
+		// drop the transactions that double spend an output of the connected block
+		for id, tx := range m.txs {
+			for _, in := range tx.Vin {
+				if spent[OutPoint{in.Txid, in.Vout}.String()] {
+					delete(m.txs, id)
+					break
+				}
+			}
+		}
+	}
+}
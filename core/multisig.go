@@ -0,0 +1,156 @@
+package core
+
+import (
+	"blockchain-from-scratch/core/wallet"
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrNotCosigner         = errors.New("key is not part of the multisig script")
+	ErrNotEnoughSignatures = errors.New("not enough signatures")
+	errBadMultiSigTx       = errors.New("multisig transaction has no signatures slot for every input")
+)
+
+// MultiSigTx is a spend of outputs paying to a multisig script hash that collects the signatures of its owners.
+// It is passed from one signer to the next, each one adds a partial signature with Sign,
+// then Finalize turns it into a transaction once enough signatures are collected.
+// The signatures commit to the redeem script, so signers do not need the chain.
+type MultiSigTx struct {
+	Tx           *Transaction
+	RedeemScript Script
+	// Signatures holds the signatures collected for every input, keyed by the hex public key of the signer
+	Signatures []map[string][]byte
+}
+
+// NewMultiSigTx builds an unsigned spend of the outputs paying to the redeem script, sending amount to the address.
+// Like NewUTXOTransaction, the fee is left out of the outputs and the change goes back to the script.
+func NewMultiSigTx(redeemScript Script, to string, amount, fee int, UTXOSet *UTXOSet) (*MultiSigTx, error) {
+	if _, _, err := ParseMultiSigScript(redeemScript); err != nil {
+		return nil, err
+	}
+	if fee < 0 {
+		return nil, fmt.Errorf("%w: negative fee %d", ErrBadTxOutValue, fee)
+	}
+
+	scriptHash := redeemScript.Hash()
+	acc, validOutputs, err := UTXOSet.FindSpendableScriptOutputs(scriptHash, amount+fee)
+	if err != nil {
+		return nil, err
+	}
+	if acc < amount+fee {
+		return nil, fmt.Errorf("%w: have %d, need %d", ErrInsufficientFunds, acc, amount+fee)
+	}
+
+	var inputs []TxInput
+	for txId, outs := range validOutputs {
+		txID, err := hex.DecodeString(txId)
+		if err != nil {
+			return nil, err
+		}
+		for _, out := range outs {
+			inputs = append(inputs, TxInput{txID, out, nil, SequenceFinal})
+		}
+	}
+
+	logrus.Infof("NewMultiSigTx from script %x to '%s' amount %d fee %d", scriptHash, to, amount, fee)
+	out, err := NewTXOutput(amount, to)
+	if err != nil {
+		return nil, err
+	}
+	outputs := []TxOutput{*out}
+	if change := acc - amount - fee; change > 0 {
+		outputs = append(outputs, TxOutput{change, PayToScriptHashScript(scriptHash)})
+	}
+	tx := &Transaction{nil, inputs, outputs, 0}
+	tx.ID, err = tx.Hash()
+	if err != nil {
+		return nil, err
+	}
+
+	signatures := make([]map[string][]byte, len(inputs))
+	for i := range signatures {
+		signatures[i] = make(map[string][]byte)
+	}
+	return &MultiSigTx{tx, redeemScript, signatures}, nil
+}
+
+// Sign adds the signatures of the private key owner to every input,
+// it returns ErrNotCosigner if the key is not one of the redeem script
+func (m *MultiSigTx) Sign(priKey ecdsa.PrivateKey) error {
+	_, pubKeys, err := ParseMultiSigScript(m.RedeemScript)
+	if err != nil {
+		return err
+	}
+	pubKey := wallet.MarshalPublicKey(&priKey.PublicKey)
+	if !containsKey(pubKeys, pubKey) {
+		return fmt.Errorf("%w: %x", ErrNotCosigner, pubKey)
+	}
+	if len(m.Signatures) != len(m.Tx.Vin) {
+		return errBadMultiSigTx
+	}
+
+	for inId := range m.Tx.Vin {
+		sig, err := m.Tx.signInput(inId, m.RedeemScript, priKey)
+		if err != nil {
+			return err
+		}
+		m.Signatures[inId][hex.EncodeToString(pubKey)] = sig
+	}
+	return nil
+}
+
+// SignatureCount returns the number of signers so far and the number required
+func (m *MultiSigTx) SignatureCount() (int, int, error) {
+	required, _, err := ParseMultiSigScript(m.RedeemScript)
+	if err != nil || len(m.Signatures) == 0 {
+		return 0, required, err
+	}
+	// every signer signs all the inputs at once
+	return len(m.Signatures[0]), required, nil
+}
+
+// Finalize builds the unlocking scripts from the collected signatures, taken in the order of the keys
+// of the redeem script. It returns ErrNotEnoughSignatures until the required number is collected.
+func (m *MultiSigTx) Finalize() (*Transaction, error) {
+	required, pubKeys, err := ParseMultiSigScript(m.RedeemScript)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Signatures) != len(m.Tx.Vin) {
+		return nil, errBadMultiSigTx
+	}
+
+	tx := *m.Tx
+	tx.Vin = make([]TxInput, len(m.Tx.Vin))
+	for inId, vin := range m.Tx.Vin {
+		b := NewScriptBuilder()
+		count := 0
+		for _, pubKey := range pubKeys {
+			sig, ok := m.Signatures[inId][hex.EncodeToString(pubKey)]
+			if ok && count < required {
+				b.AddData(sig)
+				count++
+			}
+		}
+		if count < required {
+			return nil, fmt.Errorf("%w: input %d has %d of %d", ErrNotEnoughSignatures, inId, count, required)
+		}
+		tx.Vin[inId] = TxInput{vin.Txid, vin.Vout, b.AddData(m.RedeemScript).Script(), vin.Sequence}
+	}
+	return &tx, nil
+}
+
+func containsKey(pubKeys [][]byte, pubKey []byte) bool {
+	for _, key := range pubKeys {
+		if bytes.Equal(key, pubKey) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,215 @@
+package core
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+)
+
+// ChainParams holds the rules and settings that may differ from one network to another:
+// two nodes only talk to each other when they use the same parameters
+type ChainParams struct {
+	// Name identifies the network, it selects the parameters on the command line
+	Name string
+	// Magic prefixes every message sent between nodes, messages of other networks are dropped
+	Magic [4]byte
+	// GenesisCoinbaseData is the message written in the coinbase of the genesis block
+	GenesisCoinbaseData string
+	// GenesisTimestamp and GenesisNonce fix the header of the genesis block, see GenesisBlock
+	GenesisTimestamp int64
+	GenesisNonce     int
+	// GenesisHash is the hash of the genesis block in hex, it is empty for custom networks
+	// whose genesis block is mined by CreateBlockchain
+	GenesisHash string
+	// AddressVersion is the first byte of the addresses of the network,
+	// so coins cannot be sent to an address of another network by mistake
+	AddressVersion byte
+	// ScriptAddressVersion is the first byte of the addresses paying to a script hash
+	ScriptAddressVersion byte
+	// PrivateKeyVersion is the first byte of the private keys exported by dumpprivkey
+	PrivateKeyVersion byte
+	// DefaultPort is the port a node listens on unless another one is given, the seeds listen on it
+	DefaultPort string
+	// Seeds are the hosts of the nodes a new node connects to first, the first one is the central node
+	Seeds []string
+
+	// PowLimit is the highest target (lowest difficulty) a block hash can have, the genesis block uses it
+	PowLimit *big.Int
+	// TargetBlockSpacing is the number of seconds we expect between two blocks
+	TargetBlockSpacing int64
+	// RetargetInterval is the number of blocks between two difficulty adjustments,
+	// like Bitcoin does every 2016 blocks
+	RetargetInterval int
+	// NoRetargeting keeps every block at the PowLimit difficulty
+	NoRetargeting bool
+
+	// InitialSubsidy is the reward of the blocks mined before the first halving
+	InitialSubsidy int
+	// SubsidyHalvingInterval is the number of blocks after which the subsidy is halved,
+	// like Bitcoin does every 210000 blocks
+	SubsidyHalvingInterval int
+	// MaxSupply caps the total amount of coins created by the subsidy, the last blocks
+	// before the cap only get what is left
+	MaxSupply int
+	// CoinbaseMaturity is the number of blocks a coinbase output must be buried under before it can be spent,
+	// so a reorganization cannot make transactions spending a vanished reward invalid
+	CoinbaseMaturity int
+}
+
+// MainNetParams are the parameters of the main network.
+// 10 coins halved every 210000 blocks add up to 3780000 coins, the max supply.
+var MainNetParams = ChainParams{
+	Name:                 "main",
+	Magic:                [4]byte{0xf9, 0xbe, 0xb4, 0xd9},
+	GenesisCoinbaseData:  "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks",
+	GenesisTimestamp:     1735689600,
+	GenesisNonce:         74,
+	GenesisHash:          "00070568fc0549168d40dd59f029690c7cf09eeadee66ea2dbe9af9bb45b180f",
+	AddressVersion:       0x00,
+	ScriptAddressVersion: 0x05,
+	PrivateKeyVersion:    0x80,
+	DefaultPort:          "3000",
+	Seeds:                []string{"localhost"},
+
+	PowLimit:           new(big.Int).Lsh(big.NewInt(1), 256-8),
+	TargetBlockSpacing: 10,
+	RetargetInterval:   10,
+
+	InitialSubsidy:         10,
+	SubsidyHalvingInterval: 210000,
+	MaxSupply:              3780000,
+	CoinbaseMaturity:       100,
+}
+
+// TestNetParams are the parameters of the test network, it follows the rules of the main network
+// with coins that have no value
+var TestNetParams = ChainParams{
+	Name:                 "test",
+	Magic:                [4]byte{0x0b, 0x11, 0x09, 0x07},
+	GenesisCoinbaseData:  "Testnet genesis block, the coins of this network have no value",
+	GenesisTimestamp:     1735776000,
+	GenesisNonce:         253,
+	GenesisHash:          "006ba22a341c421eecff5725af302737541b36026ad4d3c2d9e666ad476d6c9b",
+	AddressVersion:       0x6f,
+	ScriptAddressVersion: 0xc4,
+	PrivateKeyVersion:    0xef,
+	DefaultPort:          "13000",
+	Seeds:                []string{"localhost"},
+
+	PowLimit:           new(big.Int).Lsh(big.NewInt(1), 256-8),
+	TargetBlockSpacing: 10,
+	RetargetInterval:   10,
+
+	InitialSubsidy:         10,
+	SubsidyHalvingInterval: 210000,
+	MaxSupply:              3780000,
+	CoinbaseMaturity:       100,
+}
+
+// RegTestParams are the parameters of the regression test network: blocks are mined instantly
+// at a fixed difficulty and the subsidy halves every 150 blocks, so a local chain can go through
+// the whole schedule in seconds
+var RegTestParams = ChainParams{
+	Name:                 "regtest",
+	Magic:                [4]byte{0xfa, 0xbf, 0xb5, 0xda},
+	GenesisCoinbaseData:  "Regtest genesis block",
+	GenesisTimestamp:     1735862400,
+	GenesisNonce:         4,
+	GenesisHash:          "46a32bf4cd03ba1e4e6228554fcac9cf6cbe246f6ca6e6c43d48205e6bc6b5fe",
+	AddressVersion:       0x6f,
+	ScriptAddressVersion: 0xc4,
+	PrivateKeyVersion:    0xef,
+	DefaultPort:          "23000",
+	Seeds:                []string{"localhost"},
+
+	PowLimit:           new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(1)),
+	TargetBlockSpacing: 10,
+	RetargetInterval:   10,
+	NoRetargeting:      true,
+
+	InitialSubsidy:         10,
+	SubsidyHalvingInterval: 150,
+	MaxSupply:              2700,
+	CoinbaseMaturity:       100,
+}
+
+// CustomNetParams are the parameters of a custom private network with the rules of regtest.
+// It has no hard-coded genesis block: CreateBlockchain mines one paying its reward to the creator,
+// and other nodes join by copying the db file.
+var CustomNetParams = ChainParams{
+	Name:                 "custom",
+	Magic:                [4]byte{0xfa, 0xbf, 0xb5, 0xdb},
+	GenesisCoinbaseData:  "Custom network genesis block",
+	AddressVersion:       0x6f,
+	ScriptAddressVersion: 0xc4,
+	PrivateKeyVersion:    0xef,
+	DefaultPort:          "33000",
+	Seeds:                []string{"localhost"},
+
+	PowLimit:           new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(1)),
+	TargetBlockSpacing: 10,
+	RetargetInterval:   10,
+	NoRetargeting:      true,
+
+	InitialSubsidy:         10,
+	SubsidyHalvingInterval: 150,
+	MaxSupply:              2700,
+	CoinbaseMaturity:       100,
+}
+
+// ParamsByName returns the parameter set of the named network: main, test, regtest or custom
+func ParamsByName(name string) (*ChainParams, error) {
+	for _, params := range []*ChainParams{&MainNetParams, &TestNetParams, &RegTestParams, &CustomNetParams} {
+		if params.Name == name {
+			return params, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownNetwork, name)
+}
+
+// SeedAddresses returns the addresses of the seeds, they listen on the default port of the network
+func (p *ChainParams) SeedAddresses() []string {
+	addresses := make([]string, len(p.Seeds))
+	for i, seed := range p.Seeds {
+		addresses[i] = net.JoinHostPort(seed, p.DefaultPort)
+	}
+	return addresses
+}
+
+// PowLimitBits returns PowLimit in its compact representation
+func (p *ChainParams) PowLimitBits() uint32 {
+	return BigToCompact(p.PowLimit)
+}
+
+// targetTimespan is the expected time to mine RetargetInterval blocks
+func (p *ChainParams) targetTimespan() int64 {
+	return p.TargetBlockSpacing * int64(p.RetargetInterval)
+}
+
+// CalcBlockSubsidy returns the amount of new coins the coinbase of the block at the given height can create
+func (p *ChainParams) CalcBlockSubsidy(height int) int {
+	if height < 0 {
+		return 0
+	}
+	return p.issuedBefore(height+1) - p.issuedBefore(height)
+}
+
+// CalcSupply returns the total amount of coins created by the blocks from genesis to the given height
+func (p *ChainParams) CalcSupply(height int) int {
+	return p.issuedBefore(height + 1)
+}
+
+// issuedBefore returns the amount of coins created by the first n blocks
+func (p *ChainParams) issuedBefore(n int) int {
+	issued := 0
+	reward := p.InitialSubsidy
+	for start := 0; start < n && reward > 0; start += p.SubsidyHalvingInterval {
+		blocks := min(n-start, p.SubsidyHalvingInterval)
+		issued += blocks * reward
+		if issued >= p.MaxSupply {
+			return p.MaxSupply
+		}
+		reward >>= 1
+	}
+	return issued
+}
@@ -9,21 +9,18 @@ import (
 	"math/big"
 )
 
-const targetBits = 8
-
 var (
 	maxNonce = math.MaxInt64
 )
 
 type ProofOfWork struct {
-	block  *Block
+	header *BlockHeader
 	target *big.Int
 }
 
-func NewProofOfWork(b *Block) *ProofOfWork {
-	target := big.NewInt(1)
-	target.Lsh(target, uint(256-targetBits))
-	pow := &ProofOfWork{b, target}
+func NewProofOfWork(h *BlockHeader) *ProofOfWork {
+	target := CompactToBig(h.Bits)
+	pow := &ProofOfWork{h, target}
 	return pow
 }
 
@@ -57,12 +54,18 @@ func (pow *ProofOfWork) Run() (nonce int, hashRes []byte, err error) {
 	return
 }
 
+// prepareData serializes the header with the given nonce.
+// Only the header is hashed, the transactions are committed through the Merkle root.
 func (pow *ProofOfWork) prepareData(nonce int) ([]byte, error) {
-	timeHex, err := intToHex(pow.block.Timestamp)
+	versionHex, err := intToHex(int64(pow.header.Version))
 	if err != nil {
 		return nil, err
 	}
-	targetBitsHex, err := intToHex(int64(targetBits))
+	timeHex, err := intToHex(pow.header.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	targetBitsHex, err := intToHex(int64(pow.header.Bits))
 	if err != nil {
 		return nil, err
 	}
@@ -70,13 +73,19 @@ func (pow *ProofOfWork) prepareData(nonce int) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	heightHex, err := intToHex(int64(pow.header.Height))
+	if err != nil {
+		return nil, err
+	}
 	data := bytes.Join(
 		[][]byte{
-			pow.block.PrevBlockHash,
-			pow.block.HashTransactions(),
+			versionHex,
+			pow.header.PrevBlockHash,
+			pow.header.MerkleRoot,
 			timeHex,
 			targetBitsHex,
 			nonceHex,
+			heightHex,
 		},
 		[]byte{},
 	)
@@ -93,9 +102,18 @@ func intToHex(num int64) ([]byte, error) {
 	return buff.Bytes(), nil
 }
 
-func (pow *ProofOfWork) Validate() bool {
+// Validate checks that the block uses the target the chain rules expect at its height
+// and that its hash satisfies that target. The expected target never exceeds the limit of the network.
+func (pow *ProofOfWork) Validate(expectedBits uint32) bool {
+	if pow.header.Bits != expectedBits {
+		return false
+	}
+	if pow.target.Sign() <= 0 {
+		return false
+	}
+
 	var hashInt big.Int
-	data, err := pow.prepareData(pow.block.Nonce)
+	data, err := pow.prepareData(pow.header.Nonce)
 	if err != nil {
 		return false
 	}
@@ -103,3 +121,13 @@ func (pow *ProofOfWork) Validate() bool {
 	hashInt.SetBytes(hash[:])
 	return hashInt.Cmp(pow.target) == -1
 }
+
+// Hash recomputes the hash of the header with its nonce
+func (pow *ProofOfWork) Hash() ([]byte, error) {
+	data, err := pow.prepareData(pow.header.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(data)
+	return hash[:], nil
+}
@@ -0,0 +1,552 @@
+package core
+
+import (
+	"blockchain-from-scratch/core/wallet"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Script is a program of the stack based script language used to lock outputs.
+// The locking script of an output (ScriptPubKey) sets the conditions to spend it and
+// the unlocking script of the input spending it (ScriptSig) pushes the data that satisfies them.
+// Like Bitcoin, the unlocking script runs first, then the locking script runs on the resulting stack,
+// the spend is valid when it leaves a true value on top. See verifyScript.
+type Script []byte
+
+// Opcodes of the script language, their values are the ones of Bitcoin
+const (
+	// Op0 pushes an empty value, which is false
+	Op0 byte = 0x00
+	// opcodes 0x01 to 0x4b push the next 1 to 75 bytes
+	OpPushData1 byte = 0x4c
+	OpPushData2 byte = 0x4d
+	// Op1 to Op16 push the numbers 1 to 16
+	Op1  byte = 0x51
+	Op16 byte = 0x60
+
+	OpIf     byte = 0x63
+	OpNotIf  byte = 0x64
+	OpElse   byte = 0x67
+	OpEndIf  byte = 0x68
+	OpVerify byte = 0x69
+	// OpReturn fails the script, it marks data outputs that can never be spent
+	OpReturn byte = 0x6a
+
+	OpDrop byte = 0x75
+	OpDup  byte = 0x76
+
+	OpEqual       byte = 0x87
+	OpEqualVerify byte = 0x88
+
+	// OpCheckLockTimeVerify fails unless the lock time of the transaction is at least the number on top of the stack,
+	// which it leaves there. See scriptEngine.checkLockTime.
+	OpCheckLockTimeVerify byte = 0xb1
+	// OpCheckSequenceVerify fails unless the relative lock of the input is at least the number on top of the stack,
+	// which it leaves there. See scriptEngine.checkSequence.
+	OpCheckSequenceVerify byte = 0xb2
+
+	OpSHA256 byte = 0xa8
+	// OpHash160 hashes a public key like the addresses do, see wallet.HashPubKey
+	OpHash160        byte = 0xa9
+	OpCheckSig       byte = 0xac
+	OpCheckSigVerify byte = 0xad
+	// OpCheckMultiSig checks m signatures against n public keys, the signatures must be
+	// in the order of the keys. Unlike Bitcoin, it does not pop an extra unused value.
+	OpCheckMultiSig       byte = 0xae
+	OpCheckMultiSigVerify byte = 0xaf
+)
+
+var opcodeNames = map[byte]string{
+	Op0:                   "OP_0",
+	OpPushData1:           "OP_PUSHDATA1",
+	OpPushData2:           "OP_PUSHDATA2",
+	OpIf:                  "OP_IF",
+	OpNotIf:               "OP_NOTIF",
+	OpElse:                "OP_ELSE",
+	OpEndIf:               "OP_ENDIF",
+	OpVerify:              "OP_VERIFY",
+	OpReturn:              "OP_RETURN",
+	OpDrop:                "OP_DROP",
+	OpDup:                 "OP_DUP",
+	OpEqual:               "OP_EQUAL",
+	OpEqualVerify:         "OP_EQUALVERIFY",
+	OpSHA256:              "OP_SHA256",
+	OpHash160:             "OP_HASH160",
+	OpCheckSig:            "OP_CHECKSIG",
+	OpCheckSigVerify:      "OP_CHECKSIGVERIFY",
+	OpCheckMultiSig:       "OP_CHECKMULTISIG",
+	OpCheckMultiSigVerify: "OP_CHECKMULTISIGVERIFY",
+	OpCheckLockTimeVerify: "OP_CHECKLOCKTIMEVERIFY",
+	OpCheckSequenceVerify: "OP_CHECKSEQUENCEVERIFY",
+}
+
+const (
+	// maxScriptSize is the maximum size of a script
+	maxScriptSize = 10000
+	// maxScriptElementSize is the maximum size of a value pushed on the stack
+	maxScriptElementSize = 520
+	// maxLockTimeSize is the maximum size of the numbers of OP_CHECKLOCKTIMEVERIFY and OP_CHECKSEQUENCEVERIFY,
+	// enough for any uint32
+	maxLockTimeSize = 5
+	// maxPubKeysPerMultiSig is the maximum number of keys of OP_CHECKMULTISIG, the counts are pushed with Op1 to Op16
+	maxPubKeysPerMultiSig = 16
+)
+
+var (
+	ErrBadScript    = errors.New("script is invalid")
+	ErrScriptFailed = errors.New("script evaluated to false")
+)
+
+// parsedOp is an opcode of a script with the data it pushes
+type parsedOp struct {
+	opcode byte
+	data   []byte
+}
+
+// isPush reports whether the op only pushes a value
+func (op parsedOp) isPush() bool {
+	return op.opcode <= OpPushData2 || (op.opcode >= Op1 && op.opcode <= Op16)
+}
+
+// parseScript splits the script into its opcodes, it fails if a push runs past the end of the script
+func parseScript(script Script) ([]parsedOp, error) {
+	if len(script) > maxScriptSize {
+		return nil, fmt.Errorf("%w: %d bytes is over the %d bytes limit", ErrBadScript, len(script), maxScriptSize)
+	}
+
+	var ops []parsedOp
+	for i := 0; i < len(script); {
+		opcode := script[i]
+		i++
+
+		size := 0
+		switch {
+		case opcode > Op0 && opcode < OpPushData1:
+			size = int(opcode)
+		case opcode == OpPushData1:
+			if i+1 > len(script) {
+				return nil, fmt.Errorf("%w: truncated OP_PUSHDATA1", ErrBadScript)
+			}
+			size = int(script[i])
+			i++
+		case opcode == OpPushData2:
+			if i+2 > len(script) {
+				return nil, fmt.Errorf("%w: truncated OP_PUSHDATA2", ErrBadScript)
+			}
+			size = int(binary.LittleEndian.Uint16(script[i:]))
+			i += 2
+		}
+		if i+size > len(script) {
+			return nil, fmt.Errorf("%w: push of %d bytes past the end of the script", ErrBadScript, size)
+		}
+
+		op := parsedOp{opcode: opcode}
+		if size > 0 {
+			op.data = script[i : i+size]
+			i += size
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// String disassembles the script, pushed values are printed in hex
+func (s Script) String() string {
+	ops, err := parseScript(s)
+	if err != nil {
+		return fmt.Sprintf("[error: %s]", err)
+	}
+
+	var words []string
+	for _, op := range ops {
+		switch {
+		case op.data != nil:
+			words = append(words, hex.EncodeToString(op.data))
+		case op.opcode >= Op1 && op.opcode <= Op16:
+			words = append(words, fmt.Sprintf("OP_%d", op.opcode-Op1+1))
+		case opcodeNames[op.opcode] != "":
+			words = append(words, opcodeNames[op.opcode])
+		default:
+			words = append(words, fmt.Sprintf("OP_UNKNOWN_%02x", op.opcode))
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// ScriptBuilder builds a script one opcode at a time, pushes use the shortest encoding
+type ScriptBuilder struct {
+	script Script
+}
+
+func NewScriptBuilder() *ScriptBuilder {
+	return &ScriptBuilder{}
+}
+
+// AddOp appends an opcode
+func (b *ScriptBuilder) AddOp(opcode byte) *ScriptBuilder {
+	b.script = append(b.script, opcode)
+	return b
+}
+
+// AddData appends the opcode pushing data
+func (b *ScriptBuilder) AddData(data []byte) *ScriptBuilder {
+	switch size := len(data); {
+	case size == 0:
+		b.script = append(b.script, Op0)
+	case size < int(OpPushData1):
+		b.script = append(b.script, byte(size))
+	case size <= 0xff:
+		b.script = append(b.script, OpPushData1, byte(size))
+	default:
+		b.script = append(b.script, OpPushData2, 0, 0)
+		binary.LittleEndian.PutUint16(b.script[len(b.script)-2:], uint16(size))
+	}
+	b.script = append(b.script, data...)
+	return b
+}
+
+// AddSmallInt appends the opcode pushing a number from 0 to 16
+func (b *ScriptBuilder) AddSmallInt(n int) *ScriptBuilder {
+	if n == 0 {
+		return b.AddOp(Op0)
+	}
+	return b.AddOp(Op1 + byte(n-1))
+}
+
+// AddInt64 appends the opcode pushing a number, see scriptNum
+func (b *ScriptBuilder) AddInt64(n int64) *ScriptBuilder {
+	if n >= 0 && n <= 16 {
+		return b.AddSmallInt(int(n))
+	}
+	return b.AddData(scriptNumBytes(n))
+}
+
+// Script returns the script built so far
+func (b *ScriptBuilder) Script() Script {
+	return b.script
+}
+
+// scriptNum decodes a number of the stack, encoded like Bitcoin: little endian,
+// the highest bit of the last byte is the sign. The empty value is 0.
+func scriptNum(value []byte, maxLen int) (int64, error) {
+	if len(value) > maxLen {
+		return 0, fmt.Errorf("%w: number of %d bytes is over the %d bytes limit", ErrBadScript, len(value), maxLen)
+	}
+	if len(value) == 0 {
+		return 0, nil
+	}
+
+	var n int64
+	for i, b := range value {
+		n |= int64(b) << (8 * i)
+	}
+	if last := value[len(value)-1]; last&0x80 != 0 {
+		n &^= int64(0x80) << (8 * (len(value) - 1))
+		return -n, nil
+	}
+	return n, nil
+}
+
+// scriptNumBytes encodes a number for scriptNum
+func scriptNumBytes(n int64) []byte {
+	if n == 0 {
+		return nil
+	}
+	negative := n < 0
+	if negative {
+		n = -n
+	}
+
+	var value []byte
+	for n > 0 {
+		value = append(value, byte(n))
+		n >>= 8
+	}
+	// the sign needs an extra byte when the highest bit is taken
+	if value[len(value)-1]&0x80 != 0 {
+		value = append(value, 0)
+	}
+	if negative {
+		value[len(value)-1] |= 0x80
+	}
+	return value
+}
+
+// ScriptClass identifies the standard templates of locking scripts
+type ScriptClass int
+
+const (
+	// NonStandardClass is any script that does not follow a template
+	NonStandardClass ScriptClass = iota
+	// PubKeyHashClass pays to the hash of a public key, the addresses of the wallet:
+	//	OP_DUP OP_HASH160 <pubKeyHash> OP_EQUALVERIFY OP_CHECKSIG
+	PubKeyHashClass
+	// PubKeyClass pays to a public key:
+	//	<pubKey> OP_CHECKSIG
+	PubKeyClass
+	// NullDataClass carries data and can never be spent:
+	//	OP_RETURN <data>
+	NullDataClass
+	// ScriptHashClass pays to the hash of a redeem script, the spender reveals the script
+	// and satisfies it. See verifyScript.
+	//	OP_HASH160 <scriptHash> OP_EQUAL
+	ScriptHashClass
+	// MultiSigClass requires m signatures of n public keys:
+	//	OP_m <pubKey1> ... <pubKeyn> OP_n OP_CHECKMULTISIG
+	MultiSigClass
+	// LockTimeClass pays to the hash of a public key once the block height or time lockTime is reached:
+	//	<lockTime> OP_CHECKLOCKTIMEVERIFY OP_DROP OP_DUP OP_HASH160 <pubKeyHash> OP_EQUALVERIFY OP_CHECKSIG
+	LockTimeClass
+	// SequenceLockClass pays to the hash of a public key once the relative lock sequence is reached:
+	//	<sequence> OP_CHECKSEQUENCEVERIFY OP_DROP OP_DUP OP_HASH160 <pubKeyHash> OP_EQUALVERIFY OP_CHECKSIG
+	SequenceLockClass
+	// HTLCClass is a hash time-locked contract, see HTLC
+	HTLCClass
+)
+
+func (c ScriptClass) String() string {
+	switch c {
+	case PubKeyHashClass:
+		return "pubkeyhash"
+	case PubKeyClass:
+		return "pubkey"
+	case NullDataClass:
+		return "nulldata"
+	case ScriptHashClass:
+		return "scripthash"
+	case MultiSigClass:
+		return "multisig"
+	case LockTimeClass:
+		return "locktime"
+	case SequenceLockClass:
+		return "sequencelock"
+	case HTLCClass:
+		return "htlc"
+	default:
+		return "nonstandard"
+	}
+}
+
+// PayToPubKeyHashScript returns the locking script paying to the owner of the public key hash
+func PayToPubKeyHashScript(pubKeyHash []byte) Script {
+	return NewScriptBuilder().AddOp(OpDup).AddOp(OpHash160).AddData(pubKeyHash).
+		AddOp(OpEqualVerify).AddOp(OpCheckSig).Script()
+}
+
+// LockTimeScript returns the locking script paying to the owner of the public key hash once
+// the block height, or the unix time from LockTimeThreshold on, lockTime is reached
+func LockTimeScript(lockTime uint32, pubKeyHash []byte) Script {
+	b := NewScriptBuilder().AddInt64(int64(lockTime)).AddOp(OpCheckLockTimeVerify).AddOp(OpDrop)
+	return append(b.Script(), PayToPubKeyHashScript(pubKeyHash)...)
+}
+
+// SequenceLockScript returns the locking script paying to the owner of the public key hash once
+// the relative lock sequence is reached: a number of blocks, or of 512 seconds with SequenceLockTimeIsSeconds,
+// after the block of the output
+func SequenceLockScript(sequence uint32, pubKeyHash []byte) Script {
+	b := NewScriptBuilder().AddInt64(int64(sequence)).AddOp(OpCheckSequenceVerify).AddOp(OpDrop)
+	return append(b.Script(), PayToPubKeyHashScript(pubKeyHash)...)
+}
+
+// PayToPubKeyScript returns the locking script paying to the owner of the public key
+func PayToPubKeyScript(pubKey []byte) Script {
+	return NewScriptBuilder().AddData(pubKey).AddOp(OpCheckSig).Script()
+}
+
+// NullDataScript returns an unspendable locking script carrying data
+func NullDataScript(data []byte) Script {
+	return NewScriptBuilder().AddOp(OpReturn).AddData(data).Script()
+}
+
+// PayToScriptHashScript returns the locking script paying to the hash of a redeem script
+func PayToScriptHashScript(scriptHash []byte) Script {
+	return NewScriptBuilder().AddOp(OpHash160).AddData(scriptHash).AddOp(OpEqual).Script()
+}
+
+// MultiSigScript returns the script requiring m signatures of the public keys,
+// it is used as the redeem script of multisig addresses. The unlocking script pushes the redeem script,
+// so it must fit in a stack value: up to 7 keys of 64 bytes.
+func MultiSigScript(m int, pubKeys [][]byte) (Script, error) {
+	n := len(pubKeys)
+	if n == 0 || n > maxPubKeysPerMultiSig || m < 1 || m > n {
+		return nil, fmt.Errorf("%w: cannot require %d signatures of %d keys", ErrBadScript, m, n)
+	}
+
+	b := NewScriptBuilder().AddSmallInt(m)
+	for _, pubKey := range pubKeys {
+		if len(pubKey) != wallet.PublicKeyLen {
+			return nil, fmt.Errorf("%w: public key of %d bytes", ErrBadScript, len(pubKey))
+		}
+		b.AddData(pubKey)
+	}
+	script := b.AddSmallInt(n).AddOp(OpCheckMultiSig).Script()
+	if len(script) > maxScriptElementSize {
+		return nil, fmt.Errorf("%w: redeem script of %d bytes is over the %d bytes limit", ErrBadScript, len(script), maxScriptElementSize)
+	}
+	return script, nil
+}
+
+// ParseMultiSigScript returns the number of required signatures and the public keys of a multisig script
+func ParseMultiSigScript(script Script) (int, [][]byte, error) {
+	if script.Class() != MultiSigClass {
+		return 0, nil, fmt.Errorf("%w: %s is not a multisig script", ErrBadScript, script)
+	}
+	ops, _ := parseScript(script)
+
+	var pubKeys [][]byte
+	for _, op := range ops[1 : len(ops)-2] {
+		pubKeys = append(pubKeys, op.data)
+	}
+	return int(ops[0].opcode-Op1) + 1, pubKeys, nil
+}
+
+// Class returns the template the script follows
+func (s Script) Class() ScriptClass {
+	ops, err := parseScript(s)
+	if err != nil {
+		return NonStandardClass
+	}
+
+	switch {
+	case len(ops) == 5 && ops[0].opcode == OpDup && ops[1].opcode == OpHash160 && len(ops[2].data) == 20 &&
+		ops[3].opcode == OpEqualVerify && ops[4].opcode == OpCheckSig:
+		return PubKeyHashClass
+	case len(ops) == 2 && len(ops[0].data) > 0 && ops[1].opcode == OpCheckSig:
+		return PubKeyClass
+	case len(ops) <= 2 && len(ops) > 0 && ops[0].opcode == OpReturn && (len(ops) == 1 || ops[1].isPush()):
+		return NullDataClass
+	case len(ops) == 3 && ops[0].opcode == OpHash160 && len(ops[1].data) == 20 && ops[2].opcode == OpEqual:
+		return ScriptHashClass
+	case isMultiSig(ops):
+		return MultiSigClass
+	case len(ops) == 8 && ops[1].opcode == OpCheckLockTimeVerify && isTimeLocked(ops):
+		return LockTimeClass
+	case len(ops) == 8 && ops[1].opcode == OpCheckSequenceVerify && isTimeLocked(ops):
+		return SequenceLockClass
+	case len(ops) == 17 && ops[0].opcode == OpIf:
+		if _, err := ParseHTLCScript(s); err == nil {
+			return HTLCClass
+		}
+	}
+	return NonStandardClass
+}
+
+// isTimeLocked checks the templates of LockTimeClass and SequenceLockClass but their opcode:
+// a positive lock that fits an uint32, dropped before a pay-to-pubkey-hash script
+func isTimeLocked(ops []parsedOp) bool {
+	if !ops[0].isPush() || ops[2].opcode != OpDrop {
+		return false
+	}
+	lock, err := lockNum(ops[0])
+	if err != nil || lock > math.MaxUint32 {
+		return false
+	}
+	return ops[3].opcode == OpDup && ops[4].opcode == OpHash160 && len(ops[5].data) == 20 &&
+		ops[6].opcode == OpEqualVerify && ops[7].opcode == OpCheckSig
+}
+
+// lockNum returns the non negative number pushed by op for a time lock
+func lockNum(op parsedOp) (int64, error) {
+	if op.opcode >= Op1 && op.opcode <= Op16 {
+		return int64(op.opcode-Op1) + 1, nil
+	}
+	n, err := scriptNum(op.data, maxLockTimeSize)
+	if err == nil && n < 0 {
+		err = fmt.Errorf("%w: negative lock time %d", ErrBadScript, n)
+	}
+	return n, err
+}
+
+// LockTime returns the lock time of a LockTimeClass script
+func (s Script) LockTime() (uint32, bool) {
+	if s.Class() != LockTimeClass {
+		return 0, false
+	}
+	ops, _ := parseScript(s)
+	lockTime, _ := lockNum(ops[0])
+	return uint32(lockTime), true
+}
+
+// SequenceLock returns the relative lock of a SequenceLockClass script
+func (s Script) SequenceLock() (uint32, bool) {
+	if s.Class() != SequenceLockClass {
+		return 0, false
+	}
+	ops, _ := parseScript(s)
+	sequence, _ := lockNum(ops[0])
+	return uint32(sequence), true
+}
+
+// isMultiSig checks the template of MultiSigClass, the counts must match the keys
+func isMultiSig(ops []parsedOp) bool {
+	if len(ops) < 4 || ops[len(ops)-1].opcode != OpCheckMultiSig {
+		return false
+	}
+	m, n := ops[0].opcode, ops[len(ops)-2].opcode
+	if m < Op1 || m > Op16 || n < Op1 || n > Op16 || m > n {
+		return false
+	}
+	pubKeys := ops[1 : len(ops)-2]
+	if len(pubKeys) != int(n-Op1)+1 {
+		return false
+	}
+	for _, op := range pubKeys {
+		if len(op.data) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// IsUnspendable reports whether the script fails as soon as it runs, so its output can never be spent
+// and is left out of the chainstate
+func (s Script) IsUnspendable() bool {
+	return len(s) > 0 && s[0] == OpReturn
+}
+
+// NullData returns the data carried by a null data script
+func (s Script) NullData() ([]byte, bool) {
+	if s.Class() != NullDataClass {
+		return nil, false
+	}
+	ops, _ := parseScript(s)
+	if len(ops) == 1 {
+		return nil, true
+	}
+	return ops[1].data, true
+}
+
+// Hash returns the hash of the script that pay-to-script-hash outputs commit to
+func (s Script) Hash() []byte {
+	return wallet.HashPubKey(s)
+}
+
+// ScriptHash returns the redeem script hash of a pay-to-script-hash script, nil for the other scripts
+func (s Script) ScriptHash() []byte {
+	if s.Class() != ScriptHashClass {
+		return nil
+	}
+	ops, _ := parseScript(s)
+	return ops[1].data
+}
+
+// PubKeyHash returns the public key hash of the owner of a pay-to-pubkey-hash, time-locked pay-to-pubkey-hash
+// or pay-to-pubkey script, it is what the wallet uses to find its outputs. It returns nil for the other scripts.
+func (s Script) PubKeyHash() []byte {
+	switch s.Class() {
+	case PubKeyHashClass:
+		ops, _ := parseScript(s)
+		return ops[2].data
+	case LockTimeClass, SequenceLockClass:
+		ops, _ := parseScript(s)
+		return ops[5].data
+	case PubKeyClass:
+		ops, _ := parseScript(s)
+		return wallet.HashPubKey(ops[0].data)
+	}
+	return nil
+}
@@ -0,0 +1,425 @@
+package core
+
+import (
+	"blockchain-from-scratch/core/wallet"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+)
+
+// maxStackSize is the maximum number of values on the stack
+const maxStackSize = 1000
+
+// scriptEngine executes the scripts that unlock an input of a transaction
+type scriptEngine struct {
+	tx    *Transaction
+	inIdx int
+	// script is the script being executed, it replaces the unlocking script of the input
+	// in the hash signed by OP_CHECKSIG
+	script Script
+	stack  [][]byte
+	// conditions holds a value per OP_IF being executed, an opcode only runs when all of them are true
+	conditions []bool
+}
+
+// verifyScript checks that the unlocking script of input inIdx of tx satisfies the locking script of the output it spends
+func verifyScript(scriptSig, scriptPubKey Script, tx *Transaction, inIdx int) error {
+	sigOps, err := parseScript(scriptSig)
+	if err != nil {
+		return err
+	}
+	for _, op := range sigOps {
+		if !op.isPush() {
+			return fmt.Errorf("%w: unlocking script must only push data", ErrBadScript)
+		}
+	}
+
+	vm := &scriptEngine{tx: tx, inIdx: inIdx}
+	err = vm.execute(scriptSig)
+	if err != nil {
+		return err
+	}
+	unlockStack := append([][]byte{}, vm.stack...)
+	err = vm.execute(scriptPubKey)
+	if err != nil {
+		return err
+	}
+	err = vm.checkResult()
+	if err != nil {
+		return err
+	}
+
+	// a pay-to-script-hash output only checks that the last value pushed is the redeem script,
+	// which then runs on the rest of the values pushed by the unlocking script
+	if scriptPubKey.Class() == ScriptHashClass {
+		vm.stack = unlockStack
+		redeemScript, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		err = vm.execute(redeemScript)
+		if err != nil {
+			return err
+		}
+		return vm.checkResult()
+	}
+	return nil
+}
+
+// checkResult fails unless the script left a true value on top of the stack
+func (vm *scriptEngine) checkResult() error {
+	if len(vm.stack) == 0 || !asBool(vm.stack[len(vm.stack)-1]) {
+		return ErrScriptFailed
+	}
+	return nil
+}
+
+// execute runs the script on the stack left by the previous one
+func (vm *scriptEngine) execute(script Script) error {
+	ops, err := parseScript(script)
+	if err != nil {
+		return err
+	}
+
+	vm.script = script
+	vm.conditions = nil
+	for _, op := range ops {
+		err = vm.step(op)
+		if err != nil {
+			return err
+		}
+		if len(vm.stack) > maxStackSize {
+			return fmt.Errorf("%w: stack holds more than %d values", ErrBadScript, maxStackSize)
+		}
+	}
+	if len(vm.conditions) != 0 {
+		return fmt.Errorf("%w: OP_IF without OP_ENDIF", ErrBadScript)
+	}
+	return nil
+}
+
+// executing reports whether the current branch of the script runs
+func (vm *scriptEngine) executing() bool {
+	for _, condition := range vm.conditions {
+		if !condition {
+			return false
+		}
+	}
+	return true
+}
+
+func (vm *scriptEngine) step(op parsedOp) error {
+	if len(op.data) > maxScriptElementSize {
+		return fmt.Errorf("%w: push of %d bytes is over the %d bytes limit", ErrBadScript, len(op.data), maxScriptElementSize)
+	}
+
+	// flow control runs in every branch to keep track of the nesting
+	switch op.opcode {
+	case OpIf, OpNotIf:
+		condition := false
+		if vm.executing() {
+			value, err := vm.pop()
+			if err != nil {
+				return err
+			}
+			condition = asBool(value) == (op.opcode == OpIf)
+		}
+		vm.conditions = append(vm.conditions, condition)
+		return nil
+	case OpElse:
+		if len(vm.conditions) == 0 {
+			return fmt.Errorf("%w: OP_ELSE without OP_IF", ErrBadScript)
+		}
+		vm.conditions[len(vm.conditions)-1] = !vm.conditions[len(vm.conditions)-1]
+		return nil
+	case OpEndIf:
+		if len(vm.conditions) == 0 {
+			return fmt.Errorf("%w: OP_ENDIF without OP_IF", ErrBadScript)
+		}
+		vm.conditions = vm.conditions[:len(vm.conditions)-1]
+		return nil
+	}
+	if !vm.executing() {
+		return nil
+	}
+
+	switch {
+	case op.opcode <= OpPushData2:
+		vm.push(op.data)
+		return nil
+	case op.opcode >= Op1 && op.opcode <= Op16:
+		vm.push([]byte{op.opcode - Op1 + 1})
+		return nil
+	}
+
+	switch op.opcode {
+	case OpVerify:
+		return vm.verify()
+	case OpReturn:
+		return fmt.Errorf("%w: OP_RETURN", ErrScriptFailed)
+	case OpDrop:
+		_, err := vm.pop()
+		return err
+	case OpDup:
+		value, err := vm.peek()
+		if err != nil {
+			return err
+		}
+		vm.push(value)
+	case OpEqual, OpEqualVerify:
+		a, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		b, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		vm.pushBool(bytes.Equal(a, b))
+		if op.opcode == OpEqualVerify {
+			return vm.verify()
+		}
+	case OpSHA256:
+		value, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(value)
+		vm.push(hash[:])
+	case OpHash160:
+		value, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		vm.push(wallet.HashPubKey(value))
+	case OpCheckSig, OpCheckSigVerify:
+		pubKey, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		sig, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		valid, err := vm.checkSig(sig, pubKey)
+		if err != nil {
+			return err
+		}
+		vm.pushBool(valid)
+		if op.opcode == OpCheckSigVerify {
+			return vm.verify()
+		}
+	case OpCheckMultiSig, OpCheckMultiSigVerify:
+		valid, err := vm.checkMultiSig()
+		if err != nil {
+			return err
+		}
+		vm.pushBool(valid)
+		if op.opcode == OpCheckMultiSigVerify {
+			return vm.verify()
+		}
+	case OpCheckLockTimeVerify:
+		lockTime, err := vm.peekLockNum()
+		if err != nil {
+			return err
+		}
+		return vm.checkLockTime(lockTime)
+	case OpCheckSequenceVerify:
+		sequence, err := vm.peekLockNum()
+		if err != nil {
+			return err
+		}
+		// like Bitcoin, a disabled lock leaves room for future rules
+		if uint32(sequence)&SequenceLockTimeDisabled != 0 {
+			return nil
+		}
+		return vm.checkSequence(sequence)
+	default:
+		return fmt.Errorf("%w: unknown opcode %02x", ErrBadScript, op.opcode)
+	}
+	return nil
+}
+
+// checkSig verifies a signature of the input, the last byte of the signature is its hash type.
+// The public key must be X followed by Y on 32 bytes each, see wallet.MarshalPublicKey.
+func (vm *scriptEngine) checkSig(sig, pubKey []byte) (bool, error) {
+	if len(sig) != 2*32+1 || SigHashType(sig[len(sig)-1]) != SigHashAll || len(pubKey) != wallet.PublicKeyLen {
+		return false, nil
+	}
+	hash, err := vm.tx.signatureHash(vm.inIdx, vm.script, SigHashAll)
+	if err != nil {
+		return false, err
+	}
+
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:64])
+	x := new(big.Int).SetBytes(pubKey[:32])
+	y := new(big.Int).SetBytes(pubKey[32:])
+	rawPubKey := ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	return ecdsa.Verify(&rawPubKey, hash, r, s), nil
+}
+
+// checkMultiSig pops the public keys and the signatures of OP_CHECKMULTISIG and checks them.
+// Every signature must match one of the keys, in the order of the keys.
+func (vm *scriptEngine) checkMultiSig() (bool, error) {
+	n, err := vm.popSmallInt(maxPubKeysPerMultiSig)
+	if err != nil {
+		return false, err
+	}
+	pubKeys := make([][]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		pubKeys[i], err = vm.pop()
+		if err != nil {
+			return false, err
+		}
+	}
+	m, err := vm.popSmallInt(n)
+	if err != nil {
+		return false, err
+	}
+	sigs := make([][]byte, m)
+	for i := m - 1; i >= 0; i-- {
+		sigs[i], err = vm.pop()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	key := 0
+	for _, sig := range sigs {
+		for ; key < len(pubKeys); key++ {
+			valid, err := vm.checkSig(sig, pubKeys[key])
+			if err != nil {
+				return false, err
+			}
+			if valid {
+				break
+			}
+		}
+		if key == len(pubKeys) {
+			return false, nil
+		}
+		key++
+	}
+	return true, nil
+}
+
+// peekLockNum returns the time lock on top of the stack, a non negative number of up to 5 bytes
+func (vm *scriptEngine) peekLockNum() (int64, error) {
+	value, err := vm.peek()
+	if err != nil {
+		return 0, err
+	}
+	n, err := scriptNum(value, maxLockTimeSize)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("%w: negative lock time %d", ErrScriptFailed, n)
+	}
+	return n, nil
+}
+
+// checkLockTime fails unless the transaction lock time is at least lockTime and of the same kind,
+// a height or a time. The block validation makes sure the transaction lock time is reached.
+func (vm *scriptEngine) checkLockTime(lockTime int64) error {
+	txLockTime := int64(vm.tx.LockTime)
+	if (lockTime < LockTimeThreshold) != (txLockTime < LockTimeThreshold) {
+		return fmt.Errorf("%w: lock time %d and transaction lock time %d are not of the same kind", ErrScriptFailed, lockTime, txLockTime)
+	}
+	if lockTime > txLockTime {
+		return fmt.Errorf("%w: lock time %d is after the transaction lock time %d", ErrScriptFailed, lockTime, txLockTime)
+	}
+	// a final input would disable the transaction lock time
+	if vm.tx.Vin[vm.inIdx].Sequence == SequenceFinal {
+		return fmt.Errorf("%w: input sequence is final, the lock time does not apply", ErrScriptFailed)
+	}
+	return nil
+}
+
+// checkSequence fails unless the relative lock of the input is at least sequence and of the same kind,
+// a number of blocks or a time. The block validation makes sure the relative lock of the input is reached.
+func (vm *scriptEngine) checkSequence(sequence int64) error {
+	txSequence := int64(vm.tx.Vin[vm.inIdx].Sequence)
+	if txSequence&int64(SequenceLockTimeDisabled) != 0 {
+		return fmt.Errorf("%w: relative lock of the input is disabled", ErrScriptFailed)
+	}
+
+	mask := int64(SequenceLockTimeIsSeconds | SequenceLockTimeMask)
+	lock, txLock := sequence&mask, txSequence&mask
+	if (lock < int64(SequenceLockTimeIsSeconds)) != (txLock < int64(SequenceLockTimeIsSeconds)) {
+		return fmt.Errorf("%w: relative locks %x and %x are not of the same kind", ErrScriptFailed, lock, txLock)
+	}
+	if lock > txLock {
+		return fmt.Errorf("%w: relative lock %x is over the one of the input %x", ErrScriptFailed, lock, txLock)
+	}
+	return nil
+}
+
+// popSmallInt pops a count pushed with Op0 to Op16, it must not be above max
+func (vm *scriptEngine) popSmallInt(max int) (int, error) {
+	value, err := vm.pop()
+	if err != nil {
+		return 0, err
+	}
+	if len(value) > 1 || (len(value) == 1 && int(value[0]) > max) {
+		return 0, fmt.Errorf("%w: count %x is out of range", ErrBadScript, value)
+	}
+	if len(value) == 0 {
+		return 0, nil
+	}
+	return int(value[0]), nil
+}
+
+func (vm *scriptEngine) push(value []byte) {
+	vm.stack = append(vm.stack, value)
+}
+
+func (vm *scriptEngine) pushBool(value bool) {
+	if value {
+		vm.push([]byte{1})
+	} else {
+		vm.push(nil)
+	}
+}
+
+func (vm *scriptEngine) peek() ([]byte, error) {
+	if len(vm.stack) == 0 {
+		return nil, fmt.Errorf("%w: stack is empty", ErrBadScript)
+	}
+	return vm.stack[len(vm.stack)-1], nil
+}
+
+func (vm *scriptEngine) pop() ([]byte, error) {
+	value, err := vm.peek()
+	if err != nil {
+		return nil, err
+	}
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return value, nil
+}
+
+// verify pops the top value and fails the script if it is false
+func (vm *scriptEngine) verify() error {
+	value, err := vm.pop()
+	if err != nil {
+		return err
+	}
+	if !asBool(value) {
+		return fmt.Errorf("%w: verify failed", ErrScriptFailed)
+	}
+	return nil
+}
+
+// asBool converts a stack value to a boolean: any non zero value is true, except negative zero
+func asBool(value []byte) bool {
+	for i, b := range value {
+		if b != 0 {
+			return i != len(value)-1 || b != 0x80
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Store is the database behind Blockchain and UTXOSet.
+// Data is organized in named buckets of key/value pairs, like BoltDB:
+//   - blocks, headers, blockindex and undo hold the block store
+//   - chainstate holds the UTXO set
+//   - heightindex maps the heights of the main chain to their block
+//   - txindex optionally maps transaction ids to their block
+//   - meta holds the chain metadata such as the tip and the network
+//
+// All the reads and writes go through transactions, so that connecting or disconnecting
+// a block updates every bucket atomically.
+type Store interface {
+	// View runs fn in a read-only transaction
+	View(fn func(tx StoreTx) error) error
+	// Update runs fn in a read-write transaction, the changes are committed only if fn returns nil
+	Update(fn func(tx StoreTx) error) error
+	Close() error
+}
+
+// StoreTx is a transaction of a Store
+type StoreTx interface {
+	// Bucket returns the bucket with the given name, it is created on first write
+	Bucket(name string) Bucket
+	// DeleteBucket removes a bucket and all its keys
+	DeleteBucket(name string) error
+}
+
+// Bucket is a set of key/value pairs.
+// Values returned by Get and ForEach are only valid during the transaction.
+type Bucket interface {
+	Get(key []byte) []byte
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	// ForEach calls fn for every pair in key order, iteration stops if fn returns an error
+	ForEach(fn func(k, v []byte) error) error
+}
+
+const metaBucket = "meta"
+
+// tipKey is the metadata key of the hash of the last block of the main chain
+var tipKey = []byte("l")
+
+func getTip(tx StoreTx) []byte {
+	return tx.Bucket(metaBucket).Get(tipKey)
+}
+
+func putTip(tx StoreTx, hash []byte) error {
+	return tx.Bucket(metaBucket).Put(tipKey, hash)
+}
+
+// networkKey is the metadata key of the name of the network the chain belongs to
+var networkKey = []byte("net")
+
+// checkNetwork fails if the chain was created for another network,
+// chains created before the key was added are assumed to match
+func checkNetwork(tx StoreTx, params *ChainParams) error {
+	name := tx.Bucket(metaBucket).Get(networkKey)
+	if name != nil && string(name) != params.Name {
+		return fmt.Errorf("%w: %s, want %s", ErrWrongNetwork, name, params.Name)
+	}
+	return nil
+}
+
+func putNetwork(tx StoreTx, params *ChainParams) error {
+	return tx.Bucket(metaBucket).Put(networkKey, []byte(params.Name))
+}
+
+var errReadOnlyTx = errors.New("cannot write in a read-only transaction")
+
+// errStopIteration is returned from a ForEach callback to stop iterating early
+var errStopIteration = errors.New("stop iteration")
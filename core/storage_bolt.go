@@ -0,0 +1,89 @@
+package core
+
+import (
+	"github.com/boltdb/bolt"
+)
+
+// boltStore is a Store persisted in a BoltDB file
+type boltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore opens or creates the BoltDB file at path
+func OpenBoltStore(path string) (Store, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &boltStore{db}, nil
+}
+
+func (s *boltStore) View(fn func(tx StoreTx) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return fn(&boltTx{tx})
+	})
+}
+
+func (s *boltStore) Update(fn func(tx StoreTx) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(&boltTx{tx})
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+func (t *boltTx) Bucket(name string) Bucket {
+	return &boltBucket{t.tx, []byte(name)}
+}
+
+func (t *boltTx) DeleteBucket(name string) error {
+	err := t.tx.DeleteBucket([]byte(name))
+	if err == bolt.ErrBucketNotFound {
+		return nil
+	}
+	return err
+}
+
+// boltBucket resolves the bolt bucket lazily, so buckets do not have to be created up front
+type boltBucket struct {
+	tx   *bolt.Tx
+	name []byte
+}
+
+func (b *boltBucket) Get(key []byte) []byte {
+	bucket := b.tx.Bucket(b.name)
+	if bucket == nil {
+		return nil
+	}
+	return bucket.Get(key)
+}
+
+func (b *boltBucket) Put(key, value []byte) error {
+	bucket, err := b.tx.CreateBucketIfNotExists(b.name)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, value)
+}
+
+func (b *boltBucket) Delete(key []byte) error {
+	bucket := b.tx.Bucket(b.name)
+	if bucket == nil {
+		return nil
+	}
+	return bucket.Delete(key)
+}
+
+func (b *boltBucket) ForEach(fn func(k, v []byte) error) error {
+	bucket := b.tx.Bucket(b.name)
+	if bucket == nil {
+		return nil
+	}
+	return bucket.ForEach(fn)
+}
@@ -0,0 +1,154 @@
+package core
+
+import (
+	"sort"
+	"sync"
+)
+
+// memoryStore is a Store kept in memory, for tests and simulations that should not create db files.
+// Writes of an Update are staged and only applied to the data when the transaction succeeds.
+type memoryStore struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string][]byte
+}
+
+// NewMemoryStore creates an empty in-memory Store
+func NewMemoryStore() Store {
+	return &memoryStore{buckets: make(map[string]map[string][]byte)}
+}
+
+func (s *memoryStore) View(fn func(tx StoreTx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return fn(&memoryTx{store: s})
+}
+
+func (s *memoryStore) Update(fn func(tx StoreTx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &memoryTx{store: s, writable: true, pending: make(map[string]map[string][]byte), deleted: make(map[string]bool)}
+	err := fn(tx)
+	if err != nil {
+		return err
+	}
+	tx.commit()
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+// memoryTx stages the writes of an Update: pending holds the new values per bucket
+// (a nil value marks a deleted key) and deleted the buckets dropped by the transaction
+type memoryTx struct {
+	store    *memoryStore
+	writable bool
+	pending  map[string]map[string][]byte
+	deleted  map[string]bool
+}
+
+func (t *memoryTx) Bucket(name string) Bucket {
+	return &memoryBucket{t, name}
+}
+
+func (t *memoryTx) DeleteBucket(name string) error {
+	if !t.writable {
+		return errReadOnlyTx
+	}
+	t.deleted[name] = true
+	delete(t.pending, name)
+	return nil
+}
+
+func (t *memoryTx) commit() {
+	for name := range t.deleted {
+		delete(t.store.buckets, name)
+	}
+	for name, changes := range t.pending {
+		bucket := t.store.buckets[name]
+		if bucket == nil {
+			bucket = make(map[string][]byte)
+			t.store.buckets[name] = bucket
+		}
+		for k, v := range changes {
+			if v == nil {
+				delete(bucket, k)
+			} else {
+				bucket[k] = v
+			}
+		}
+	}
+}
+
+type memoryBucket struct {
+	tx   *memoryTx
+	name string
+}
+
+func (b *memoryBucket) Get(key []byte) []byte {
+	if v, ok := b.tx.pending[b.name][string(key)]; ok {
+		return v
+	}
+	if b.tx.deleted[b.name] {
+		return nil
+	}
+	return b.tx.store.buckets[b.name][string(key)]
+}
+
+func (b *memoryBucket) Put(key, value []byte) error {
+	if !b.tx.writable {
+		return errReadOnlyTx
+	}
+	changes := b.tx.pending[b.name]
+	if changes == nil {
+		changes = make(map[string][]byte)
+		b.tx.pending[b.name] = changes
+	}
+	// copy the value, the caller may reuse its buffer
+	changes[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (b *memoryBucket) Delete(key []byte) error {
+	if !b.tx.writable {
+		return errReadOnlyTx
+	}
+	changes := b.tx.pending[b.name]
+	if changes == nil {
+		changes = make(map[string][]byte)
+		b.tx.pending[b.name] = changes
+	}
+	changes[string(key)] = nil
+	return nil
+}
+
+func (b *memoryBucket) ForEach(fn func(k, v []byte) error) error {
+	keys := make(map[string]bool)
+	if !b.tx.deleted[b.name] {
+		for k := range b.tx.store.buckets[b.name] {
+			keys[k] = true
+		}
+	}
+	for k := range b.tx.pending[b.name] {
+		keys[k] = true
+	}
+
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	for _, k := range sorted {
+		v := b.Get([]byte(k))
+		if v == nil {
+			continue
+		}
+		if err := fn([]byte(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,15 +2,13 @@ package core
 
 import (
 	"blockchain-from-scratch/core/wallet"
+	"bytes"
 	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"github.com/vmihailenco/msgpack/v5"
-	"log"
-	"math/big"
 	"time"
 
 	"github.com/google/uuid"
@@ -26,15 +24,17 @@ type Transaction struct {
 	ID   []byte
 	Vin  []TxInput
 	VOut []TxOutput
+	// LockTime is the block height, or the unix time from LockTimeThreshold on, the transaction
+	// cannot be mined before. 0 means no lock, see IsFinal.
+	LockTime uint32
 }
 
-// subsidy is the amount of reward.
-// In Bitcoin, this number is not stored anywhere and calculated based only on the total number of blocks: the number of blocks is divided by 210000.
-// Mining the genesis block produced 50 BTC, and every 210000 blocks the reward is halved.
-// In our implementation, we’ll store the reward as a constant
-const subsidy = 10
-
-func NewCoinbaseTx(to, data string) *Transaction {
+// NewCoinbaseTx creates the transaction paying the reward of a block to the miner.
+// The reward is the block subsidy plus the fees collected in the block.
+// In Bitcoin, the subsidy is not stored anywhere and calculated based only on the height of the block:
+// mining the genesis block produced 50 BTC, and every 210000 blocks the reward is halved.
+// See ChainParams.CalcBlockSubsidy for our schedule.
+func NewCoinbaseTx(to, data string, reward int) (*Transaction, error) {
 	if data == "" {
 		// using random number generation to ensure uniqueness of data and transaction ID uniqueness
 		timestamp := time.Now().Unix()
@@ -42,158 +42,261 @@ func NewCoinbaseTx(to, data string) *Transaction {
 		data = fmt.Sprintf("Reward to '%s' at %d with UUID %s", to, timestamp, randomUUID)
 	}
 
-	txin := TxInput{[]byte{}, -1, nil, []byte(data)}
-	txout := NewTXOutput(subsidy, to)
-	tx := Transaction{nil, []TxInput{txin}, []TxOutput{*txout}}
-	tx.ID = tx.Hash()
+	// the unlocking script of a coinbase is never run, it carries the data
+	txin := TxInput{[]byte{}, -1, Script(data), SequenceFinal}
+	txout, err := NewTXOutput(reward, to)
+	if err != nil {
+		return nil, err
+	}
+	tx := Transaction{nil, []TxInput{txin}, []TxOutput{*txout}, 0}
+	tx.ID, err = tx.Hash()
+	if err != nil {
+		return nil, err
+	}
 	logrus.Infof("NewCoinbaseTx to '%s'", to)
-	return &tx
+	return &tx, nil
+}
+
+// NewUTXOTransaction creates a signed transaction sending amount from the wallet to the address.
+// The fee is left out of the outputs for the miner to collect, the rest of the inputs goes back to the wallet.
+// It returns ErrInsufficientFunds if the wallet does not own enough unspent outputs.
+func NewUTXOTransaction(nodeWallet *wallet.Wallet, to string, amount, fee int, UTXOSet *UTXOSet) (*Transaction, error) {
+	out, err := NewTXOutput(amount, to)
+	if err != nil {
+		return nil, err
+	}
+	return NewUTXOTransactionToScript(nodeWallet, out.ScriptPubKey, amount, fee, UTXOSet)
+}
+
+// NewUTXOTransactionToScript is NewUTXOTransaction paying to any locking script, like a time-locked one.
+// Spent time-locked outputs of the wallet set the lock time and the sequences of the transaction they need.
+func NewUTXOTransactionToScript(nodeWallet *wallet.Wallet, scriptPubKey Script, amount, fee int, UTXOSet *UTXOSet) (*Transaction, error) {
+	changeScript := PayToPubKeyHashScript(wallet.HashPubKey(nodeWallet.PublicKey))
+	return NewUTXOTransactionWithChange(nodeWallet, scriptPubKey, amount, fee, changeScript, UTXOSet)
 }
 
-func NewUTXOTransaction(nodeWallet *wallet.Wallet, to string, amount int, UTXOSet *UTXOSet) *Transaction {
+// NewUTXOTransactionWithChange is NewUTXOTransactionToScript sending the rest of the inputs to changeScript,
+// like a key of the change chain of an HD wallet, instead of back to the wallet.
+func NewUTXOTransactionWithChange(nodeWallet *wallet.Wallet, scriptPubKey Script, amount, fee int, changeScript Script, UTXOSet *UTXOSet) (*Transaction, error) {
 	var inputs []TxInput
 	var outputs []TxOutput
 
+	if fee < 0 {
+		return nil, fmt.Errorf("%w: negative fee %d", ErrBadTxOutValue, fee)
+	}
 	pubKeyHash := wallet.HashPubKey(nodeWallet.PublicKey)
-	acc, validOutputs := UTXOSet.FindSpendableOutputs(pubKeyHash, amount)
-	if acc < amount {
-		log.Panic("Error: Not enough funds")
+	// a transaction spends at least one output, even to carry data for free, so its id is unique
+	need := max(amount+fee, 1)
+	acc, validOutputs, err := UTXOSet.FindSpendableOutputs(pubKeyHash, need)
+	if err != nil {
+		return nil, err
+	}
+	if acc < need {
+		return nil, fmt.Errorf("%w: have %d, need %d", ErrInsufficientFunds, acc, need)
 	}
 
+	lockTime := uint32(0)
 	for txId, outs := range validOutputs {
 		txID, err := hex.DecodeString(txId)
 		if err != nil {
-			log.Panic(err)
+			return nil, err
 		}
 		for _, out := range outs {
-			input := TxInput{txID, out, nil, nodeWallet.PublicKey}
+			entry, err := UTXOSet.GetUTXO(OutPoint{txID, out})
+			if err != nil {
+				return nil, err
+			}
+			sequence := SequenceFinal
+			if outLockTime, ok := entry.ScriptPubKey.LockTime(); ok {
+				// OP_CHECKLOCKTIMEVERIFY needs the transaction lock time to apply
+				sequence = SequenceFinal - 1
+				lockTime = max(lockTime, outLockTime)
+			} else if outSequence, ok := entry.ScriptPubKey.SequenceLock(); ok {
+				sequence = outSequence
+			}
+			input := TxInput{txID, out, nil, sequence}
 			inputs = append(inputs, input)
 		}
 	}
 
 	from := string(nodeWallet.GetAddress())
-	logrus.Infof("NewUTXOTransaction from '%s' to '%s' amount %d", from, to, acc)
+	logrus.Infof("NewUTXOTransaction from '%s' to script '%s' amount %d fee %d", from, scriptPubKey, amount, fee)
+	change := acc - amount - fee
 	// Ensure correct balance when transferring to self
-	if from == to {
-		outputs = append(outputs, *NewTXOutput(acc, from))
+	if bytes.Equal(scriptPubKey, changeScript) {
+		outputs = append(outputs, TxOutput{amount + change, changeScript})
 	} else {
-		outputs = append(outputs, *NewTXOutput(amount, to))
-		if acc > amount {
-			outputs = append(outputs, *NewTXOutput(acc-amount, from))
+		outputs = append(outputs, TxOutput{amount, scriptPubKey})
+		if change > 0 {
+			outputs = append(outputs, TxOutput{change, changeScript})
 		}
 	}
-	tx := Transaction{nil, inputs, outputs}
+	tx := Transaction{nil, inputs, outputs, lockTime}
 	//utils.PrintJsonLog(tx, "NewUTXOTransaction")
-	tx.ID = tx.Hash()
+	tx.ID, err = tx.Hash()
+	if err != nil {
+		return nil, err
+	}
 	// need SignTransaction
-	UTXOSet.Blockchain.SignTransaction(&tx, nodeWallet.PrivateKey)
-	return &tx
+	err = UTXOSet.Blockchain.SignTransaction(&tx, nodeWallet.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return &tx, nil
 }
 
-// Hash returns the hash of the Transaction
-func (tx *Transaction) Hash() []byte {
-	var hash [32]byte
+// OutputValue returns the sum of the values of the outputs,
+// it fails with ErrValueOverflow when a value is negative or the sum does not fit in an int
+func (tx *Transaction) OutputValue() (int, error) {
+	total := 0
+	for _, out := range tx.VOut {
+		var err error
+		total, err = addValue(total, out.Value)
+		if err != nil {
+			return 0, fmt.Errorf("outputs of transaction %x: %w", tx.ID, err)
+		}
+	}
+	return total, nil
+}
 
+// Hash returns the hash of the Transaction
+func (tx *Transaction) Hash() ([]byte, error) {
 	txCopy := *tx
 	txCopy.ID = []byte{}
 
-	hash = sha256.Sum256(txCopy.Serialize())
+	data, err := txCopy.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(data)
+	return hash[:], nil
+}
 
-	return hash[:]
+// unsignedHash returns the hash of the transaction with its unlocking scripts removed.
+// The ID of a transaction is computed before its inputs are signed, so this is what the ID must match.
+// The unlocking script of a coinbase is its data, it stays in the ID so every coinbase has a different one.
+func (tx *Transaction) unsignedHash() ([]byte, error) {
+	if tx.IsCoinbase() {
+		return tx.Hash()
+	}
+	txCopy := tx.TrimmedCopy()
+	return txCopy.Hash()
 }
 
 // Serialize returns a serialized Transaction
-func (tx Transaction) Serialize() []byte {
+func (tx Transaction) Serialize() ([]byte, error) {
 	result, err := msgpack.Marshal(tx)
 	if err != nil {
-		log.Panic(err)
+		return nil, fmt.Errorf("serialize transaction %x: %w", tx.ID, err)
 	}
-	return result
+	return result, nil
 }
 
-func (tx *Transaction) Sign(priKey ecdsa.PrivateKey, prevTXs map[string]Transaction) {
-	if tx.IsCoinbase() {
-		return
-	}
+// SigHashType tells which parts of the transaction a signature commits to, it is the last byte of a signature
+type SigHashType byte
+
+// SigHashAll signs all the inputs and outputs, the only type supported for now
+const SigHashAll SigHashType = 0x01
 
-	// Create a trimmed copy of the transaction for signing
-	// Using txCopy is to isolate the signing data and prevent transaction malleability issues
+// signatureHash returns the hash the signature of input inIdx commits to: the transaction without
+// its unlocking scripts, the script being satisfied in place of the unlocking script of the input,
+// and the hash type. Signing the locking script of the spent output prevents reusing the signature elsewhere.
+func (tx *Transaction) signatureHash(inIdx int, subscript Script, hashType SigHashType) ([]byte, error) {
 	txCopy := tx.TrimmedCopy()
+	txCopy.ID = nil
+	txCopy.Vin[inIdx].ScriptSig = subscript
+	data, err := txCopy.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(append(data, byte(hashType)))
+	return hash[:], nil
+}
 
-	for inId, vin := range txCopy.Vin {
+// Sign builds the unlocking scripts of the inputs spending pay-to-pubkey-hash, time-locked pay-to-pubkey-hash
+// and pay-to-pubkey outputs of the private key owner. prevTXs holds the transactions the inputs spend, keyed by their hex id.
+func (tx *Transaction) Sign(priKey ecdsa.PrivateKey, prevTXs map[string]Transaction) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
+	pubKey := wallet.MarshalPublicKey(&priKey.PublicKey)
+
+	for inId, vin := range tx.Vin {
 		// Retrieve the previous transaction corresponding to the current input
-		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
-		prepareForSigning(&txCopy, inId, &prevTx)
-		dataToSign := txCopy.ID
-		txCopy.Vin[inId].Signature = nil
-		txCopy.Vin[inId].PubKey = prevTx.VOut[vin.Vout].PubKeyHash
-		//dataToSign := []byte(fmt.Sprintf("%x\n", txCopy))
-		// Sign the transaction ID with the private key
-		r, s, err := ecdsa.Sign(rand.Reader, &priKey, dataToSign)
+		prevTx, ok := prevTXs[hex.EncodeToString(vin.Txid)]
+		if !ok || vin.Vout < 0 || vin.Vout >= len(prevTx.VOut) {
+			return fmt.Errorf("%w: %x:%d", ErrMissingTxOut, vin.Txid, vin.Vout)
+		}
+		scriptPubKey := prevTx.VOut[vin.Vout].ScriptPubKey
+
+		sig, err := tx.signInput(inId, scriptPubKey, priKey)
 		if err != nil {
-			log.Panic(err)
+			return err
+		}
+		switch class := scriptPubKey.Class(); class {
+		case PubKeyHashClass, LockTimeClass, SequenceLockClass:
+			tx.Vin[inId].ScriptSig = NewScriptBuilder().AddData(sig).AddData(pubKey).Script()
+		case PubKeyClass:
+			tx.Vin[inId].ScriptSig = NewScriptBuilder().AddData(sig).Script()
+		default:
+			return fmt.Errorf("%w: cannot sign input %d spending a %s output", ErrBadScript, inId, class)
 		}
-		// Append the signature to the original transaction's input
-		tx.Vin[inId].Signature = append(r.Bytes(), s.Bytes()...)
 	}
-
+	return nil
 }
 
-func prepareForSigning(txCopy *Transaction, inId int, prevTx *Transaction) {
-	txCopy.Vin[inId].Signature = nil
-	txCopy.Vin[inId].PubKey = prevTx.Hash()
-	txCopy.ID = txCopy.Hash()
-	txCopy.Vin[inId].PubKey = nil
+// signInput returns the signature of input inId satisfying subscript: r and s padded to 32 bytes,
+// followed by the hash type
+func (tx *Transaction) signInput(inId int, subscript Script, priKey ecdsa.PrivateKey) ([]byte, error) {
+	hash, err := tx.signatureHash(inId, subscript, SigHashAll)
+	if err != nil {
+		return nil, err
+	}
+	r, s, err := ecdsa.Sign(rand.Reader, &priKey, hash)
+	if err != nil {
+		return nil, fmt.Errorf("sign input %d: %w", inId, err)
+	}
+	sig := make([]byte, 2*32+1)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:64])
+	sig[64] = byte(SigHashAll)
+	return sig, nil
 }
 
-func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
-	txCopy := tx.TrimmedCopy()
-	curve := elliptic.P256()
+// Verify runs the unlocking script of every input against the locking script of the output it spends.
+// prevTXs holds the transactions the inputs spend, keyed by their hex id.
+func (tx *Transaction) Verify(prevTXs map[string]Transaction) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
 
 	for inId, vin := range tx.Vin {
-		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
-		prepareForSigning(&txCopy, inId, &prevTx)
-		dataToVerify := txCopy.ID
-		//utils.PrintJsonLog(&prevTx, "Verify")
-		//txCopy.Vin[inId].Signature = nil
-		//txCopy.Vin[inId].PubKey = prevTx.VOut[vin.Vout].PubKeyHash
-		//dataToVerify := []byte(fmt.Sprintf("%x\n", txCopy))
-
-		r := big.Int{}
-		s := big.Int{}
-		sigLen := len(vin.Signature)
-		r.SetBytes(vin.Signature[:(sigLen / 2)])
-		s.SetBytes(vin.Signature[(sigLen / 2):])
-		// logrus.Infof("r '%x': , s '%x', id: '%x'", r.Bytes(), s.Bytes(), txCopy.ID)
-
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(vin.PubKey)
-		x.SetBytes(vin.PubKey[:(keyLen / 2)])
-		y.SetBytes(vin.PubKey[(keyLen / 2):])
-
-		rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
-		if !ecdsa.Verify(&rawPubKey, dataToVerify, &r, &s) {
-			return false
+		prevTx, ok := prevTXs[hex.EncodeToString(vin.Txid)]
+		if !ok || vin.Vout < 0 || vin.Vout >= len(prevTx.VOut) {
+			return fmt.Errorf("%w: %x:%d", ErrMissingTxOut, vin.Txid, vin.Vout)
+		}
+		err := verifyScript(vin.ScriptSig, prevTx.VOut[vin.Vout].ScriptPubKey, tx, inId)
+		if err != nil {
+			return fmt.Errorf("input %d: %w", inId, err)
 		}
 	}
-
-	return true
+	return nil
 }
 
+// TrimmedCopy returns a copy of the transaction without the unlocking scripts
 func (tx *Transaction) TrimmedCopy() Transaction {
 	var inputs []TxInput
 	var outputs []TxOutput
 
 	for _, vin := range tx.Vin {
-		inputs = append(inputs, TxInput{vin.Txid, vin.Vout, nil, nil})
+		inputs = append(inputs, TxInput{vin.Txid, vin.Vout, nil, vin.Sequence})
 	}
 
 	for _, vout := range tx.VOut {
-		outputs = append(outputs, TxOutput{vout.Value, vout.PubKeyHash})
+		outputs = append(outputs, TxOutput{vout.Value, vout.ScriptPubKey})
 	}
 
-	txCopy := Transaction{tx.ID, inputs, outputs}
+	txCopy := Transaction{tx.ID, inputs, outputs, tx.LockTime}
 
 	return txCopy
 }
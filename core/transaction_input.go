@@ -1,20 +1,13 @@
 package core
 
-import (
-	"blockchain-from-scratch/core/wallet"
-	"bytes"
-)
-
 // TxInput represents an input in a transaction.
 type TxInput struct {
-	Txid      []byte
-	Vout      int
-	Signature []byte
-	PubKey    []byte
-}
-
-func (in *TxInput) UsesKey(publicHash []byte) bool {
-	lockingHash := wallet.HashPubKey(in.PubKey)
-
-	return bytes.Equal(lockingHash, publicHash)
+	Txid []byte
+	Vout int
+	// ScriptSig is the unlocking script, it pushes the data satisfying the locking script of the spent output.
+	// The one of a coinbase holds arbitrary data instead.
+	ScriptSig Script
+	// Sequence is SequenceFinal for most inputs. A lower value lets the lock time of the transaction apply,
+	// unless SequenceLockTimeDisabled is set it is also a lock relative to the output spent, see sequenceLockReached.
+	Sequence uint32
 }
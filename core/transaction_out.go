@@ -7,30 +7,44 @@ import (
 
 // TXOutput represents a transaction output
 type TxOutput struct {
-	Value      int
-	PubKeyHash []byte
-}
-type TXOutputs struct {
-	Outputs []TxOutput
+	Value int
+	// ScriptPubKey is the locking script, the conditions to satisfy to spend the output
+	ScriptPubKey Script
 }
 
-func (out *TxOutput) Lock(address []byte) {
-	pubKeyHash := wallet.Base58Decode(address)
-	// Slicing [1:len(pubKeyHash) - 4] here is to remove the version byte and checksum.
-	// The version byte is usually the first byte, and the checksum is usually the last four bytes. See field version/addressChecksumLen.
-	// The version byte is 0x00, which is one byte. In hexadecimal, each pair (e.g., 00) represents 1 byte.
-	out.PubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
+// Lock sets the locking script paying to the address, a public key hash or a script hash.
+// It returns wallet.ErrInvalidAddress if the address is not valid on the network.
+func (out *TxOutput) Lock(address []byte) error {
+	hash, isScript, err := wallet.DecodeAddress(string(address))
+	if err != nil {
+		return err
+	}
+	if isScript {
+		out.ScriptPubKey = PayToScriptHashScript(hash)
+	} else {
+		out.ScriptPubKey = PayToPubKeyHashScript(hash)
+	}
+	return nil
 }
 
+// IsLockedWithKey checks if the output pays to the owner of the public key hash,
+// with a pay-to-pubkey-hash or a pay-to-pubkey script
 func (out *TxOutput) IsLockedWithKey(pubKeyHash []byte) bool {
-	return bytes.Equal(out.PubKeyHash, pubKeyHash)
+	owner := out.ScriptPubKey.PubKeyHash()
+	return owner != nil && bytes.Equal(owner, pubKeyHash)
 }
 
-// NewTXOutput create a new TXOutput
-func NewTXOutput(value int, address string) *TxOutput {
-	txo := &TxOutput{value, nil}
-	txo.Lock([]byte(address))
-
-	return txo
+// IsLockedWithScript checks if the output pays to the hash of the redeem script
+func (out *TxOutput) IsLockedWithScript(scriptHash []byte) bool {
+	owner := out.ScriptPubKey.ScriptHash()
+	return owner != nil && bytes.Equal(owner, scriptHash)
 }
 
+// NewTXOutput create a new TXOutput paying to the address
+func NewTXOutput(value int, address string) (*TxOutput, error) {
+	txo := &TxOutput{value, nil}
+	if err := txo.Lock([]byte(address)); err != nil {
+		return nil, err
+	}
+	return txo, nil
+}
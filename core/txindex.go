@@ -0,0 +1,143 @@
+package core
+
+import (
+	"blockchain-from-scratch/utils"
+	"fmt"
+	"github.com/sirupsen/logrus"
+)
+
+// txIndexBucket maps the id of every transaction of the main chain to its txIndexEntry.
+// The index is optional: it is only maintained once it has been built with ReindexTransactions.
+const txIndexBucket = "txindex"
+
+// txIndexKey is the metadata key telling whether the transaction index is enabled
+var txIndexKey = []byte("txindex")
+
+// txIndexEntry locates a transaction in the block store
+type txIndexEntry struct {
+	BlockHash []byte
+	// Position is the index of the transaction in the block
+	Position int
+}
+
+func txIndexEnabled(tx StoreTx) bool {
+	return tx.Bucket(metaBucket).Get(txIndexKey) != nil
+}
+
+// indexTransactions adds the transactions of a block connected to the main chain to the index
+func indexTransactions(tx StoreTx, block *Block) error {
+	if !txIndexEnabled(tx) {
+		return nil
+	}
+	b := tx.Bucket(txIndexBucket)
+	for i, blockTx := range block.Transactions {
+		data, err := utils.Serialize(txIndexEntry{block.Hash, i})
+		if err != nil {
+			return err
+		}
+		err = b.Put(blockTx.ID, data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unindexTransactions removes the transactions of a block disconnected from the main chain
+func unindexTransactions(tx StoreTx, block *Block) error {
+	if !txIndexEnabled(tx) {
+		return nil
+	}
+	b := tx.Bucket(txIndexBucket)
+	for _, blockTx := range block.Transactions {
+		err := b.Delete(blockTx.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookupTransaction finds a transaction of the main chain with the index
+func lookupTransaction(tx StoreTx, ID []byte) (*Transaction, error) {
+	data := tx.Bucket(txIndexBucket).Get(ID)
+	if data == nil {
+		return nil, fmt.Errorf("%w: %x", ErrTxNotFound, ID)
+	}
+
+	var entry txIndexEntry
+	err := utils.Deserialize(data, &entry)
+	if err != nil {
+		return nil, err
+	}
+	block, err := getBlock(tx, entry.BlockHash)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Position >= len(block.Transactions) {
+		return nil, fmt.Errorf("%w: %x is out of block %x", ErrTxNotFound, ID, entry.BlockHash)
+	}
+	return block.Transactions[entry.Position], nil
+}
+
+// reindexTransactions rebuilds the transaction index from the chain ending with tip and enables it
+func reindexTransactions(tx StoreTx, tip []byte) error {
+	err := tx.DeleteBucket(txIndexBucket)
+	if err != nil {
+		return err
+	}
+	err = tx.Bucket(metaBucket).Put(txIndexKey, []byte{1})
+	if err != nil {
+		return err
+	}
+
+	currentHash := tip
+	for {
+		block, err := getBlock(tx, currentHash)
+		if err != nil {
+			return err
+		}
+		err = indexTransactions(tx, block)
+		if err != nil {
+			return err
+		}
+		if len(block.PrevBlockHash) == 0 {
+			return nil
+		}
+		currentHash = block.PrevBlockHash
+	}
+}
+
+// ReindexTransactions builds the transaction index from the main chain and keeps it up to date from now on,
+// so FindTransaction and transaction verification no longer walk the chain
+func (bc *Blockchain) ReindexTransactions() error {
+	err := bc.Store.Update(func(tx StoreTx) error {
+		return reindexTransactions(tx, getTip(tx))
+	})
+	if err != nil {
+		return err
+	}
+	logrus.Info("======= Reindex transactions ======")
+	return nil
+}
+
+// DropTxIndex disables the transaction index and removes it from the store
+func (bc *Blockchain) DropTxIndex() error {
+	return bc.Store.Update(func(tx StoreTx) error {
+		err := tx.Bucket(metaBucket).Delete(txIndexKey)
+		if err != nil {
+			return err
+		}
+		return tx.DeleteBucket(txIndexBucket)
+	})
+}
+
+// TxIndexEnabled reports whether the transaction index is maintained
+func (bc *Blockchain) TxIndexEnabled() (bool, error) {
+	enabled := false
+	err := bc.Store.View(func(tx StoreTx) error {
+		enabled = txIndexEnabled(tx)
+		return nil
+	})
+	return enabled, err
+}
@@ -0,0 +1,37 @@
+package core
+
+import (
+	"blockchain-from-scratch/utils"
+	"errors"
+)
+
+const undoBucket = "undo"
+
+// blockUndo records what a block removed from the chainstate, so the block can be disconnected
+// without rebuilding the UTXO set from the whole chain
+type blockUndo struct {
+	// Spent holds the outputs spent by the block, in the order they were spent
+	Spent []UTXO
+}
+
+func getBlockUndo(tx StoreTx, hash []byte) (*blockUndo, error) {
+	data := tx.Bucket(undoBucket).Get(hash)
+	if data == nil {
+		return nil, errors.New("block undo data is not found")
+	}
+
+	var undo blockUndo
+	err := utils.Deserialize(data, &undo)
+	if err != nil {
+		return nil, err
+	}
+	return &undo, nil
+}
+
+func putBlockUndo(tx StoreTx, hash []byte, undo *blockUndo) error {
+	data, err := utils.Serialize(undo)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(undoBucket).Put(hash, data)
+}
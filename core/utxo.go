@@ -0,0 +1,71 @@
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// OutPoint identifies a transaction output by the id of its transaction and its index in it
+type OutPoint struct {
+	Txid  []byte
+	Index int
+}
+
+// UTXOEntry is what the chainstate stores for an unspent output
+type UTXOEntry struct {
+	Value        int
+	ScriptPubKey Script
+	// Height of the block that created the output
+	Height int
+	// IsCoinbase tells whether the output was created by a coinbase, which can only be spent once mature
+	IsCoinbase bool
+}
+
+// UTXO is an unspent output together with its position
+type UTXO struct {
+	OutPoint
+	UTXOEntry
+}
+
+// Key returns the chainstate key of the output: the transaction id followed by
+// the big endian index, so all the outputs of a transaction are next to each other
+func (op OutPoint) Key() []byte {
+	key := make([]byte, len(op.Txid)+4)
+	copy(key, op.Txid)
+	binary.BigEndian.PutUint32(key[len(op.Txid):], uint32(op.Index))
+	return key
+}
+
+func (op OutPoint) String() string {
+	return fmt.Sprintf("%x:%d", op.Txid, op.Index)
+}
+
+// outPointFromKey is the inverse of OutPoint.Key
+func outPointFromKey(key []byte) OutPoint {
+	txidLen := len(key) - 4
+	txid := make([]byte, txidLen)
+	copy(txid, key[:txidLen])
+	return OutPoint{txid, int(binary.BigEndian.Uint32(key[txidLen:]))}
+}
+
+func newUTXOEntry(out TxOutput, height int, isCoinbase bool) UTXOEntry {
+	return UTXOEntry{out.Value, out.ScriptPubKey, height, isCoinbase}
+}
+
+// IsMature reports whether the output can be spent in a block at spendHeight:
+// coinbase outputs must be buried under maturity blocks first
+func (e *UTXOEntry) IsMature(spendHeight, maturity int) bool {
+	return !e.IsCoinbase || spendHeight-e.Height >= maturity
+}
+
+// IsLockedWithKey checks if the output can be spent by the owner of the public key hash
+func (e *UTXOEntry) IsLockedWithKey(pubKeyHash []byte) bool {
+	out := TxOutput{e.Value, e.ScriptPubKey}
+	return out.IsLockedWithKey(pubKeyHash)
+}
+
+// IsLockedWithScript checks if the output pays to the hash of the redeem script
+func (e *UTXOEntry) IsLockedWithScript(scriptHash []byte) bool {
+	out := TxOutput{e.Value, e.ScriptPubKey}
+	return out.IsLockedWithScript(scriptHash)
+}
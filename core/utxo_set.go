@@ -1,13 +1,14 @@
 package core
 
 import (
+	"blockchain-from-scratch/core/wallet"
 	"blockchain-from-scratch/utils"
 	"encoding/hex"
-	"github.com/boltdb/bolt"
+	"fmt"
 	"github.com/sirupsen/logrus"
-	"log"
 )
 
+// utxoBucket maps every unspent output, keyed by its OutPoint, to its UTXOEntry
 const utxoBucket = "chainstate"
 
 // UTXOSet represents UTXO set
@@ -15,170 +16,424 @@ type UTXOSet struct {
 	Blockchain *Blockchain
 }
 
-func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
+// Balance splits the outputs owned by an address
+type Balance struct {
+	// Confirmed is the value of all the unspent outputs in the main chain
+	Confirmed int
+	// Immature is the part of Confirmed made of coinbase outputs that cannot be spent yet
+	Immature int
+	// Locked is the part of Confirmed made of time-locked outputs whose lock is not reached yet
+	Locked int
+	// Spendable is what can be spent in the next block
+	Spendable int
+}
+
+// FindSpendableOutputs collects mature and unlocked outputs owned by the public key hash until they add up to amount
+func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int, error) {
+	return u.findSpendableOutputs(func(entry *UTXOEntry) bool { return entry.IsLockedWithKey(pubKeyHash) }, amount)
+}
+
+// FindSpendableScriptOutputs collects mature outputs paying to the script hash until they add up to amount
+func (u UTXOSet) FindSpendableScriptOutputs(scriptHash []byte, amount int) (int, map[string][]int, error) {
+	return u.findSpendableOutputs(func(entry *UTXOEntry) bool { return entry.IsLockedWithScript(scriptHash) }, amount)
+}
+
+func (u UTXOSet) findSpendableOutputs(owned func(entry *UTXOEntry) bool, amount int) (int, map[string][]int, error) {
 	unspentOutputs := make(map[string][]int)
 	accumulated := 0
-	db := u.Blockchain.Db
-
-	err := db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(utxoBucket))
-		c := b.Cursor()
-
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			txID := hex.EncodeToString(k)
-			var outs TXOutputs
-			utils.Deserialize(v, &outs)
-
-			for outIdx, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubKeyHash) && accumulated < amount {
-					//if out.IsLockedWithKey(pubKeyHash) {
-					accumulated += out.Value
-					unspentOutputs[txID] = append(unspentOutputs[txID], outIdx)
-				}
+	db := u.Blockchain.Store
+	maturity := u.Blockchain.Params.CoinbaseMaturity
+
+	err := db.View(func(tx StoreTx) error {
+		spendHeight, err := nextHeight(tx)
+		if err != nil {
+			return err
+		}
+		medianTime, err := medianTimeOf(tx, getTip(tx))
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(utxoBucket).ForEach(func(k, v []byte) error {
+			if accumulated >= amount {
+				return errStopIteration
+			}
+
+			var entry UTXOEntry
+			err := utils.Deserialize(v, &entry)
+			if err != nil {
+				return err
+			}
+			if !owned(&entry) || !entry.IsMature(spendHeight, maturity) {
+				return nil
+			}
+			unlocked, err := timeLockReached(tx, &entry, spendHeight, medianTime)
+			if err != nil {
+				return err
+			}
+
+			if unlocked {
+				outPoint := outPointFromKey(k)
+				txID := hex.EncodeToString(outPoint.Txid)
+				accumulated += entry.Value
+				unspentOutputs[txID] = append(unspentOutputs[txID], outPoint.Index)
 			}
+			return nil
+		})
+	})
+	if err == errStopIteration {
+		err = nil
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	return accumulated, unspentOutputs, nil
+}
+
+// GetBalance returns the confirmed, immature, locked and spendable amounts owned by the public key hash
+func (u UTXOSet) GetBalance(pubKeyHash []byte) (Balance, error) {
+	return u.getBalance(func(entry *UTXOEntry) bool { return entry.IsLockedWithKey(pubKeyHash) })
+}
+
+// GetScriptBalance returns the confirmed, immature, locked and spendable amounts paid to the script hash
+func (u UTXOSet) GetScriptBalance(scriptHash []byte) (Balance, error) {
+	return u.getBalance(func(entry *UTXOEntry) bool { return entry.IsLockedWithScript(scriptHash) })
+}
+
+// GetBalances returns the balance of every address, paying to a public key hash or a script hash,
+// in a single pass over the UTXO set. It returns wallet.ErrInvalidAddress for an address of another network.
+func (u UTXOSet) GetBalances(addresses []string) (map[string]Balance, error) {
+	// the addresses keyed by the hash they pay to, prefixed by the kind of hash
+	owners := make(map[string]string, len(addresses))
+	for _, address := range addresses {
+		hash, isScript, err := wallet.DecodeAddress(address)
+		if err != nil {
+			return nil, err
+		}
+		owners[ownerKey(hash, isScript)] = address
+	}
+
+	balances, err := u.getBalances(func(entry *UTXOEntry) (string, bool) {
+		if pubKeyHash := entry.ScriptPubKey.PubKeyHash(); pubKeyHash != nil {
+			address, ok := owners[ownerKey(pubKeyHash, false)]
+			return address, ok
+		}
+		if scriptHash := entry.ScriptPubKey.ScriptHash(); scriptHash != nil {
+			address, ok := owners[ownerKey(scriptHash, true)]
+			return address, ok
 		}
-		return nil
+		return "", false
 	})
 	if err != nil {
-		log.Panic(err)
+		return nil, err
+	}
+	for _, address := range addresses {
+		if _, ok := balances[address]; !ok {
+			balances[address] = Balance{}
+		}
 	}
-	return accumulated, unspentOutputs
+	return balances, nil
 }
 
-// IsCoinbase checks whether the transaction is coinbase
-func (tx Transaction) IsCoinbase() bool {
-	return len(tx.Vin) == 1 && len(tx.Vin[0].Txid) == 0 && tx.Vin[0].Vout == -1
+func ownerKey(hash []byte, isScript bool) string {
+	if isScript {
+		return "s" + string(hash)
+	}
+	return "k" + string(hash)
 }
 
-func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TxOutput {
-	var UTXOs []TxOutput
-	db := u.Blockchain.Db
+// Add adds the amounts of another balance, like the one of another address of the wallet
+func (b *Balance) Add(other Balance) {
+	b.Confirmed += other.Confirmed
+	b.Immature += other.Immature
+	b.Locked += other.Locked
+	b.Spendable += other.Spendable
+}
 
-	err := db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(utxoBucket))
-		c := b.Cursor()
+func (u UTXOSet) getBalance(owned func(entry *UTXOEntry) bool) (Balance, error) {
+	balances, err := u.getBalances(func(entry *UTXOEntry) (string, bool) { return "", owned(entry) })
+	return balances[""], err
+}
 
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			var outs TXOutputs
-			utils.Deserialize(v, &outs)
-			//logrus.Infof("get '%x' vin: ", k)
-			//utils.PrintJsonLog(&outs, "FindUTXO")
+// getBalances sums the outputs by owner, ownerOf returns the owner of an output and false for the outputs to skip
+func (u UTXOSet) getBalances(ownerOf func(entry *UTXOEntry) (string, bool)) (map[string]Balance, error) {
+	balances := make(map[string]Balance)
+	maturity := u.Blockchain.Params.CoinbaseMaturity
 
-			for _, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubKeyHash) {
-					UTXOs = append(UTXOs, out)
-				}
-			}
+	err := u.Blockchain.Store.View(func(tx StoreTx) error {
+		spendHeight, err := nextHeight(tx)
+		if err != nil {
+			return err
 		}
+		medianTime, err := medianTimeOf(tx, getTip(tx))
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(utxoBucket).ForEach(func(k, v []byte) error {
+			var entry UTXOEntry
+			err := utils.Deserialize(v, &entry)
+			if err != nil {
+				return err
+			}
+			owner, ok := ownerOf(&entry)
+			if !ok {
+				return nil
+			}
 
-		return nil
+			balance := balances[owner]
+			balance.Confirmed += entry.Value
+			unlocked, err := timeLockReached(tx, &entry, spendHeight, medianTime)
+			if err != nil {
+				return err
+			}
+			switch {
+			case !entry.IsMature(spendHeight, maturity):
+				balance.Immature += entry.Value
+			case !unlocked:
+				balance.Locked += entry.Value
+			}
+			balance.Spendable = balance.Confirmed - balance.Immature - balance.Locked
+			balances[owner] = balance
+			return nil
+		})
 	})
+	return balances, err
+}
+
+// nextHeight returns the height of the next block of the main chain, where new transactions are spent
+func nextHeight(tx StoreTx) (int, error) {
+	tipHeader, err := getHeader(tx, getTip(tx))
 	if err != nil {
-		log.Panic(err)
+		return 0, err
 	}
+	return tipHeader.Height + 1, nil
+}
 
-	return UTXOs
+// IsCoinbase checks whether the transaction is coinbase
+func (tx Transaction) IsCoinbase() bool {
+	return len(tx.Vin) == 1 && len(tx.Vin[0].Txid) == 0 && tx.Vin[0].Vout == -1
 }
 
-func (u UTXOSet) GetUTXODetails() {
-	db := u.Blockchain.Db
+// FindUTXO returns the unspent outputs owned by the public key hash
+func (u UTXOSet) FindUTXO(pubKeyHash []byte) ([]UTXO, error) {
+	var UTXOs []UTXO
+	db := u.Blockchain.Store
 
-	err := db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(utxoBucket))
-		c := b.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			var outs TXOutputs
-			utils.Deserialize(v, &outs)
-			logrus.Infof("get vin '%x' : ", k)
-			utils.PrintJsonLog(&outs, "GetUTXODetails")
-		}
-		return nil
+	err := db.View(func(tx StoreTx) error {
+		return tx.Bucket(utxoBucket).ForEach(func(k, v []byte) error {
+			var entry UTXOEntry
+			err := utils.Deserialize(v, &entry)
+			if err != nil {
+				return err
+			}
+
+			if entry.IsLockedWithKey(pubKeyHash) {
+				UTXOs = append(UTXOs, UTXO{outPointFromKey(k), entry})
+			}
+			return nil
+		})
 	})
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
+	return UTXOs, nil
 }
 
-func (utxo UTXOSet) Reindex() {
-	db := utxo.Blockchain.Db
-	bucketName := []byte(utxoBucket)
+// PubKeyHashes returns the set of the public key hashes owning unspent outputs,
+// it is how a restored HD wallet finds the keys it used
+func (u UTXOSet) PubKeyHashes() (map[string]bool, error) {
+	owners := make(map[string]bool)
+	err := u.Blockchain.Store.View(func(tx StoreTx) error {
+		return tx.Bucket(utxoBucket).ForEach(func(k, v []byte) error {
+			var entry UTXOEntry
+			err := utils.Deserialize(v, &entry)
+			if err != nil {
+				return err
+			}
 
-	err := db.Update(func(tx *bolt.Tx) error {
-		err := tx.DeleteBucket(bucketName)
-		_, err = tx.CreateBucket(bucketName)
-		return err
+			if pubKeyHash := entry.ScriptPubKey.PubKeyHash(); pubKeyHash != nil {
+				owners[string(pubKeyHash)] = true
+			}
+			return nil
+		})
 	})
-	//utils.PrintJsonLog(utxo, "Reindex")
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
+	return owners, nil
+}
 
-	UTXO := utxo.Blockchain.FindUTXO()
-	//utils.PrintJsonLog(UTXO, "Reindex")
-	err = db.Update(func(tx *bolt.Tx) error {
-		block := tx.Bucket(bucketName)
-		for txId, outs := range UTXO {
-			key, err := hex.DecodeString(txId)
+// GetUTXO looks up a single unspent output
+func (u UTXOSet) GetUTXO(outPoint OutPoint) (*UTXOEntry, error) {
+	var entry *UTXOEntry
+	err := u.Blockchain.Store.View(func(tx StoreTx) error {
+		var err error
+		entry, err = getUTXO(tx, outPoint)
+		return err
+	})
+	return entry, err
+}
+
+// GetUTXODetails prints every entry of the UTXO set
+func (u UTXOSet) GetUTXODetails() error {
+	db := u.Blockchain.Store
+
+	return db.View(func(tx StoreTx) error {
+		return tx.Bucket(utxoBucket).ForEach(func(k, v []byte) error {
+			var entry UTXOEntry
+			err := utils.Deserialize(v, &entry)
 			if err != nil {
-				log.Panic(err)
+				return err
 			}
-			err = block.Put(key, utils.Serialize(outs))
-		}
-		return nil
+			logrus.Infof("get outpoint '%s' : ", outPointFromKey(k))
+			utils.PrintJsonLog(&entry, "GetUTXODetails")
+			return nil
+		})
+	})
+}
+
+// Reindex rebuilds the UTXO set from the blocks of the main chain
+func (utxo UTXOSet) Reindex() error {
+	db := utxo.Blockchain.Store
+
+	err := db.Update(func(tx StoreTx) error {
+		return reindexUTXO(tx, utxo.Blockchain.tip)
 	})
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 	logrus.Info("======= Reindex UTXO ======")
+	return nil
+}
+
+// reindexUTXO rebuilds the chainstate bucket from the chain ending with tip
+func reindexUTXO(tx StoreTx, tip []byte) error {
+	err := tx.DeleteBucket(utxoBucket)
+	if err != nil {
+		return err
+	}
+	b := tx.Bucket(utxoBucket)
+
+	UTXOs, err := findUTXO(tx, tip)
+	if err != nil {
+		return err
+	}
+	for _, utxo := range UTXOs {
+		err = putUTXO(b, utxo.OutPoint, utxo.UTXOEntry)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Update applies the outputs spent and created by the block to the UTXO set
+// (and to the transaction index when it is enabled)
+func (u UTXOSet) Update(block *Block) error {
+	return u.Blockchain.Store.Update(func(tx StoreTx) error {
+		return connectBlock(tx, block)
+	})
 }
 
-func (u UTXOSet) Update(block *Block) {
-	db := u.Blockchain.Db
-
-	err := db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(utxoBucket))
-		for _, blockTx := range block.Transactions {
-			if !blockTx.IsCoinbase() {
-				for _, in := range blockTx.Vin {
-					updatedOuts := TXOutputs{}
-					outsBytes := b.Get(in.Txid)
-					var outs TXOutputs
-					utils.Deserialize(outsBytes, &outs)
-					//utils.PrintJsonLog(outs, "outs")
-
-					for outIndex, out := range outs.Outputs {
-						if outIndex != in.Vout {
-							updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-						}
-					}
-
-					var err error
-					if len(updatedOuts.Outputs) == 0 {
-						err = b.Delete(in.Txid)
-					} else {
-						err = b.Put(in.Txid, utils.Serialize(updatedOuts))
-					}
-					if err != nil {
-						log.Panic(err)
-					}
+func getUTXO(tx StoreTx, outPoint OutPoint) (*UTXOEntry, error) {
+	data := tx.Bucket(utxoBucket).Get(outPoint.Key())
+	if data == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUTXONotFound, outPoint)
+	}
+
+	var entry UTXOEntry
+	err := utils.Deserialize(data, &entry)
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func putUTXO(b Bucket, outPoint OutPoint, entry UTXOEntry) error {
+	data, err := utils.Serialize(entry)
+	if err != nil {
+		return err
+	}
+	return b.Put(outPoint.Key(), data)
+}
+
+// updateUTXO connects the block to the chainstate and stores its undo record
+func updateUTXO(tx StoreTx, block *Block) error {
+	b := tx.Bucket(utxoBucket)
+	undo := blockUndo{}
+
+	for _, blockTx := range block.Transactions {
+		if !blockTx.IsCoinbase() {
+			for _, in := range blockTx.Vin {
+				outPoint := OutPoint{in.Txid, in.Vout}
+				entry, err := getUTXO(tx, outPoint)
+				if err != nil {
+					return fmt.Errorf("%w: %s", ErrMissingTxOut, outPoint)
+				}
+
+				// remember the spent output so the block can be disconnected
+				undo.Spent = append(undo.Spent, UTXO{outPoint, *entry})
+				err = b.Delete(outPoint.Key())
+				if err != nil {
+					return err
 				}
 			}
+		}
 
-			newOutputs := TXOutputs{}
-			for _, out := range blockTx.VOut {
-				newOutputs.Outputs = append(newOutputs.Outputs, out)
+		for outIdx, out := range blockTx.VOut {
+			if out.ScriptPubKey.IsUnspendable() {
+				continue
 			}
-			err := b.Put(blockTx.ID, utils.Serialize(newOutputs))
-			//utils.PrintJsonLog(newOutputs, fmt.Sprintf("newOutputs: %x", blockTx.ID))
+			outPoint := OutPoint{blockTx.ID, outIdx}
+			err := putUTXO(b, outPoint, newUTXOEntry(out, block.Height, blockTx.IsCoinbase()))
 			if err != nil {
-				log.Panic(err)
+				return err
 			}
 		}
-		return nil
+	}
+	return putBlockUndo(tx, block.Hash, &undo)
+}
 
+// Disconnect reverts the changes the block made to the UTXO set, it is the inverse of Update.
+// The block must be the last one applied to the UTXO set.
+func (u UTXOSet) Disconnect(block *Block) error {
+	return u.Blockchain.Store.Update(func(tx StoreTx) error {
+		return disconnectBlock(tx, block)
 	})
+}
 
+// disconnectUTXO removes the outputs created by the block and restores
+// the outputs it spent from its undo record. The transactions are walked backwards
+// so an output created and spent in the block is deleted again after being restored.
+func disconnectUTXO(tx StoreTx, block *Block) error {
+	b := tx.Bucket(utxoBucket)
+	undo, err := getBlockUndo(tx, block.Hash)
 	if err != nil {
-		log.Panic(err)
+		return err
+	}
+
+	next := len(undo.Spent)
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		blockTx := block.Transactions[i]
+		for outIdx := range blockTx.VOut {
+			err = b.Delete(OutPoint{blockTx.ID, outIdx}.Key())
+			if err != nil {
+				return err
+			}
+		}
+		if blockTx.IsCoinbase() {
+			continue
+		}
+
+		if next < len(blockTx.Vin) {
+			return fmt.Errorf("undo record of block %x misses spent outputs", block.Hash)
+		}
+		next -= len(blockTx.Vin)
+		for _, spent := range undo.Spent[next : next+len(blockTx.Vin)] {
+			err = putUTXO(b, spent.OutPoint, spent.UTXOEntry)
+			if err != nil {
+				return err
+			}
+		}
 	}
+	return nil
 }
@@ -0,0 +1,355 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"time"
+)
+
+const (
+	// maxFutureBlockTime is how far in the future a block timestamp may be
+	maxFutureBlockTime = 2 * time.Hour
+	// medianTimeBlocks is the number of previous blocks used to compute the median time past
+	medianTimeBlocks = 11
+)
+
+// Block validation errors. They are wrapped with more details, so check them with errors.Is
+var (
+	ErrBadBlockHash       = errors.New("block hash does not match block content")
+	ErrBadProofOfWork     = errors.New("block hash is higher than its target")
+	ErrBadDifficulty      = errors.New("block target does not match the expected difficulty")
+	ErrOrphanBlock        = errors.New("parent block is not found")
+	ErrBadHeight          = errors.New("block height does not follow its parent")
+	ErrTimeTooOld         = errors.New("block timestamp is before the median time of the previous blocks")
+	ErrTimeTooNew         = errors.New("block timestamp is too far in the future")
+	ErrNoTransactions     = errors.New("block has no transactions")
+	ErrBadMerkleRoot      = errors.New("block Merkle root does not match its transactions")
+	ErrFirstTxNotCoinbase = errors.New("first transaction of the block is not a coinbase")
+	ErrMultipleCoinbases  = errors.New("block has more than one coinbase")
+	ErrBadCoinbaseValue   = errors.New("coinbase pays more than the block subsidy and fees")
+	ErrDuplicateTx        = errors.New("block contains duplicate transactions")
+	ErrBadTxID            = errors.New("transaction id does not match its content")
+	ErrBadTxOutValue      = errors.New("transaction output value is invalid")
+	ErrSpendTooHigh       = errors.New("transaction outputs exceed its inputs")
+	ErrValueOverflow      = errors.New("sum of values overflows")
+	ErrMissingTxOut       = errors.New("transaction input spends an unknown or spent output")
+	ErrDoubleSpend        = errors.New("transaction output is spent twice in the block")
+	ErrImmatureSpend      = errors.New("transaction spends an immature coinbase output")
+)
+
+// CheckBlockHeader performs the checks of a header that do not depend on the chain:
+// the hash must match the header and satisfy the proof of work within the limit of the network,
+// and the timestamp must not be in the future.
+func CheckBlockHeader(params *ChainParams, header *BlockHeader, hash []byte) error {
+	computed, err := header.BlockHash()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(computed, hash) {
+		return fmt.Errorf("%w: %x", ErrBadBlockHash, hash)
+	}
+
+	target := CompactToBig(header.Bits)
+	if target.Sign() <= 0 || target.Cmp(params.PowLimit) > 0 {
+		return fmt.Errorf("%w: target %08x is out of range", ErrBadProofOfWork, header.Bits)
+	}
+	if new(big.Int).SetBytes(hash).Cmp(target) >= 0 {
+		return fmt.Errorf("%w: %x", ErrBadProofOfWork, hash)
+	}
+
+	maxTime := time.Now().Add(maxFutureBlockTime).Unix()
+	if header.Timestamp > maxTime {
+		return fmt.Errorf("%w: %d", ErrTimeTooNew, header.Timestamp)
+	}
+	return nil
+}
+
+// CheckBlock performs the checks that do not depend on the chain: the header checks,
+// the Merkle root, the coinbase position and the sanity of every transaction.
+// The coinbase value depends on the fees of the block, it is checked by checkBlockTransactions.
+func CheckBlock(params *ChainParams, block *Block) error {
+	err := CheckBlockHeader(params, &block.BlockHeader, block.Hash)
+	if err != nil {
+		return err
+	}
+
+	if len(block.Transactions) == 0 {
+		return ErrNoTransactions
+	}
+	merkleRoot, err := block.HashTransactions()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(block.MerkleRoot, merkleRoot) {
+		return fmt.Errorf("%w: %x", ErrBadMerkleRoot, block.MerkleRoot)
+	}
+	if !block.Transactions[0].IsCoinbase() {
+		return ErrFirstTxNotCoinbase
+	}
+
+	seen := make(map[string]bool)
+	for i, tx := range block.Transactions {
+		if i > 0 && tx.IsCoinbase() {
+			return ErrMultipleCoinbases
+		}
+		if err := CheckTransactionSanity(params, tx); err != nil {
+			return err
+		}
+
+		txID := hex.EncodeToString(tx.ID)
+		if seen[txID] {
+			return fmt.Errorf("%w: %s", ErrDuplicateTx, txID)
+		}
+		seen[txID] = true
+	}
+	return nil
+}
+
+// CheckTransactionSanity checks a transaction without looking at the outputs it spends.
+// No output, and not the sum of them, may be above the max supply of the network.
+func CheckTransactionSanity(params *ChainParams, tx *Transaction) error {
+	hash, err := tx.unsignedHash()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(tx.ID, hash) {
+		return fmt.Errorf("%w: %x", ErrBadTxID, tx.ID)
+	}
+	for _, out := range tx.VOut {
+		if out.Value < 0 || out.Value > params.MaxSupply {
+			return fmt.Errorf("%w: %d", ErrBadTxOutValue, out.Value)
+		}
+		if out.ScriptPubKey.IsUnspendable() && len(out.ScriptPubKey) > maxNullDataScriptSize {
+			return fmt.Errorf("%w: data output script of %d bytes", ErrDataTooLarge, len(out.ScriptPubKey))
+		}
+	}
+
+	outputValue, err := tx.OutputValue()
+	if err != nil {
+		return err
+	}
+	if outputValue > params.MaxSupply {
+		return fmt.Errorf("%w: outputs of %d are above the max supply %d", ErrBadTxOutValue, outputValue, params.MaxSupply)
+	}
+	return nil
+}
+
+// addValue returns the sum of two values, it fails when a value is negative or the sum does not fit in an int
+func addValue(total, value int) (int, error) {
+	if total < 0 || value < 0 || total > math.MaxInt-value {
+		return 0, fmt.Errorf("%w: %d + %d", ErrValueOverflow, total, value)
+	}
+	return total + value, nil
+}
+
+// ValidateBlock runs the full validation pipeline of a block that has not been stored yet:
+// the context-free checks of CheckBlock, then the checks against its parent
+// (height, difficulty and timestamp) and, when the block extends the current tip,
+// the verification of every transaction against the UTXO set.
+// Blocks of side branches get their transactions verified when a reorganization connects them.
+func (bc *Blockchain) ValidateBlock(block *Block) error {
+	return bc.Store.View(func(tx StoreTx) error {
+		err := validateBlock(tx, bc.Params, block)
+		if err != nil {
+			return err
+		}
+
+		tip := getTip(tx)
+		if bytes.Equal(block.PrevBlockHash, tip) {
+			return checkBlockTransactions(tx, bc.Params, block)
+		}
+		return nil
+	})
+}
+
+// validateBlock runs CheckBlock and the contextual header checks,
+// the parent body must be stored for the block to be connectable
+func validateBlock(tx StoreTx, params *ChainParams, block *Block) error {
+	err := CheckBlock(params, block)
+	if err != nil {
+		return err
+	}
+	if !hasBlock(tx, block.PrevBlockHash) {
+		return fmt.Errorf("%w: %x", ErrOrphanBlock, block.PrevBlockHash)
+	}
+	return validateHeaderContext(tx, params, &block.BlockHeader)
+}
+
+// validateHeaderContext runs the checks of a header that need its parent header
+func validateHeaderContext(tx StoreTx, params *ChainParams, header *BlockHeader) error {
+	parent, err := getHeader(tx, header.PrevBlockHash)
+	if err != nil {
+		return fmt.Errorf("%w: %x", ErrOrphanBlock, header.PrevBlockHash)
+	}
+
+	if header.Height != parent.Height+1 {
+		return fmt.Errorf("%w: height %d, parent height %d", ErrBadHeight, header.Height, parent.Height)
+	}
+
+	expectedBits, err := calcNextRequiredBits(params, parent, headerGetter(tx))
+	if err != nil {
+		return err
+	}
+	if header.Bits != expectedBits {
+		return fmt.Errorf("%w: got %08x, want %08x", ErrBadDifficulty, header.Bits, expectedBits)
+	}
+
+	// Bitcoin requires the timestamp to be strictly greater than the median time past,
+	// we accept equal timestamps since several blocks are often mined in the same second here
+	medianTime, err := calcMedianTimePast(parent, headerGetter(tx))
+	if err != nil {
+		return err
+	}
+	if header.Timestamp < medianTime {
+		return fmt.Errorf("%w: %d < %d", ErrTimeTooOld, header.Timestamp, medianTime)
+	}
+	return nil
+}
+
+// calcMedianTimePast returns the median timestamp of the last medianTimeBlocks blocks ending with prev
+func calcMedianTimePast(prev *BlockHeader, getHeader func(hash []byte) (*BlockHeader, error)) (int64, error) {
+	var timestamps []int64
+	header := prev
+	for i := 0; i < medianTimeBlocks; i++ {
+		timestamps = append(timestamps, header.Timestamp)
+		if len(header.PrevBlockHash) == 0 {
+			break
+		}
+
+		parent, err := getHeader(header.PrevBlockHash)
+		if err != nil {
+			return 0, err
+		}
+		header = parent
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2], nil
+}
+
+// checkBlockTransactions verifies every transaction of the block against the chainstate,
+// which must be the UTXO set of the block's parent, and checks their lock times.
+// Outputs created earlier in the same block can be spent, but each output only once.
+// The coinbase may claim the subsidy of the block height plus the fees of the other transactions.
+func checkBlockTransactions(tx StoreTx, params *ChainParams, block *Block) error {
+	blockTxs := make(map[string]Transaction)
+	spent := make(map[string]bool)
+	fees := 0
+
+	// lock times are compared to the median time past of the parent, which the block time cannot go under
+	medianTime, err := medianTimeOf(tx, block.PrevBlockHash)
+	if err != nil {
+		return err
+	}
+
+	for _, blockTx := range block.Transactions {
+		err := checkFinalTx(blockTx, block.Height, medianTime)
+		if err != nil {
+			return err
+		}
+		if !blockTx.IsCoinbase() {
+			fee, err := checkTransactionInputs(tx, params, block.PrevBlockHash, block.Height, medianTime, blockTx, blockTxs, spent)
+			if err != nil {
+				return err
+			}
+			fees, err = addValue(fees, fee)
+			if err != nil {
+				return fmt.Errorf("fees of block %x: %w", block.Hash, err)
+			}
+		}
+		blockTxs[hex.EncodeToString(blockTx.ID)] = *blockTx
+	}
+
+	coinbaseValue, err := block.Transactions[0].OutputValue()
+	if err != nil {
+		return err
+	}
+	subsidy := params.CalcBlockSubsidy(block.Height)
+	maxValue, err := addValue(subsidy, fees)
+	if err != nil {
+		return fmt.Errorf("fees of block %x: %w", block.Hash, err)
+	}
+	if coinbaseValue > maxValue {
+		return fmt.Errorf("%w: %d > %d + %d", ErrBadCoinbaseValue, coinbaseValue, subsidy, fees)
+	}
+	return nil
+}
+
+// checkTransactionInputs verifies that every input of the transaction spends an unspent and mature output
+// whose relative lock is reached, that the outputs do not exceed the inputs and that the unlocking scripts
+// satisfy the spent outputs. It returns the fee of the transaction, the inputs minus the outputs.
+// tip is the block the chainstate corresponds to, spendHeight the height of the block including the transaction
+// and medianTime the median time past of tip. pending holds the transactions that are not in the chain yet but can be spent (the previous transactions
+// of the same block) and spent the outputs already spent by them.
+func checkTransactionInputs(dbTx StoreTx, params *ChainParams, tip []byte, spendHeight int, medianTime int64, tx *Transaction, pending map[string]Transaction, spent map[string]bool) (int, error) {
+	prevTXs := make(map[string]Transaction)
+	inputValue := 0
+
+	for inIdx, in := range tx.Vin {
+		key := OutPoint{in.Txid, in.Vout}.String()
+		if spent[key] {
+			return 0, fmt.Errorf("%w: %s", ErrDoubleSpend, key)
+		}
+		spent[key] = true
+
+		inTxID := hex.EncodeToString(in.Txid)
+		// an output of the same block is created at the height it is spent
+		coinHeight := spendHeight
+		prevTx, ok := pending[inTxID]
+		if ok {
+			// the coinbase of the same block has no confirmation at all
+			if prevTx.IsCoinbase() && params.CoinbaseMaturity > 0 {
+				return 0, fmt.Errorf("%w: %s", ErrImmatureSpend, key)
+			}
+		} else {
+			entry, err := getUTXO(dbTx, OutPoint{in.Txid, in.Vout})
+			if err != nil {
+				return 0, fmt.Errorf("%w: %s", ErrMissingTxOut, key)
+			}
+			if !entry.IsMature(spendHeight, params.CoinbaseMaturity) {
+				return 0, fmt.Errorf("%w: %s created at height %d cannot be spent at height %d",
+					ErrImmatureSpend, key, entry.Height, spendHeight)
+			}
+
+			found, err := findTransaction(dbTx, tip, in.Txid)
+			if err != nil {
+				return 0, fmt.Errorf("%w: %s", ErrMissingTxOut, key)
+			}
+			prevTx = *found
+			coinHeight = entry.Height
+		}
+
+		err := checkSequenceLock(dbTx, tx, inIdx, coinHeight, spendHeight, medianTime)
+		if err != nil {
+			return 0, err
+		}
+
+		if in.Vout < 0 || in.Vout >= len(prevTx.VOut) {
+			return 0, fmt.Errorf("%w: %s", ErrMissingTxOut, key)
+		}
+		inputValue, err = addValue(inputValue, prevTx.VOut[in.Vout].Value)
+		if err != nil {
+			return 0, fmt.Errorf("inputs of transaction %x: %w", tx.ID, err)
+		}
+		prevTXs[inTxID] = prevTx
+	}
+
+	outputValue, err := tx.OutputValue()
+	if err != nil {
+		return 0, err
+	}
+	if outputValue > inputValue {
+		return 0, fmt.Errorf("%w: %d > %d", ErrSpendTooHigh, outputValue, inputValue)
+	}
+
+	err = tx.Verify(prevTXs)
+	if err != nil {
+		return 0, fmt.Errorf("transaction %x: %w", tx.ID, err)
+	}
+	return inputValue - outputValue, nil
+}
@@ -0,0 +1,174 @@
+package wallet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// legacyUnlockFile held the key of an unlocked wallet file in earlier versions, it is removed when found
+const legacyUnlockFile = "wallet_%s.unlock"
+
+// scrypt parameters of the key of the wallet file, the ones recommended for interactive logins
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	cryptKeyLen  = 32
+	cryptSaltLen = 16
+)
+
+// encryptedMagic starts an encrypted wallet file, an unencrypted one is a gob of Wallets
+var encryptedMagic = []byte("WALLETAES1")
+
+var (
+	ErrWalletLocked       = errors.New("wallet is locked, run the command under walletpassphrase")
+	ErrWalletEncrypted    = errors.New("wallet is already encrypted")
+	ErrWalletNotEncrypted = errors.New("wallet is not encrypted")
+	ErrBadPassphrase      = errors.New("passphrase is not correct")
+)
+
+// encryptedWallets is the content of an encrypted wallet file: the gob of Wallets sealed with AES-256-GCM
+// under the key derived from the passphrase with scrypt
+type encryptedWallets struct {
+	Salt       []byte
+	Nonce      []byte
+	Ciphertext []byte
+}
+
+// walletCrypt is the encryption state of the wallet file, key is nil while it is locked
+type walletCrypt struct {
+	file encryptedWallets
+	key  []byte
+}
+
+func deriveCryptKey(passphrase string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, cryptKeyLen)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (c *walletCrypt) seal(plaintext []byte) error {
+	gcm, err := newGCM(c.key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return err
+	}
+	c.file.Nonce = nonce
+	c.file.Ciphertext = gcm.Seal(nil, nonce, plaintext, encryptedMagic)
+	return nil
+}
+
+// open decrypts the wallet file with key, it returns ErrBadPassphrase if the key is not the one of the file
+func (c *walletCrypt) open(key []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, c.file.Nonce, c.file.Ciphertext, encryptedMagic)
+	if err != nil {
+		return nil, ErrBadPassphrase
+	}
+	return plaintext, nil
+}
+
+// IsEncrypted reports whether the wallet file is encrypted
+func (ws *Wallets) IsEncrypted() bool {
+	return ws.crypt != nil
+}
+
+// IsLocked reports whether the wallet file is encrypted and its keys are not decrypted
+func (ws *Wallets) IsLocked() bool {
+	return ws.crypt != nil && ws.crypt.key == nil
+}
+
+// Encrypt makes SaveToFile encrypt the wallet file with a key derived from the passphrase,
+// it is how an unencrypted wallet file is migrated. It returns ErrWalletEncrypted if it already is.
+func (ws *Wallets) Encrypt(passphrase string) error {
+	if ws.crypt != nil {
+		return ErrWalletEncrypted
+	}
+	salt := make([]byte, cryptSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	key, err := deriveCryptKey(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	ws.crypt = &walletCrypt{encryptedWallets{Salt: salt}, key}
+	return nil
+}
+
+// Unlock decrypts the keys of the encrypted wallet file with the passphrase. The key only stays in memory,
+// so the wallet is unlocked for the process that called Unlock until it exits or calls Lock:
+// every CLI command is its own process, walletpassphrase can only unlock the wallet for a single command.
+func (ws *Wallets) Unlock(passphrase string) error {
+	if ws.crypt == nil {
+		return ErrWalletNotEncrypted
+	}
+	key, err := deriveCryptKey(passphrase, ws.crypt.file.Salt)
+	if err != nil {
+		return err
+	}
+	plaintext, err := ws.crypt.open(key)
+	if err != nil {
+		return err
+	}
+	if err = ws.decode(plaintext); err != nil {
+		return err
+	}
+	ws.crypt.key = key
+	return nil
+}
+
+// Lock forgets the keys of the encrypted wallet file
+func (ws *Wallets) Lock() error {
+	if ws.crypt == nil {
+		return ErrWalletNotEncrypted
+	}
+	ws.crypt.key = nil
+	ws.Wallets = make(map[string]*Wallet)
+	ws.Seed = nil
+	ws.WatchOnly = nil
+	ws.Labels = nil
+	ws.History = History{}
+	return nil
+}
+
+// removeLegacyUnlockFile removes the unlock file an earlier version may have left next to the wallet file of the node
+func removeLegacyUnlockFile(nodeID string) error {
+	err := os.Remove(fmt.Sprintf(legacyUnlockFile, nodeID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// writeFileAtomic replaces the file with a new one only the user can read and write,
+// the old content stays whole if writing fails
+func writeFileAtomic(name string, content []byte) error {
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, content, 0600); err != nil {
+		return err
+	}
+	// the temporary file may be left over with other permissions
+	if err := os.Chmod(tmp, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
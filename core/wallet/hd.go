@@ -0,0 +1,97 @@
+package wallet
+
+import (
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"math/big"
+)
+
+const (
+	// HardenedKeyStart is the index of the first hardened child key, its derivation needs the private key
+	HardenedKeyStart = uint32(0x80000000)
+
+	// ReceiveChain is the chain of the keys of the addresses given out to receive payments
+	ReceiveChain = uint32(0)
+	// ChangeChain is the chain of the keys receiving the change of the transactions of the wallet
+	ChangeChain = uint32(1)
+
+	// masterKeySalt is the HMAC key of the master key derivation of SLIP-10 for the P-256 curve
+	masterKeySalt = "Nist256p1 seed"
+)
+
+// accountPath is the path of the account holding the receive and change chains, m/44'/0'/0' like BIP44
+var accountPath = []uint32{HardenedKeyStart + 44, HardenedKeyStart, HardenedKeyStart}
+
+// ExtendedKey is a private key of an HD wallet with the chain code deriving its children, like BIP32.
+// Bitcoin uses secp256k1, the keys are on P-256 here so derivation follows SLIP-10 for this curve.
+type ExtendedKey struct {
+	Key       *big.Int
+	ChainCode []byte
+}
+
+// NewMasterKey derives the root key of the tree of keys of the seed
+func NewMasterKey(seed []byte) *ExtendedKey {
+	i := hmacSHA512([]byte(masterKeySalt), seed)
+	for {
+		key := new(big.Int).SetBytes(i[:32])
+		if key.Sign() > 0 && key.Cmp(elliptic.P256().Params().N) < 0 {
+			return &ExtendedKey{key, i[32:]}
+		}
+		// the key is not valid on the curve, which is very unlikely: derive again from the whole output
+		i = hmacSHA512([]byte(masterKeySalt), i)
+	}
+}
+
+// Child derives the child key at index, hardened from HardenedKeyStart on
+func (k *ExtendedKey) Child(index uint32) *ExtendedKey {
+	n := elliptic.P256().Params().N
+
+	var data []byte
+	if index >= HardenedKeyStart {
+		data = append([]byte{0}, k.Key.FillBytes(make([]byte, 32))...)
+	} else {
+		x, y := elliptic.P256().ScalarBaseMult(k.Key.Bytes())
+		data = elliptic.MarshalCompressed(elliptic.P256(), x, y)
+	}
+	data = binary.BigEndian.AppendUint32(data, index)
+
+	for {
+		i := hmacSHA512(k.ChainCode, data)
+		tweak := new(big.Int).SetBytes(i[:32])
+		if tweak.Cmp(n) < 0 {
+			key := tweak.Add(tweak, k.Key)
+			key.Mod(key, n)
+			if key.Sign() > 0 {
+				return &ExtendedKey{key, i[32:]}
+			}
+		}
+		// the child key is not valid, derive again from the chain code of this attempt, like SLIP-10
+		data = binary.BigEndian.AppendUint32(append([]byte{1}, i[32:]...), index)
+	}
+}
+
+// Derive follows the path of child indexes from the key
+func (k *ExtendedKey) Derive(path ...uint32) *ExtendedKey {
+	for _, index := range path {
+		k = k.Child(index)
+	}
+	return k
+}
+
+// Wallet returns the key pair of the extended key
+func (k *ExtendedKey) Wallet() *Wallet {
+	return newWalletFromKey(k.Key)
+}
+
+// DeriveWallet returns the key pair at index of the receive or change chain of the seed, m/44'/0'/0'/chain/index
+func DeriveWallet(seed []byte, chain, index uint32) *Wallet {
+	return NewMasterKey(seed).Derive(accountPath...).Derive(chain, index).Wallet()
+}
+
+func hmacSHA512(key, data []byte) []byte {
+	mac := hmac.New(sha512.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
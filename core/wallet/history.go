@@ -0,0 +1,122 @@
+package wallet
+
+import "fmt"
+
+// Directions of a WalletTx
+const (
+	// TxReceive is a transaction paying the wallet, funded by others
+	TxReceive = "receive"
+	// TxSend is a transaction funded by the wallet paying others
+	TxSend = "send"
+	// TxSelf is a transaction funded by the wallet paying only itself
+	TxSelf = "self"
+	// TxGenerate is a coinbase paying the wallet
+	TxGenerate = "generate"
+)
+
+// TxAddress is an address a transaction spends from or pays to, with the amount it received,
+// negative when more was spent from it
+type TxAddress struct {
+	Address string `json:"address,omitempty"`
+	// Script is the class of the locking script of an output without an address, like a null data output
+	Script string `json:"script,omitempty"`
+	Label  string `json:"label,omitempty"`
+	Amount int    `json:"amount"`
+}
+
+// WalletTx is a transaction of the main chain touching addresses of the wallet
+type WalletTx struct {
+	Txid      string `json:"txid"`
+	Direction string `json:"direction"`
+	// Amount is what the wallet received, or minus what it sent to others, the fee excluded
+	Amount int `json:"amount"`
+	// Fee is the fee of a transaction the wallet funded
+	Fee int `json:"fee"`
+	// Addresses are the addresses of the wallet the transaction spends from or pays to
+	Addresses []TxAddress `json:"addresses"`
+	// Counterparties are the other addresses: the ones paid by a send, the ones paying a receive
+	Counterparties []TxAddress `json:"counterparties"`
+	Height         int         `json:"height"`
+	BlockHash      string      `json:"blockhash"`
+	Timestamp      int64       `json:"time"`
+	// Confirmations is the number of blocks of the main chain from the one of the transaction,
+	// it is set when the transaction is listed
+	Confirmations int `json:"confirmations"`
+}
+
+// History is the index of the transactions of the wallet, it covers the blocks of the main chain
+// up to TipHash, see core.Blockchain.SyncWalletHistory
+type History struct {
+	// Txs are the transactions of the wallet, oldest first
+	Txs       []WalletTx
+	TipHash   []byte
+	TipHeight int
+}
+
+// ResetHistory drops the index of the transactions, it is built again from the genesis block at the next sync.
+// It is how the history of imported addresses is found.
+func (ws *Wallets) ResetHistory() {
+	ws.History = History{}
+}
+
+// SetLabel sets the label of an address of the wallet or of the address book, an empty label removes it
+func (ws *Wallets) SetLabel(address, label string) error {
+	if ws.IsLocked() {
+		return ErrWalletLocked
+	}
+	if !ValidateAddress(address) {
+		return fmt.Errorf("%w: %s", ErrInvalidAddress, address)
+	}
+	if label == "" {
+		delete(ws.Labels, address)
+		return nil
+	}
+	if ws.Labels == nil {
+		ws.Labels = make(map[string]string)
+	}
+	ws.Labels[address] = label
+	return nil
+}
+
+// ListTransactions returns the last count transactions of the history, oldest first.
+// When address is set, only the ones touching it are returned, as a wallet address or a counterparty.
+// Confirmations are counted from the tip height, labels are the current ones.
+func (ws *Wallets) ListTransactions(address string, count, tipHeight int) []WalletTx {
+	var txs []WalletTx
+	for i := len(ws.History.Txs) - 1; i >= 0 && len(txs) < count; i-- {
+		tx := ws.History.Txs[i]
+		if address != "" && !touches(tx, address) {
+			continue
+		}
+		tx.Confirmations = tipHeight - tx.Height + 1
+		tx.Addresses = ws.labeled(tx.Addresses)
+		tx.Counterparties = ws.labeled(tx.Counterparties)
+		txs = append(txs, tx)
+	}
+
+	for i, j := 0, len(txs)-1; i < j; i, j = i+1, j-1 {
+		txs[i], txs[j] = txs[j], txs[i]
+	}
+	return txs
+}
+
+func touches(tx WalletTx, address string) bool {
+	for _, addresses := range [][]TxAddress{tx.Addresses, tx.Counterparties} {
+		for _, a := range addresses {
+			if a.Address == address {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// labeled returns a copy of the addresses with their labels
+func (ws *Wallets) labeled(addresses []TxAddress) []TxAddress {
+	result := make([]TxAddress, len(addresses))
+	for i, a := range addresses {
+		a.Label = ws.Labels[a.Address]
+		result[i] = a
+	}
+	return result
+}
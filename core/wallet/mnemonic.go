@@ -0,0 +1,102 @@
+package wallet
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	_ "embed"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// mnemonicEntropyBits is the entropy of a new mnemonic, it makes 12 words
+const mnemonicEntropyBits = 128
+
+// ErrInvalidMnemonic is returned for a mnemonic with an unknown word, a bad length or a bad checksum
+var ErrInvalidMnemonic = errors.New("mnemonic is not valid")
+
+// english is the BIP39 English word list, see https://github.com/bitcoin/bips/blob/master/bip-0039/english.txt
+//
+//go:embed english.txt
+var english string
+
+var (
+	wordList  = strings.Fields(english)
+	wordIndex = make(map[string]int, len(wordList))
+)
+
+func init() {
+	for i, word := range wordList {
+		wordIndex[word] = i
+	}
+}
+
+// NewMnemonic returns a new random BIP39 mnemonic, the backup of an HD wallet.
+// Every word encodes 11 bits of the entropy followed by its checksum, the first bits of its SHA-256.
+func NewMnemonic() (string, error) {
+	entropy := make([]byte, mnemonicEntropyBits/8)
+	if _, err := rand.Read(entropy); err != nil {
+		return "", err
+	}
+	return entropyToMnemonic(entropy), nil
+}
+
+func entropyToMnemonic(entropy []byte) string {
+	checksumBits := len(entropy) * 8 / 32
+	hash := sha256.Sum256(entropy)
+
+	// the entropy followed by the checksum, as a big number
+	data := new(big.Int).SetBytes(entropy)
+	data.Lsh(data, uint(checksumBits))
+	data.Or(data, big.NewInt(int64(hash[0]>>(8-checksumBits))))
+
+	words := make([]string, (len(entropy)*8+checksumBits)/11)
+	mask := big.NewInt(2047)
+	for i := len(words) - 1; i >= 0; i-- {
+		words[i] = wordList[new(big.Int).And(data, mask).Int64()]
+		data.Rsh(data, 11)
+	}
+	return strings.Join(words, " ")
+}
+
+// ValidateMnemonic checks the words and the checksum of a BIP39 mnemonic
+func ValidateMnemonic(mnemonic string) error {
+	words := strings.Fields(mnemonic)
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return fmt.Errorf("%w: %d words", ErrInvalidMnemonic, len(words))
+	}
+
+	data := new(big.Int)
+	for _, word := range words {
+		index, ok := wordIndex[word]
+		if !ok {
+			return fmt.Errorf("%w: unknown word '%s'", ErrInvalidMnemonic, word)
+		}
+		data.Lsh(data, 11)
+		data.Or(data, big.NewInt(int64(index)))
+	}
+
+	checksumBits := len(words) * 11 / 33
+	checksum := new(big.Int).And(data, big.NewInt(1<<checksumBits-1)).Int64()
+	data.Rsh(data, uint(checksumBits))
+	entropy := data.FillBytes(make([]byte, checksumBits*4))
+	hash := sha256.Sum256(entropy)
+	if int64(hash[0]>>(8-checksumBits)) != checksum {
+		return fmt.Errorf("%w: bad checksum", ErrInvalidMnemonic)
+	}
+	return nil
+}
+
+// MnemonicToSeed checks the mnemonic and derives the 64 bytes seed of the HD wallet from it, like BIP39.
+// The passphrase protects the seed in addition to the words, it is empty when there is none.
+func MnemonicToSeed(mnemonic, passphrase string) ([]byte, error) {
+	if err := ValidateMnemonic(mnemonic); err != nil {
+		return nil, err
+	}
+	normalized := strings.Join(strings.Fields(mnemonic), " ")
+	return pbkdf2.Key([]byte(normalized), []byte("mnemonic"+passphrase), 2048, 64, sha512.New), nil
+}
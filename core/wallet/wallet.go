@@ -6,51 +6,126 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
-	"log"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// version is the first byte of the addresses paying to a public key hash,
+// scriptVersion the one of the addresses paying to a script hash.
+// They are set by the network the node runs on.
+var (
+	version       = byte(0x00)
+	scriptVersion = byte(0x05)
 )
 
-const version = byte(0x00)
 const addressChecksumLen = 4
 
+// ErrInvalidAddress is returned for an address with a bad checksum or from another network
+var ErrInvalidAddress = errors.New("address is not valid")
+
+// SetAddressVersion sets the version byte of the addresses of the network, see core.ChainParams
+func SetAddressVersion(v byte) {
+	version = v
+}
+
+// SetScriptAddressVersion sets the version byte of the script addresses of the network, see core.ChainParams
+func SetScriptAddressVersion(v byte) {
+	scriptVersion = v
+}
+
 type Wallet struct {
 	PrivateKey ecdsa.PrivateKey
 	PublicKey  []byte
 }
 
-func NewWallet() *Wallet {
-	private, public := newKeyPair()
-	return &Wallet{private, public}
+func NewWallet() (*Wallet, error) {
+	private, public, err := newKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	return &Wallet{private, public}, nil
+}
+
+// newWalletFromKey returns the key pair of the private key d
+func newWalletFromKey(d *big.Int) *Wallet {
+	curve := elliptic.P256()
+	x, y := curve.ScalarBaseMult(d.Bytes())
+	private := ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: curve, X: x, Y: y}, D: new(big.Int).Set(d)}
+	return &Wallet{private, MarshalPublicKey(&private.PublicKey)}
+}
+
+// PublicKeyLen is the size of a public key: X followed by Y, both on 32 bytes
+const PublicKeyLen = 2 * 32
+
+// MarshalPublicKey returns X followed by Y, both on 32 bytes so the script engine can split them.
+// It is the public key hashed by the addresses and pushed by the unlocking scripts.
+func MarshalPublicKey(pub *ecdsa.PublicKey) []byte {
+	return append(pub.X.FillBytes(make([]byte, 32)), pub.Y.FillBytes(make([]byte, 32))...)
 }
 
-func newKeyPair() (ecdsa.PrivateKey, []byte) {
+func newKeyPair() (ecdsa.PrivateKey, []byte, error) {
 	curve := elliptic.P256()
 	privateKey, err := ecdsa.GenerateKey(curve, rand.Reader)
 	if err != nil {
-		log.Panic(err)
+		return ecdsa.PrivateKey{}, nil, fmt.Errorf("generate key: %w", err)
 	}
 
-	public := append(privateKey.PublicKey.X.Bytes(), privateKey.Y.Bytes()...)
-	return *privateKey, public
+	return *privateKey, MarshalPublicKey(&privateKey.PublicKey), nil
 }
 
 // BitCoin public address generate: https://3bcaf57.webp.li/myblog/BtcPublicKeyGenerate.png
 func (w Wallet) GetAddress() []byte {
-	pubKeyHash := HashPubKey(w.PublicKey)
+	return encodeAddress(version, HashPubKey(w.PublicKey))
+}
+
+// PubKeyHashAddress returns the address paying to a public key hash
+func PubKeyHashAddress(pubKeyHash []byte) string {
+	return string(encodeAddress(version, pubKeyHash))
+}
+
+// ScriptAddress returns the address paying to the hash of a script, see core.PayToScriptHashScript
+func ScriptAddress(scriptHash []byte) string {
+	return string(encodeAddress(scriptVersion, scriptHash))
+}
 
-	versionedPayload := append([]byte{version}, pubKeyHash...)
+func encodeAddress(version byte, hash []byte) []byte {
+	versionedPayload := append([]byte{version}, hash...)
 	checkSum := checksum(versionedPayload)
 
 	fullPayload := append(versionedPayload, checkSum...)
 	return Base58Encode(fullPayload)
 }
 
+// DecodeAddress returns the hash an address pays to, isScript tells whether it is a script hash
+// rather than a public key hash. It returns ErrInvalidAddress if the checksum is wrong
+// or the address belongs to another network.
+func DecodeAddress(address string) (hash []byte, isScript bool, err error) {
+	payload := Base58Decode([]byte(address))
+	if len(payload) <= 1+addressChecksumLen {
+		return nil, false, fmt.Errorf("%w: %s", ErrInvalidAddress, address)
+	}
+	actualCheckSum := payload[len(payload)-addressChecksumLen:]
+	addressVersion := payload[0]
+	hash = payload[1 : len(payload)-addressChecksumLen]
+	targetCheckSum := checksum(append([]byte{addressVersion}, hash...))
+	if !bytes.Equal(actualCheckSum, targetCheckSum) {
+		return nil, false, fmt.Errorf("%w: %s", ErrInvalidAddress, address)
+	}
+
+	switch addressVersion {
+	case version:
+		return hash, false, nil
+	case scriptVersion:
+		return hash, true, nil
+	}
+	return nil, false, fmt.Errorf("%w: %s belongs to another network", ErrInvalidAddress, address)
+}
+
+// ValidateAddress checks the checksum of the address and that it belongs to the current network
 func ValidateAddress(address string) bool {
-	pubKeyHash := Base58Decode([]byte(address))
-	actualCheckSum := pubKeyHash[len(pubKeyHash)-addressChecksumLen:]
-	version := pubKeyHash[0]
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen]
-	targetCheckSum := checksum(append([]byte{version}, pubKeyHash...))
-	return bytes.Equal(actualCheckSum, targetCheckSum)
+	_, _, err := DecodeAddress(address)
+	return err == nil
 }
 
 func HashPubKey(pubKey []byte) []byte {
@@ -3,15 +3,47 @@ package wallet
 import (
 	"bytes"
 	"encoding/gob"
+	"errors"
 	"fmt"
-	"log"
 	"os"
+	"sort"
 )
 
 const walletFile = "wallet_%s.dat"
 
+// GapLimit is the number of unused keys in a row after which a chain is considered unused, like BIP44
+const GapLimit = 20
+
+var (
+	// ErrWalletNotFound is returned when the wallet file holds no key for an address
+	ErrWalletNotFound = errors.New("wallet is not found")
+	// ErrHDSeedExists is returned when setting the seed of a wallet file that already has one
+	ErrHDSeedExists = errors.New("wallet file already has an HD seed")
+)
+
+// WalletAddress is an address the wallet file tracks the coins of
+type WalletAddress struct {
+	Address string
+	// WatchOnly is set when the wallet does not hold the key to spend the coins of the address
+	WatchOnly bool
+}
+
 type Wallets struct {
 	Wallets map[string]*Wallet
+	// Seed is the seed of the HD key chains derived from the mnemonic, nil when the keys are all random
+	Seed []byte
+	// NextIndex is the index of the next key of the receive and change chains
+	NextIndex [2]uint32
+	// WatchOnly maps the watch-only addresses to their public key, nil for an address imported without it.
+	// The wallet tracks their coins without being able to spend them.
+	WatchOnly map[string][]byte
+	// Labels names addresses of the wallet and of the address book
+	Labels map[string]string
+	// History indexes the transactions touching the addresses of the wallet
+	History History
+
+	// crypt is set when the wallet file is encrypted
+	crypt *walletCrypt
 }
 
 func NewWallets(nodeID string) (*Wallets, error) {
@@ -21,15 +53,143 @@ func NewWallets(nodeID string) (*Wallets, error) {
 	return &wallets, err
 }
 
-// CreateWallet adds a Wallet to Wallets
-func (ws *Wallets) CreateWallet() string {
-	wallet := NewWallet()
+// CreateWallet adds a Wallet to Wallets, it is the next key of the receive chain of an HD wallet
+// and a random key otherwise
+func (ws *Wallets) CreateWallet() (string, error) {
+	if ws.IsHD() {
+		return ws.NewAddress(ReceiveChain), nil
+	}
+
+	wallet, err := NewWallet()
+	if err != nil {
+		return "", err
+	}
+	address := string(wallet.GetAddress())
+
+	ws.Wallets[address] = wallet
+	return address, nil
+}
+
+// ImportWallet adds a key pair exported by another node to the wallet file and returns its address,
+// it is no longer watch-only if it was
+func (ws *Wallets) ImportWallet(wallet *Wallet) (string, error) {
+	if ws.IsLocked() {
+		return "", ErrWalletLocked
+	}
 	address := string(wallet.GetAddress())
+	ws.Wallets[address] = wallet
+	delete(ws.WatchOnly, address)
+	return address, nil
+}
+
+// ImportPubKey adds the address of the public key to the watch-only addresses and returns it
+func (ws *Wallets) ImportPubKey(pubKey []byte) (string, error) {
+	if ws.IsLocked() {
+		return "", ErrWalletLocked
+	}
+	if err := ValidatePublicKey(pubKey); err != nil {
+		return "", err
+	}
+	address := string(encodeAddress(version, HashPubKey(pubKey)))
+	if _, ok := ws.Wallets[address]; ok {
+		// the wallet can already spend its coins
+		return address, nil
+	}
+	if ws.WatchOnly == nil {
+		ws.WatchOnly = make(map[string][]byte)
+	}
+	ws.WatchOnly[address] = pubKey
+	return address, nil
+}
+
+// ImportAddress adds an address to the watch-only addresses, like a multisig address or the one of another wallet.
+// It returns ErrInvalidAddress if the address is not valid on the current network.
+func (ws *Wallets) ImportAddress(address string) error {
+	if ws.IsLocked() {
+		return ErrWalletLocked
+	}
+	if !ValidateAddress(address) {
+		return fmt.Errorf("%w: %s", ErrInvalidAddress, address)
+	}
+	if _, ok := ws.Wallets[address]; ok {
+		return nil
+	}
+	if _, ok := ws.WatchOnly[address]; ok {
+		// keep its public key
+		return nil
+	}
+	if ws.WatchOnly == nil {
+		ws.WatchOnly = make(map[string][]byte)
+	}
+	ws.WatchOnly[address] = nil
+	return nil
+}
+
+// IsHD reports whether the wallet file derives its keys from a seed
+func (ws *Wallets) IsHD() bool {
+	return len(ws.Seed) > 0
+}
 
+// SetMnemonic makes an HD wallet of the wallet file with the seed of the mnemonic,
+// the random keys it holds are kept. It returns ErrHDSeedExists if it already has a seed.
+func (ws *Wallets) SetMnemonic(mnemonic string) error {
+	if ws.IsLocked() {
+		return ErrWalletLocked
+	}
+	if ws.IsHD() {
+		return ErrHDSeedExists
+	}
+	seed, err := MnemonicToSeed(mnemonic, "")
+	if err != nil {
+		return err
+	}
+	ws.Seed = seed
+	ws.NextIndex = [2]uint32{}
+	return nil
+}
+
+// NewAddress derives the next key of the receive or change chain of an HD wallet and returns its address
+func (ws *Wallets) NewAddress(chain uint32) string {
+	wallet := DeriveWallet(ws.Seed, chain, ws.NextIndex[chain])
+	ws.NextIndex[chain]++
+
+	address := string(wallet.GetAddress())
 	ws.Wallets[address] = wallet
 	return address
 }
 
+// Scan rediscovers the keys of the HD chains that were used, after a restore from the mnemonic.
+// used tells whether a public key hash owns coins. The keys of a chain are derived in order
+// until GapLimit of them in a row are unused, the keys up to the last used one are added to the wallet.
+// It returns the number of used keys found.
+func (ws *Wallets) Scan(used func(pubKeyHash []byte) (bool, error)) (int, error) {
+	found := 0
+	for _, chain := range []uint32{ReceiveChain, ChangeChain} {
+		var keys []*Wallet
+		for index, gap := ws.NextIndex[chain], 0; gap < GapLimit; index++ {
+			wallet := DeriveWallet(ws.Seed, chain, index)
+			keys = append(keys, wallet)
+			ok, err := used(HashPubKey(wallet.PublicKey))
+			if err != nil {
+				return found, err
+			}
+			if !ok {
+				gap++
+				continue
+			}
+
+			found++
+			gap = 0
+			for _, key := range keys {
+				ws.Wallets[string(key.GetAddress())] = key
+			}
+			keys = nil
+			ws.NextIndex[chain] = index + 1
+		}
+	}
+	return found, nil
+}
+
 // GetAddresses returns an array of addresses stored in the wallet file
 func (ws *Wallets) GetAddresses() []string {
 	var addresses []string
@@ -41,12 +201,40 @@ func (ws *Wallets) GetAddresses() []string {
 	return addresses
 }
 
-// GetWallet returns a Wallet by its address
-func (ws Wallets) GetWallet(address string) Wallet {
-	return *ws.Wallets[address]
+// ListAddresses returns the addresses of the wallet file, the spendable ones first, each sorted
+func (ws *Wallets) ListAddresses() []WalletAddress {
+	spendable := ws.GetAddresses()
+	sort.Strings(spendable)
+	var watchOnly []string
+	for address := range ws.WatchOnly {
+		watchOnly = append(watchOnly, address)
+	}
+	sort.Strings(watchOnly)
+
+	addresses := make([]WalletAddress, 0, len(spendable)+len(watchOnly))
+	for _, address := range spendable {
+		addresses = append(addresses, WalletAddress{address, false})
+	}
+	for _, address := range watchOnly {
+		addresses = append(addresses, WalletAddress{address, true})
+	}
+	return addresses
+}
+
+// GetWallet returns a Wallet by its address, it returns ErrWalletLocked if the wallet file is locked
+func (ws Wallets) GetWallet(address string) (Wallet, error) {
+	if ws.IsLocked() {
+		return Wallet{}, ErrWalletLocked
+	}
+	wallet, ok := ws.Wallets[address]
+	if !ok {
+		return Wallet{}, fmt.Errorf("%w: %s", ErrWalletNotFound, address)
+	}
+	return *wallet, nil
 }
 
-// LoadFromFile loads wallets from the file
+// LoadFromFile loads wallets from the file, they are keyed by their address on the current network.
+// An encrypted wallet file is loaded locked, see Unlock.
 func (ws *Wallets) LoadFromFile(nodeID string) error {
 	walletFile := fmt.Sprintf(walletFile, nodeID)
 	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
@@ -55,29 +243,72 @@ func (ws *Wallets) LoadFromFile(nodeID string) error {
 
 	fileContent, err := os.ReadFile(walletFile)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
+	if bytes.HasPrefix(fileContent, encryptedMagic) {
+		ws.crypt = &walletCrypt{}
+		err = gob.NewDecoder(bytes.NewReader(fileContent[len(encryptedMagic):])).Decode(&ws.crypt.file)
+		if err != nil {
+			return fmt.Errorf("decode %s: %w", walletFile, err)
+		}
+		return removeLegacyUnlockFile(nodeID)
+	}
+	if err = ws.decode(fileContent); err != nil {
+		return fmt.Errorf("decode %s: %w", walletFile, err)
+	}
+	return nil
+}
+
+// decode sets the wallets of the gob of a wallet file
+func (ws *Wallets) decode(content []byte) error {
 	var wallets Wallets
-	err = gob.NewDecoder(bytes.NewReader(fileContent)).Decode(&wallets)
+	err := gob.NewDecoder(bytes.NewReader(content)).Decode(&wallets)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
-	ws.Wallets = wallets.Wallets
+	ws.Wallets = make(map[string]*Wallet, len(wallets.Wallets))
+	for _, wallet := range wallets.Wallets {
+		ws.Wallets[string(wallet.GetAddress())] = wallet
+	}
+	ws.Seed = wallets.Seed
+	ws.NextIndex = wallets.NextIndex
+	ws.WatchOnly = make(map[string][]byte, len(wallets.WatchOnly))
+	for address, pubKey := range wallets.WatchOnly {
+		if pubKey != nil {
+			address = string(encodeAddress(version, HashPubKey(pubKey)))
+		}
+		ws.WatchOnly[address] = pubKey
+	}
+	ws.Labels = wallets.Labels
+	ws.History = wallets.History
 	return nil
 }
 
-// SaveToFile saves wallets to a file
-func (ws Wallets) SaveToFile(nodeID string) {
+// SaveToFile saves wallets to a file only the user can read, encrypted if the wallet file is.
+// It returns ErrWalletLocked if the wallet file is locked.
+func (ws Wallets) SaveToFile(nodeID string) error {
+	if ws.IsLocked() {
+		return ErrWalletLocked
+	}
 	fileContent := bytes.Buffer{}
 	walletFile := fmt.Sprintf(walletFile, nodeID)
 	err := gob.NewEncoder(&fileContent).Encode(ws)
 	if err != nil {
-		log.Panic(err)
+		return fmt.Errorf("encode %s: %w", walletFile, err)
 	}
 
-	err = os.WriteFile(walletFile, fileContent.Bytes(), 0644)
-	if err != nil {
-		log.Panic(err)
+	if ws.crypt != nil {
+		err = ws.crypt.seal(fileContent.Bytes())
+		if err != nil {
+			return fmt.Errorf("encrypt %s: %w", walletFile, err)
+		}
+		fileContent.Reset()
+		fileContent.Write(encryptedMagic)
+		err = gob.NewEncoder(&fileContent).Encode(ws.crypt.file)
+		if err != nil {
+			return fmt.Errorf("encode %s: %w", walletFile, err)
+		}
 	}
+	return writeFileAtomic(walletFile, fileContent.Bytes())
 }
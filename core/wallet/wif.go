@@ -0,0 +1,69 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// privateKeyVersion is the first byte of the exported private keys, it is set by the network the node runs on
+var privateKeyVersion = byte(0x80)
+
+// privateKeyLen is the size of an exported private key: the version, the 32 bytes of the key and the checksum
+const privateKeyLen = 1 + 32 + addressChecksumLen
+
+var (
+	// ErrInvalidPrivateKey is returned for an exported private key with a bad checksum or from another network
+	ErrInvalidPrivateKey = errors.New("private key is not valid")
+	// ErrInvalidPublicKey is returned for a public key that is not a point of the curve
+	ErrInvalidPublicKey = errors.New("public key is not valid")
+)
+
+// SetPrivateKeyVersion sets the version byte of the exported private keys of the network, see core.ChainParams
+func SetPrivateKeyVersion(v byte) {
+	privateKeyVersion = v
+}
+
+// EncodePrivateKey exports the private key like the Wallet Import Format of Bitcoin: the version of the network
+// and the 32 bytes of the key followed by their checksum, in Base58. Unlike GobEncode, it is not tied to Go.
+func (w Wallet) EncodePrivateKey() string {
+	payload := append([]byte{privateKeyVersion}, w.PrivateKey.D.FillBytes(make([]byte, 32))...)
+	return string(Base58Encode(append(payload, checksum(payload)...)))
+}
+
+// DecodePrivateKey returns the key pair of a private key exported by EncodePrivateKey.
+// It returns ErrInvalidPrivateKey if the checksum is wrong or the key belongs to another network.
+func DecodePrivateKey(key string) (*Wallet, error) {
+	payload := Base58Decode([]byte(key))
+	if len(payload) != privateKeyLen {
+		return nil, fmt.Errorf("%w: bad length", ErrInvalidPrivateKey)
+	}
+	data, sum := payload[:len(payload)-addressChecksumLen], payload[len(payload)-addressChecksumLen:]
+	if !bytes.Equal(checksum(data), sum) {
+		return nil, fmt.Errorf("%w: bad checksum", ErrInvalidPrivateKey)
+	}
+	if data[0] != privateKeyVersion {
+		return nil, fmt.Errorf("%w: it belongs to another network", ErrInvalidPrivateKey)
+	}
+
+	d := new(big.Int).SetBytes(data[1:])
+	if d.Sign() == 0 || d.Cmp(elliptic.P256().Params().N) >= 0 {
+		return nil, fmt.Errorf("%w: out of the range of the curve", ErrInvalidPrivateKey)
+	}
+	return newWalletFromKey(d), nil
+}
+
+// ValidatePublicKey checks that the public key, X followed by Y on 32 bytes each, is a point of the curve
+func ValidatePublicKey(pubKey []byte) error {
+	if len(pubKey) != PublicKeyLen {
+		return fmt.Errorf("%w: %x", ErrInvalidPublicKey, pubKey)
+	}
+	x := new(big.Int).SetBytes(pubKey[:len(pubKey)/2])
+	y := new(big.Int).SetBytes(pubKey[len(pubKey)/2:])
+	if !elliptic.P256().IsOnCurve(x, y) {
+		return fmt.Errorf("%w: %x", ErrInvalidPublicKey, pubKey)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package core
+
+import (
+	"blockchain-from-scratch/core/wallet"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+)
+
+// SyncWalletHistory indexes the transactions of the main chain touching the addresses of the wallet,
+// from the block after the last one indexed up to the tip. The index is built again from the genesis block
+// when a reorganization removed the last block indexed.
+func (bc *Blockchain) SyncWalletHistory(ws *wallet.Wallets) error {
+	if ws.IsLocked() {
+		return wallet.ErrWalletLocked
+	}
+	owned := make(map[string]bool)
+	for _, address := range ws.ListAddresses() {
+		owned[address.Address] = true
+	}
+
+	return bc.Store.View(func(tx StoreTx) error {
+		from := 0
+		if ws.History.TipHash != nil {
+			hash, err := getHashByHeight(tx, ws.History.TipHeight)
+			if err == nil && bytes.Equal(hash, ws.History.TipHash) {
+				from = ws.History.TipHeight + 1
+			} else {
+				ws.ResetHistory()
+			}
+		}
+		tipHeader, err := getHeader(tx, getTip(tx))
+		if err != nil {
+			return err
+		}
+
+		for height := from; height <= tipHeader.Height; height++ {
+			hash, err := getHashByHeight(tx, height)
+			if err != nil {
+				return err
+			}
+			block, err := getBlock(tx, hash)
+			if err != nil {
+				return err
+			}
+			// the undo data holds the outputs spent by the block in order, a block of a coinbase only has none
+			var spent []UTXO
+			if len(block.Transactions) > 1 {
+				undo, err := getBlockUndo(tx, hash)
+				if err != nil {
+					return err
+				}
+				spent = undo.Spent
+			}
+
+			for _, blockTx := range block.Transactions {
+				var prevOuts []UTXO
+				if !blockTx.IsCoinbase() {
+					if len(spent) < len(blockTx.Vin) {
+						return fmt.Errorf("undo data of block %x does not match its inputs", hash)
+					}
+					prevOuts, spent = spent[:len(blockTx.Vin)], spent[len(blockTx.Vin):]
+				}
+				if walletTx, ok := newWalletTx(blockTx, prevOuts, owned); ok {
+					walletTx.Height = block.Height
+					walletTx.BlockHash = hex.EncodeToString(block.Hash)
+					walletTx.Timestamp = block.Timestamp
+					ws.History.Txs = append(ws.History.Txs, *walletTx)
+				}
+			}
+			ws.History.TipHash = hash
+			ws.History.TipHeight = height
+		}
+		return nil
+	})
+}
+
+// newWalletTx returns the view of the wallet of a transaction spending prevOuts,
+// false if it does not touch the owned addresses
+func newWalletTx(tx *Transaction, prevOuts []UTXO, owned map[string]bool) (*wallet.WalletTx, bool) {
+	var addresses, counterparties txAddresses
+	walletIn, walletOut, otherOut, totalIn := 0, 0, 0, 0
+	fundedByWallet := true
+
+	for _, prevOut := range prevOuts {
+		totalIn += prevOut.Value
+		if address, ok := scriptAddress(prevOut.ScriptPubKey); ok && owned[address] {
+			walletIn += prevOut.Value
+			addresses.add(wallet.TxAddress{Address: address, Amount: -prevOut.Value})
+		} else {
+			fundedByWallet = false
+			counterparties.add(outputAddress(prevOut.ScriptPubKey, -prevOut.Value))
+		}
+	}
+	for _, out := range tx.VOut {
+		if address, ok := scriptAddress(out.ScriptPubKey); ok && owned[address] {
+			walletOut += out.Value
+			addresses.add(wallet.TxAddress{Address: address, Amount: out.Value})
+		} else {
+			otherOut += out.Value
+			counterparties.add(outputAddress(out.ScriptPubKey, out.Value))
+		}
+	}
+	if len(addresses) == 0 {
+		return nil, false
+	}
+
+	walletTx := &wallet.WalletTx{Txid: hex.EncodeToString(tx.ID), Addresses: addresses}
+	switch {
+	case tx.IsCoinbase():
+		walletTx.Direction, walletTx.Amount = wallet.TxGenerate, walletOut
+	case walletIn == 0:
+		// the counterparties are the owners of the outputs spent
+		walletTx.Direction, walletTx.Amount = wallet.TxReceive, walletOut
+		walletTx.Counterparties = counterparties.spent()
+	default:
+		if fundedByWallet {
+			walletTx.Fee = totalIn - walletOut - otherOut
+		}
+		walletTx.Direction, walletTx.Amount = wallet.TxSelf, 0
+		if otherOut > 0 {
+			walletTx.Direction, walletTx.Amount = wallet.TxSend, -otherOut
+		}
+		walletTx.Counterparties = counterparties.paid()
+	}
+	return walletTx, true
+}
+
+// scriptAddress returns the address a locking script pays to, false for a script without one
+func scriptAddress(script Script) (string, bool) {
+	if pubKeyHash := script.PubKeyHash(); pubKeyHash != nil {
+		return wallet.PubKeyHashAddress(pubKeyHash), true
+	}
+	if scriptHash := script.ScriptHash(); scriptHash != nil {
+		return wallet.ScriptAddress(scriptHash), true
+	}
+	return "", false
+}
+
+// outputAddress returns the address of a counterparty, the class of its script when it has no address
+func outputAddress(script Script, amount int) wallet.TxAddress {
+	if address, ok := scriptAddress(script); ok {
+		return wallet.TxAddress{Address: address, Amount: amount}
+	}
+	return wallet.TxAddress{Script: script.Class().String(), Amount: amount}
+}
+
+// txAddresses adds up the amounts of the same address
+type txAddresses []wallet.TxAddress
+
+func (a *txAddresses) add(address wallet.TxAddress) {
+	for i := range *a {
+		if address.Address != "" && (*a)[i].Address == address.Address {
+			(*a)[i].Amount += address.Amount
+			return
+		}
+	}
+	*a = append(*a, address)
+}
+
+// spent returns the addresses spent from
+func (a txAddresses) spent() []wallet.TxAddress {
+	var result []wallet.TxAddress
+	for _, address := range a {
+		if address.Amount < 0 {
+			result = append(result, address)
+		}
+	}
+	return result
+}
+
+// paid returns the addresses paid to
+func (a txAddresses) paid() []wallet.TxAddress {
+	var result []wallet.TxAddress
+	for _, address := range a {
+		if address.Amount >= 0 {
+			result = append(result, address)
+		}
+	}
+	return result
+}
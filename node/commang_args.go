@@ -1,19 +1,28 @@
 package node
 
-import (
-	"blockchain-from-scratch/core"
-)
+import "blockchain-from-scratch/core"
 
+// maxHeadersPerMsg is the maximum number of headers sent in one headers message
+const maxHeadersPerMsg = 2000
+
+const magicLength = 4
 const commandLength = 12
 const protocol = "tcp"
 const nodeVersion = 1
 
+// netParams are the parameters of the network the node runs on, see useNetwork
+var netParams = &core.MainNetParams
 var nodeAddress string
 var miningAddress string
 var knownNodes = []string{"localhost:3000"}
 var minerNodes = []string{}
 var blocksInTransit = [][]byte{}
-var mempool = make(map[string]core.Transaction)
+
+// useNetwork makes the node talk to the seeds of the network and sign its messages with the network magic
+func useNetwork(params *core.ChainParams) {
+	netParams = params
+	knownNodes = params.SeedAddresses()
+}
 
 func commandToBytes(command string) []byte {
 	var bytes [commandLength]byte
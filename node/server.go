@@ -4,8 +4,7 @@ import (
 	"blockchain-from-scratch/core"
 	"blockchain-from-scratch/utils"
 	"bytes"
-	"encoding/gob"
-	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -15,8 +14,11 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func StartServer(nodeId, minerAddress string) {
-	nodeAddress = fmt.Sprintf("localhost:%s", nodeId)
+// StartServer runs the node on the network, it only talks to nodes of the same network.
+// It listens on port, the node on the default port of the network is the central node.
+func StartServer(params *core.ChainParams, nodeId, port, minerAddress string) {
+	useNetwork(params)
+	nodeAddress = net.JoinHostPort("localhost", port)
 	miningAddress = minerAddress
 	nodeNet, err := net.Listen(protocol, nodeAddress)
 	if err != nil {
@@ -25,7 +27,11 @@ func StartServer(nodeId, minerAddress string) {
 
 	defer nodeNet.Close()
 
-	bc := core.NewBlockChain(nodeId)
+	bc, err := core.NewBlockChain(params, nodeId)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer bc.Close()
 
 	// If the current node is not the central node,
 	// it must send a version message to the central node to query whether its blockchain is outdated.
@@ -65,6 +71,13 @@ func handleConnection(conn net.Conn, bc *core.Blockchain) {
 		conn.Close()
 		return
 	}
+	// messages of other networks are dropped before being decoded
+	if len(request) < magicLength+commandLength || !bytes.Equal(request[:magicLength], netParams.Magic[:]) {
+		logrus.Warnf("Drop message from %s: not on the %s network", conn.RemoteAddr(), netParams.Name)
+		conn.Close()
+		return
+	}
+	request = request[magicLength:]
 	command := bytesToCommand(request[:commandLength])
 	fmt.Printf("%s: ==> Received %s command \n", time.Now().Format("2006-01-02 15:04:05.000"), command)
 
@@ -73,8 +86,10 @@ func handleConnection(conn net.Conn, bc *core.Blockchain) {
 		handleBlock(request, bc)
 	case "inv":
 		handleInv(request, bc)
-	case "getblocks":
-		handleGetBlocks(request, bc)
+	case "getheaders":
+		handleGetHeaders(request, bc)
+	case "headers":
+		handleHeaders(request, bc)
 	case "getdata":
 		handleGetData(request, bc)
 	case "tx":
@@ -92,37 +107,44 @@ func handleConnection(conn net.Conn, bc *core.Blockchain) {
 
 func sendMinerInfo(addr string) {
 	minerInfo := miner{miningAddress, nodeAddress}
-	payload := utils.Serialize(minerInfo)
-	request := append(commandToBytes("minerInfo"), payload...)
-	sendData(addr, request)
+	sendMessage(addr, "minerInfo", minerInfo)
 }
 func handleMinerInfo(request []byte, bc *core.Blockchain) {
 	var payload miner
-	decodeRequest(request, &payload)
+	if !decodeRequest(request, &payload) {
+		return
+	}
 	utils.PrintJsonLog(&payload, "handleMinerInfo")
 	miningAddress = payload.MinerAddr
 	if !nodeIsMiner(payload.MinerNodeAddr) {
 		minerNodes = append(minerNodes, payload.MinerNodeAddr)
 		logrus.Infof("add miner node: %s \n ", payload.MinerNodeAddr)
 	}
-	logrus.Infof("mining address: %s", payload.MinerNodeAddr, miningAddress)
+	logrus.Infof("miner node: %s, mining address: %s", payload.MinerNodeAddr, miningAddress)
 }
 
 func sendVersion(addr string, bc *core.Blockchain) {
-	bestHeight := bc.GetBestHeight()
-	version := version{nodeVersion, bestHeight, nodeAddress}
-	payload := utils.Serialize(version)
-	request := append(commandToBytes("version"), payload...)
-	sendData(addr, request)
+	bestHeight, err := bc.GetBestHeight()
+	if err != nil {
+		logrus.Errorf("Cannot read best height: %s", err)
+		return
+	}
+	sendMessage(addr, "version", version{nodeVersion, bestHeight, nodeAddress})
 }
 func handleVersion(request []byte, bc *core.Blockchain) {
-	myBestHeight := bc.GetBestHeight()
+	myBestHeight, err := bc.GetBestHeight()
+	if err != nil {
+		logrus.Errorf("Cannot read best height: %s", err)
+		return
+	}
 	var payload version
-	decodeRequest(request, &payload)
+	if !decodeRequest(request, &payload) {
+		return
+	}
 	requestNodeBestHeight := payload.BestHeight
 
 	if myBestHeight < requestNodeBestHeight {
-		sendGetBlocks(payload.AddrFrom)
+		sendGetHeaders(payload.AddrFrom, bc)
 	} else if myBestHeight > requestNodeBestHeight {
 		sendVersion(payload.AddrFrom, bc)
 	}
@@ -130,13 +152,13 @@ func handleVersion(request []byte, bc *core.Blockchain) {
 		knownNodes = append(knownNodes, payload.AddrFrom)
 		logrus.Infof("Known nodes: %s", knownNodes)
 		for _, node := range knownNodes {
-			sendGetBlocks(node)
+			sendGetHeaders(node, bc)
 		}
 	}
 }
 
 func sendData(addr string, data []byte) {
-	logrus.Infof("%s: ==> Send %s data to %s\n", time.Now().Format("2006-01-02 15:04:05.000"), data[:commandLength], addr)
+	logrus.Infof("%s: ==> Send %s data to %s\n", time.Now().Format("2006-01-02 15:04:05.000"), bytesToCommand(data[magicLength:magicLength+commandLength]), addr)
 	conn, err := net.Dial(protocol, addr)
 	if err != nil {
 		// add new  node
@@ -163,41 +185,62 @@ func sendData(addr string, data []byte) {
 // func handleAddr(request []byte) {
 // }
 
+// sendMessage encodes the payload and sends it to the peer behind the network magic and the command name
+func sendMessage(addr, command string, payload interface{}) {
+	data, err := utils.Serialize(payload)
+	if err != nil {
+		logrus.Errorf("Cannot encode %s message: %s", command, err)
+		return
+	}
+	message := append(netParams.Magic[:], commandToBytes(command)...)
+	sendData(addr, append(message, data...))
+}
+
 func sendBlock(address string, b *core.Block) {
-	data := nodeBlock{nodeAddress, utils.Serialize(b)}
-	payload := utils.Serialize(data)
-	request := append(commandToBytes("block"), payload...)
-	sendData(address, request)
+	blockData, err := utils.Serialize(b)
+	if err != nil {
+		logrus.Errorf("Cannot encode block %x: %s", b.Hash, err)
+		return
+	}
+	sendMessage(address, "block", nodeBlock{nodeAddress, blockData})
 }
 func handleBlock(request []byte, bc *core.Blockchain) {
 	var payload nodeBlock
-	decodeRequest(request, &payload)
+	if !decodeRequest(request, &payload) {
+		return
+	}
 
 	blockData := payload.Block
 	var block core.Block
-	utils.Deserialize(blockData, &block)
-	bc.AddBlock(&block)
+	err := utils.Deserialize(blockData, &block)
+	if err != nil {
+		logrus.Warnf("Reject block from %s: %s", payload.AddrFrom, err)
+		return
+	}
+	// AddBlock keeps the chainstate and the mempool in sync with the best chain
+	err = bc.AddBlock(&block)
+	if errors.Is(err, core.ErrOrphanBlock) {
+		// we are missing the blocks before this one, sync the headers first
+		sendGetHeaders(payload.AddrFrom, bc)
+	} else if err != nil {
+		logrus.Warnf("Reject block %x: %s", block.Hash, err)
+	}
 
-	//utxoSet.Update(&block)
 	if len(blocksInTransit) > 0 {
 		blockHash := blocksInTransit[0]
 		sendGetData(payload.AddrFrom, "block", blockHash)
 		blocksInTransit = blocksInTransit[1:]
-	} else {
-		utxoSet := core.UTXOSet{Blockchain: bc}
-		utxoSet.Reindex()
 	}
 }
 
 func sendInv(address, kind string, items [][]byte) {
-	inventory := inv{nodeAddress, kind, items}
-	payload := utils.Serialize(inventory)
-	request := append(commandToBytes("inv"), payload...)
-	sendData(address, request)
+	sendMessage(address, "inv", inv{nodeAddress, kind, items})
 }
 func handleInv(request []byte, bc *core.Blockchain) {
 	var payload inv
-	decodeRequest(request, &payload)
+	if !decodeRequest(request, &payload) {
+		return
+	}
 	logrus.Infof("Recevied inventory with %d %s\n", len(payload.Items), payload.Type)
 
 	if payload.Type == "block" {
@@ -220,33 +263,85 @@ func handleInv(request []byte, bc *core.Blockchain) {
 
 	if payload.Type == "tx" {
 		txId := payload.Items[0]
-		// TODO mempool process
-		if mempool[hex.EncodeToString(txId)].ID == nil {
+		if !bc.Mempool.Has(txId) {
 			sendGetData(payload.AddrFrom, payload.Type, txId)
 		}
 	}
 }
 
-func sendGetBlocks(address string) {
-	payload := utils.Serialize(getblocks{nodeAddress})
-	request := append(commandToBytes("getblocks"), payload...)
-	sendData(address, request)
+// sendGetHeaders asks a peer for the headers that follow our main chain.
+// Headers are validated on their own before the block bodies are downloaded.
+func sendGetHeaders(address string, bc *core.Blockchain) {
+	locator, err := bc.BlockLocator()
+	if err != nil {
+		logrus.Errorf("Cannot build block locator: %s", err)
+		return
+	}
+	sendMessage(address, "getheaders", getheaders{nodeAddress, locator})
+}
+func handleGetHeaders(request []byte, bc *core.Blockchain) {
+	var payload getheaders
+	if !decodeRequest(request, &payload) {
+		return
+	}
+	blockHeaders, _, err := bc.GetHeaders(payload.Locator, maxHeadersPerMsg)
+	if err != nil {
+		logrus.Errorf("Cannot read headers: %s", err)
+		return
+	}
+	sendHeaders(payload.AddrFrom, blockHeaders)
+}
+
+func sendHeaders(address string, blockHeaders []core.BlockHeader) {
+	sendMessage(address, "headers", headers{nodeAddress, blockHeaders})
 }
-func handleGetBlocks(request []byte, bc *core.Blockchain) {
-	var payload getblocks
-	decodeRequest(request, &payload)
-	blocks := bc.GetBlockHashes()
-	sendInv(payload.AddrFrom, "block", blocks)
+func handleHeaders(request []byte, bc *core.Blockchain) {
+	var payload headers
+	if !decodeRequest(request, &payload) {
+		return
+	}
+	logrus.Infof("Recevied %d headers\n", len(payload.Headers))
+
+	// headers come oldest first, each one is checked against its parent before we ask for its body
+	var missing [][]byte
+	for _, header := range payload.Headers {
+		hash, err := header.BlockHash()
+		if err != nil {
+			logrus.Warnf("Reject header: %s", err)
+			break
+		}
+		err = bc.AddHeader(&header, hash)
+		if err != nil {
+			logrus.Warnf("Reject header %x: %s", hash, err)
+			break
+		}
+		found, err := bc.HasBlock(hash)
+		if err != nil {
+			logrus.Errorf("Cannot look up block %x: %s", hash, err)
+			break
+		}
+		if !found {
+			missing = append(missing, hash)
+		}
+	}
+
+	if len(missing) > 0 {
+		blocksInTransit = missing[1:]
+		sendGetData(payload.AddrFrom, "block", missing[0])
+	}
+	if len(payload.Headers) == maxHeadersPerMsg {
+		sendGetHeaders(payload.AddrFrom, bc)
+	}
 }
 
 func sendGetData(address, kind string, id []byte) {
-	payload := utils.Serialize(getdata{address, nodeAddress, kind, id})
-	request := append(commandToBytes("getdata"), payload...)
-	sendData(address, request)
+	sendMessage(address, "getdata", getdata{address, nodeAddress, kind, id})
 }
 func handleGetData(request []byte, bc *core.Blockchain) {
 	var payload getdata
-	decodeRequest(request, &payload)
+	if !decodeRequest(request, &payload) {
+		return
+	}
 
 	// TODO should check the block or tx is exist
 	if payload.Type == "block" {
@@ -259,31 +354,48 @@ func handleGetData(request []byte, bc *core.Blockchain) {
 	}
 
 	if payload.Type == "tx" {
-		txID := hex.EncodeToString(payload.ID)
-		tx := mempool[txID]
+		tx, ok := bc.Mempool.Get(payload.ID)
+		if !ok {
+			return
+		}
 		sendTx(payload.AddrTo, &tx)
 	}
 }
 
-func SendTxToNode(tnx *core.Transaction) {
+// SendTxToNode sends the transaction to the central node of the network
+func SendTxToNode(params *core.ChainParams, tnx *core.Transaction) {
+	useNetwork(params)
 	sendTx(knownNodes[0], tnx)
 }
 
 func sendTx(address string, tnx *core.Transaction) {
-	payload := utils.Serialize(tx{address, utils.Serialize(tnx)})
-	request := append(commandToBytes("tx"), payload...)
-	sendData(address, request)
+	txData, err := utils.Serialize(tnx)
+	if err != nil {
+		logrus.Errorf("Cannot encode transaction %x: %s", tnx.ID, err)
+		return
+	}
+	sendMessage(address, "tx", tx{address, txData})
 }
 func handleTx(request []byte, bc *core.Blockchain) {
 	var payload tx
-	decodeRequest(request, &payload)
+	if !decodeRequest(request, &payload) {
+		return
+	}
 	//utils.PrintJsonLog(&payload, "handleTx")
 	txData := payload.Transaction
 	var tx core.Transaction
-	utils.Deserialize(txData, &tx)
+	err := utils.Deserialize(txData, &tx)
+	if err != nil {
+		logrus.Warnf("Reject transaction from %s: %s", payload.AddFrom, err)
+		return
+	}
 
-	// add tx into mempool
-	mempool[hex.EncodeToString(tx.ID)] = tx
+	// add tx into mempool, it is rejected until its lock times let it be mined
+	err = bc.AcceptTransaction(&tx)
+	if err != nil {
+		logrus.Warnf("Reject transaction from %s: %s", payload.AddFrom, err)
+		return
+	}
 	utils.PrintJsonLog(&tx, "handleTx")
 	// TODO should be decentralized
 	if nodeAddress == knownNodes[0] {
@@ -292,17 +404,16 @@ func handleTx(request []byte, bc *core.Blockchain) {
 				sendInv(node, "tx", [][]byte{tx.ID})
 			}
 		}
-	} else if len(mempool) >= 1 && len(miningAddress) > 0 {
+	} else if bc.Mempool.Len() >= 1 && len(miningAddress) > 0 {
 		// if mempool is not empty , try to mine block
-		logrus.Infof("Start mining block with %d transactions", len(mempool))
+		logrus.Infof("Start mining block with %d transactions", bc.Mempool.Len())
 	MineTransactions:
-		var txs []*core.Transaction
-		// verfiy tx
-		for id := range mempool {
-			tx := mempool[id]
-			if bc.VerifyTransaction(&tx) {
-				txs = append(txs, &tx)
-			}
+		// verify the txs together and collect the fees for the coinbase,
+		// the invalid and conflicting ones are evicted so they cannot stall the next attempts
+		txs, fees, err := bc.SelectTransactions()
+		if err != nil {
+			logrus.Errorf("Cannot select transactions: %s", err)
+			return
 		}
 		if len(txs) == 0 {
 			logrus.Info("All transactions are invalid! Waiting for new ones...")
@@ -311,16 +422,19 @@ func handleTx(request []byte, bc *core.Blockchain) {
 
 		// mine new block
 		// TODO need sync miningAddress with other nodes
-		//cbtx := core.NewCoinbaseTx(miningAddress, "")
-		cbtx := core.NewCoinbaseTx("1Gn2Dm7ALcoGfNDiLbpm9VsMVSPTe27JVm", "")
-		txs = append(txs, cbtx)
-		newBlock := bc.MineBlock(txs)
-		utxoSet := core.UTXOSet{Blockchain: bc}
-		utxoSet.Reindex()
-
-		for _, tx := range txs {
-			txId := hex.EncodeToString(tx.ID)
-			delete(mempool, txId)
+		// the reward goes to the address of this network given with -miner
+		cbtx, err := bc.NewCoinbaseTx(miningAddress, fees)
+		if err != nil {
+			logrus.Errorf("Cannot create coinbase: %s", err)
+			return
+		}
+		// the coinbase must be the first transaction of the block
+		txs = append([]*core.Transaction{cbtx}, txs...)
+		// mined transactions are removed from the mempool once the block is connected
+		newBlock, err := bc.MineBlock(txs)
+		if err != nil {
+			logrus.Errorf("Cannot mine block: %s", err)
+			return
 		}
 
 		for _, node := range knownNodes {
@@ -329,7 +443,7 @@ func handleTx(request []byte, bc *core.Blockchain) {
 			}
 		}
 
-		if len(mempool) > 0 {
+		if bc.Mempool.Len() > 0 {
 			goto MineTransactions
 		}
 	}
@@ -355,12 +469,12 @@ func nodeIsMiner(addr string) bool {
 	return false
 }
 
-func decodeRequest(request []byte, v interface{}) {
-	var buff bytes.Buffer
-
-	buff.Write(request[commandLength:])
-	err := gob.NewDecoder(&buff).Decode(v)
+// decodeRequest decodes the payload of the request into v, a malformed request is logged and dropped
+func decodeRequest(request []byte, v interface{}) bool {
+	err := utils.Deserialize(request[commandLength:], v)
 	if err != nil {
-		log.Panic(err)
+		logrus.Warnf("Drop %s request: %s", bytesToCommand(request[:commandLength]), err)
+		return false
 	}
+	return true
 }
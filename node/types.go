@@ -1,5 +1,9 @@
 package node
 
+import (
+	"blockchain-from-scratch/core"
+)
+
 type addr struct {
 	AddrList []string
 }
@@ -9,8 +13,15 @@ type nodeBlock struct {
 	Block    []byte
 }
 
-type getblocks struct {
+type getheaders struct {
+	AddrFrom string
+	// Locator holds hashes of the requester main chain, see core.Blockchain.BlockLocator
+	Locator [][]byte
+}
+
+type headers struct {
 	AddrFrom string
+	Headers  []core.BlockHeader
 }
 
 type getdata struct {
@@ -0,0 +1,66 @@
+package tests
+
+import (
+	"blockchain-from-scratch/core"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func TestAnchors(t *testing.T) {
+	aliceWallet := newWallet(t)
+	alice := string(aliceWallet.GetAddress())
+	bc := newTestChain(t, alice)
+	utxoSet := &core.UTXOSet{Blockchain: bc}
+
+	if _, err := core.NewDataTransaction(aliceWallet, make([]byte, core.MaxDataCarrierSize+1), 0, utxoSet); !errors.Is(err, core.ErrDataTooLarge) {
+		t.Errorf("NewDataTransaction() error = %v, want %v", err, core.ErrDataTooLarge)
+	}
+	tooLarge := &core.Transaction{VOut: []core.TxOutput{{Value: 0, ScriptPubKey: core.NullDataScript(make([]byte, core.MaxDataCarrierSize+1))}}}
+	tooLarge.ID = txID(t, tooLarge)
+	if err := core.CheckTransactionSanity(bc.Params, tooLarge); !errors.Is(err, core.ErrDataTooLarge) {
+		t.Errorf("CheckTransactionSanity() error = %v, want %v", err, core.ErrDataTooLarge)
+	}
+
+	// two document hashes anchored in blocks 1 and 3, for free
+	doc1 := sha256.Sum256([]byte("document 1"))
+	doc2 := sha256.Sum256([]byte("document 2"))
+	anchor1, err := core.NewDataTransaction(aliceWallet, doc1[:], 0, utxoSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mine(t, bc, newCoinbase(t, bc, alice, 0), anchor1)
+	mine(t, bc, newCoinbase(t, bc, alice, 0))
+	anchor2, err := core.NewDataTransaction(aliceWallet, doc2[:], 1, utxoSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mine(t, bc, newCoinbase(t, bc, alice, 1), anchor2)
+	if got := balance(t, bc, alice); got != 40 {
+		t.Errorf("alice balance = %d, want 40", got)
+	}
+
+	// the data outputs are not in the chainstate, even after a reindex
+	if err = utxoSet.Reindex(); err != nil {
+		t.Fatal(err)
+	}
+	dataOut := core.OutPoint{Txid: anchor1.ID, Index: 0}
+	if _, err = utxoSet.GetUTXO(dataOut); !errors.Is(err, core.ErrUTXONotFound) {
+		t.Errorf("GetUTXO() error = %v, want %v", err, core.ErrUTXONotFound)
+	}
+
+	anchors, err := bc.FindAnchors(0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anchors) != 2 || !bytes.Equal(anchors[0].Data, doc1[:]) || !bytes.Equal(anchors[1].Data, doc2[:]) {
+		t.Fatalf("FindAnchors() = %+v, want the two document hashes", anchors)
+	}
+	if anchors[0].Height != 1 || anchors[1].Height != 3 || !bytes.Equal(anchors[1].Txid, anchor2.ID) {
+		t.Errorf("FindAnchors() = %+v, want anchors at heights 1 and 3", anchors)
+	}
+	if anchors, err = bc.FindAnchors(2, 3); err != nil || len(anchors) != 1 {
+		t.Errorf("FindAnchors(2, 3) = %+v, %v, want 1 anchor", anchors, err)
+	}
+}
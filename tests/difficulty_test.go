@@ -0,0 +1,30 @@
+package tests
+
+import (
+	"blockchain-from-scratch/core"
+	"math/big"
+	"testing"
+)
+
+func TestCompactTarget(t *testing.T) {
+	tests := []struct {
+		compact uint32
+		target  *big.Int
+	}{
+		{0x1d00ffff, new(big.Int).Lsh(big.NewInt(0xffff), 8*(0x1d-3))},
+		{0x20010000, new(big.Int).Lsh(big.NewInt(1), 248)},
+		{0x03123456, big.NewInt(0x123456)},
+		{0x02008000, big.NewInt(0x80)},
+	}
+
+	for _, test := range tests {
+		target := core.CompactToBig(test.compact)
+		if target.Cmp(test.target) != 0 {
+			t.Errorf("CompactToBig(%08x) = %x, want %x", test.compact, target, test.target)
+		}
+		compact := core.BigToCompact(test.target)
+		if compact != test.compact {
+			t.Errorf("BigToCompact(%x) = %08x, want %08x", test.target, compact, test.compact)
+		}
+	}
+}
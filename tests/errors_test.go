@@ -0,0 +1,40 @@
+package tests
+
+import (
+	"blockchain-from-scratch/core"
+	"blockchain-from-scratch/core/wallet"
+	"errors"
+	"testing"
+)
+
+func TestTypedErrors(t *testing.T) {
+	aliceWallet := newWallet(t)
+	alice := string(aliceWallet.GetAddress())
+	bob := string(newWallet(t).GetAddress())
+	bc := newTestChain(t, alice)
+
+	if _, err := core.NewBlockchainWithStore(core.NewMemoryStore(), &testParams); !errors.Is(err, core.ErrChainNotFound) {
+		t.Errorf("NewBlockchainWithStore() error = %v, want %v", err, core.ErrChainNotFound)
+	}
+
+	if _, err := core.NewUTXOTransaction(aliceWallet, bob, 1000, 0, &core.UTXOSet{Blockchain: bc}); !errors.Is(err, core.ErrInsufficientFunds) {
+		t.Errorf("NewUTXOTransaction() error = %v, want %v", err, core.ErrInsufficientFunds)
+	}
+
+	if _, err := bc.FindTransaction([]byte("unknown")); !errors.Is(err, core.ErrTxNotFound) {
+		t.Errorf("FindTransaction() error = %v, want %v", err, core.ErrTxNotFound)
+	}
+
+	if _, err := bc.GetBlock([]byte("unknown")); !errors.Is(err, core.ErrBlockNotFound) {
+		t.Errorf("GetBlock() error = %v, want %v", err, core.ErrBlockNotFound)
+	}
+
+	if _, err := core.NewTXOutput(1, "not an address"); !errors.Is(err, wallet.ErrInvalidAddress) {
+		t.Errorf("NewTXOutput() error = %v, want %v", err, wallet.ErrInvalidAddress)
+	}
+
+	wallets := wallet.Wallets{Wallets: map[string]*wallet.Wallet{}}
+	if _, err := wallets.GetWallet(bob); !errors.Is(err, wallet.ErrWalletNotFound) {
+		t.Errorf("GetWallet() error = %v, want %v", err, wallet.ErrWalletNotFound)
+	}
+}
@@ -0,0 +1,62 @@
+package tests
+
+import (
+	"blockchain-from-scratch/core"
+	"errors"
+	"testing"
+)
+
+func TestFees(t *testing.T) {
+	aliceWallet := newWallet(t)
+	alice := string(aliceWallet.GetAddress())
+	bob := string(newWallet(t).GetAddress())
+	miner := string(newWallet(t).GetAddress())
+	bc := newTestChain(t, alice)
+
+	tip, err := bc.GetBlock(tipHash(t, bc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bits, err := bc.CalcNextRequiredBits(&tip.BlockHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	toBob, err := core.NewUTXOTransaction(aliceWallet, bob, 3, 2, &core.UTXOSet{Blockchain: bc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fee, err := bc.TxFee(toBob); err != nil || fee != 2 {
+		t.Fatalf("TxFee() = %d, %v, want 2", fee, err)
+	}
+
+	// outputs above the inputs create money out of thin air
+	overspend, err := core.NewUTXOTransaction(aliceWallet, bob, 3, 0, &core.UTXOSet{Blockchain: bc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	overspend.VOut[0].Value = 100
+	for i := range overspend.Vin {
+		overspend.Vin[i].ScriptSig = nil
+	}
+	overspend.ID = txID(t, overspend)
+	if err = bc.SignTransaction(overspend, aliceWallet.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	if err = bc.VerifyTransaction(overspend); !errors.Is(err, core.ErrSpendTooHigh) {
+		t.Fatalf("VerifyTransaction() error = %v, want %v", err, core.ErrSpendTooHigh)
+	}
+
+	greedy := newBlock(t, []*core.Transaction{coinbaseTx(t, miner, "", 10+3), toBob}, tip.Hash, tip.Height+1, bits)
+	if err = bc.AddBlock(greedy); !errors.Is(err, core.ErrBadCoinbaseValue) {
+		t.Fatalf("AddBlock() error = %v, want %v", err, core.ErrBadCoinbaseValue)
+	}
+
+	mine(t, bc, newCoinbase(t, bc, miner, 2), toBob)
+	if got := balance(t, bc, alice); got != 10-3-2 {
+		t.Errorf("alice balance = %d, want %d", got, 10-3-2)
+	}
+	if got := balance(t, bc, miner); got != 10+2 {
+		t.Errorf("miner balance = %d, want %d", got, 10+2)
+	}
+}
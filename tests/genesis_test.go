@@ -0,0 +1,69 @@
+package tests
+
+import (
+	"blockchain-from-scratch/core"
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestGenesisBlock(t *testing.T) {
+	for _, params := range []*core.ChainParams{&core.MainNetParams, &core.TestNetParams, &core.RegTestParams} {
+		genesis, err := params.GenesisBlock()
+		if err != nil {
+			t.Fatalf("%s GenesisBlock() error = %v", params.Name, err)
+		}
+		if err = core.CheckBlock(params, genesis); err != nil {
+			t.Errorf("%s CheckBlock() error = %v", params.Name, err)
+		}
+	}
+
+	// two nodes starting from scratch are on the same chain and accept each other's blocks
+	first, err := core.InitBlockchainWithStore(core.NewMemoryStore(), &core.RegTestParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := core.InitBlockchainWithStore(core.NewMemoryStore(), &core.RegTestParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if !bytes.Equal(tipHash(t, first), tipHash(t, second)) {
+		t.Fatalf("genesis blocks differ: %x, %x", tipHash(t, first), tipHash(t, second))
+	}
+
+	block := mine(t, first, newCoinbase(t, first, string(newWallet(t).GetAddress()), 0))
+	if err = second.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tipHash(t, second), block.Hash) {
+		t.Errorf("tip = %x, want %x", tipHash(t, second), block.Hash)
+	}
+
+	// changing the rules changes the genesis block, custom networks have none
+	params := core.RegTestParams
+	params.InitialSubsidy = 50
+	if _, err = core.InitBlockchainWithStore(core.NewMemoryStore(), &params); !errors.Is(err, core.ErrBadGenesis) {
+		t.Errorf("InitBlockchainWithStore() error = %v, want %v", err, core.ErrBadGenesis)
+	}
+	params.GenesisHash = ""
+	if _, err = core.InitBlockchainWithStore(core.NewMemoryStore(), &params); !errors.Is(err, core.ErrChainNotFound) {
+		t.Errorf("InitBlockchainWithStore() error = %v, want %v", err, core.ErrChainNotFound)
+	}
+
+	// a network with a hard-coded genesis block cannot be created with another one
+	alice := string(newWallet(t).GetAddress())
+	if _, err = core.CreateBlockchainWithStore(core.NewMemoryStore(), &core.RegTestParams, alice); !errors.Is(err, core.ErrHardCodedGenesis) {
+		t.Errorf("CreateBlockchainWithStore() error = %v, want %v", err, core.ErrHardCodedGenesis)
+	}
+	// nor can a chain be opened when it does not start from it
+	store := core.NewMemoryStore()
+	if _, err = core.CreateBlockchainWithStore(store, &params, alice); err != nil {
+		t.Fatal(err)
+	}
+	params.GenesisHash = core.RegTestParams.GenesisHash
+	if _, err = core.NewBlockchainWithStore(store, &params); !errors.Is(err, core.ErrBadGenesis) {
+		t.Errorf("NewBlockchainWithStore() error = %v, want %v", err, core.ErrBadGenesis)
+	}
+}
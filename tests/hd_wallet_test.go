@@ -0,0 +1,143 @@
+package tests
+
+import (
+	"blockchain-from-scratch/core"
+	"blockchain-from-scratch/core/wallet"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// zeroMnemonic is the mnemonic of 16 zero bytes of entropy, the first test vector of BIP39
+var zeroMnemonic = strings.Repeat("abandon ", 11) + "about"
+
+func TestMnemonic(t *testing.T) {
+	seed, err := wallet.MnemonicToSeed(zeroMnemonic, "TREZOR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04"
+	if got := hex.EncodeToString(seed); got != want {
+		t.Errorf("MnemonicToSeed() = %s, want %s", got, want)
+	}
+
+	mnemonic, err := wallet.NewMnemonic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if words := strings.Fields(mnemonic); len(words) != 12 {
+		t.Errorf("NewMnemonic() has %d words, want 12", len(words))
+	}
+	if err = wallet.ValidateMnemonic(mnemonic); err != nil {
+		t.Errorf("ValidateMnemonic(%q) error = %v", mnemonic, err)
+	}
+	for _, bad := range []string{
+		strings.Repeat("abandon ", 12),
+		strings.Repeat("abandon ", 11) + "bitcoins",
+		"abandon about",
+	} {
+		if err = wallet.ValidateMnemonic(bad); !errors.Is(err, wallet.ErrInvalidMnemonic) {
+			t.Errorf("ValidateMnemonic(%q) error = %v, want %v", bad, err, wallet.ErrInvalidMnemonic)
+		}
+	}
+}
+
+// TestExtendedKey checks the derivation against the first test vector of SLIP-10 for nist256p1
+func TestExtendedKey(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	tests := []struct {
+		path      []uint32
+		chainCode string
+		key       string
+	}{
+		{nil, "beeb672fe4621673f722f38529c07392fecaa61015c80c34f29ce8b41b3cb6ea", "612091aaa12e22dd2abef664f8a01a82cae99ad7441b7ef8110424915c268bc2"},
+		{[]uint32{wallet.HardenedKeyStart}, "3460cea53e6a6bb5fb391eeef3237ffd8724bf0a40e94943c98b83825342ee11", "6939694369114c67917a182c59ddb8cafc3004e63ca5d3b84403ba8613debc0c"},
+	}
+	for _, tt := range tests {
+		key := wallet.NewMasterKey(seed).Derive(tt.path...)
+		if got := hex.EncodeToString(key.ChainCode); got != tt.chainCode {
+			t.Errorf("chain code of %x = %s, want %s", tt.path, got, tt.chainCode)
+		}
+		if got := hex.EncodeToString(key.Key.FillBytes(make([]byte, 32))); got != tt.key {
+			t.Errorf("key of %x = %s, want %s", tt.path, got, tt.key)
+		}
+	}
+}
+
+func TestRestoreHDWallet(t *testing.T) {
+	aliceWallet := newWallet(t)
+	alice := string(aliceWallet.GetAddress())
+	bc := newTestChain(t, alice)
+	utxoSet := &core.UTXOSet{Blockchain: bc}
+
+	hd := wallet.Wallets{Wallets: map[string]*wallet.Wallet{}}
+	if err := hd.SetMnemonic(zeroMnemonic); err != nil {
+		t.Fatal(err)
+	}
+	if err := hd.SetMnemonic(zeroMnemonic); !errors.Is(err, wallet.ErrHDSeedExists) {
+		t.Errorf("SetMnemonic() error = %v, want %v", err, wallet.ErrHDSeedExists)
+	}
+	var receive []string
+	for i := 0; i < 4; i++ {
+		address, err := hd.CreateWallet()
+		if err != nil {
+			t.Fatal(err)
+		}
+		receive = append(receive, address)
+	}
+	change := hd.NewAddress(wallet.ChangeChain)
+	if hd.NextIndex != [2]uint32{4, 1} {
+		t.Errorf("NextIndex = %v, want [4 1]", hd.NextIndex)
+	}
+
+	// the keys 0 and 3 of the receive chain get coins, then key 3 pays 2 to alice with its change to the change chain
+	mine(t, bc, newCoinbase(t, bc, alice, 0), send(t, bc, aliceWallet, receive[0], 1))
+	mine(t, bc, newCoinbase(t, bc, alice, 0), send(t, bc, aliceWallet, receive[3], 5))
+	key3, err := hd.GetWallet(receive[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := core.NewUTXOTransactionWithChange(&key3, newOutput(t, 2, alice).ScriptPubKey, 2, 0,
+		newOutput(t, 0, change).ScriptPubKey, utxoSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mine(t, bc, newCoinbase(t, bc, alice, 0), tx)
+	if got := balance(t, bc, change); got != 3 {
+		t.Errorf("change balance = %d, want 3", got)
+	}
+	// a key beyond the gap limit is not found
+	far := string(wallet.DeriveWallet(hd.Seed, wallet.ReceiveChain, 4+wallet.GapLimit).GetAddress())
+	mine(t, bc, newCoinbase(t, bc, alice, 0), send(t, bc, aliceWallet, far, 1))
+
+	owners, err := utxoSet.PubKeyHashes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := wallet.Wallets{Wallets: map[string]*wallet.Wallet{}}
+	if err = restored.SetMnemonic(zeroMnemonic); err != nil {
+		t.Fatal(err)
+	}
+	found, err := restored.Scan(func(pubKeyHash []byte) (bool, error) {
+		return owners[string(pubKeyHash)], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// receive key 3 has no coins left, only 0 and the change key are found
+	if found != 2 {
+		t.Errorf("Scan() found %d keys, want 2", found)
+	}
+	if restored.NextIndex != [2]uint32{1, 1} {
+		t.Errorf("NextIndex = %v, want [1 1]", restored.NextIndex)
+	}
+	for _, address := range []string{receive[0], change} {
+		if _, err = restored.GetWallet(address); err != nil {
+			t.Errorf("GetWallet(%s) error = %v", address, err)
+		}
+	}
+	if got, err := restored.CreateWallet(); err != nil || got != receive[1] {
+		t.Errorf("CreateWallet() = %s, %v, want %s", got, err, receive[1])
+	}
+}
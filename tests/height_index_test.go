@@ -0,0 +1,60 @@
+package tests
+
+import (
+	"blockchain-from-scratch/core"
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestBlockByHeight(t *testing.T) {
+	alice := string(newWallet(t).GetAddress())
+	bob := string(newWallet(t).GetAddress())
+	bc := newTestChain(t, alice)
+
+	genesis, err := bc.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mine(t, bc, newCoinbase(t, bc, alice, 0))
+
+	// genesis <- b1 <- b2 replaces genesis <- a1
+	b1 := mineOn(t, bc, &genesis, bob)
+	b2 := mineOn(t, bc, b1, bob)
+	for _, block := range []*core.Block{b1, b2} {
+		if err = bc.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := [][]byte{genesis.Hash, b1.Hash, b2.Hash}
+	for height, hash := range want {
+		block, err := bc.GetBlockByHeight(height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(block.Hash, hash) {
+			t.Errorf("GetBlockByHeight(%d) = %x, want %x", height, block.Hash, hash)
+		}
+	}
+	if _, err = bc.GetBlockByHeight(3); !errors.Is(err, core.ErrBlockNotFound) {
+		t.Errorf("GetBlockByHeight(3) error = %v, want %v", err, core.ErrBlockNotFound)
+	}
+
+	var visited [][]byte
+	err = bc.ForEachBlock(0, -1, func(block *core.Block) error {
+		visited = append(visited, block.Hash)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(visited) != len(want) {
+		t.Fatalf("ForEachBlock visited %d blocks, want %d", len(visited), len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(visited[i], want[i]) {
+			t.Errorf("ForEachBlock block %d = %x, want %x", i, visited[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package tests
+
+import (
+	"blockchain-from-scratch/core"
+	"blockchain-from-scratch/core/wallet"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func TestHTLC(t *testing.T) {
+	aliceWallet := newWallet(t)
+	alice := string(aliceWallet.GetAddress())
+	bobWallet := newWallet(t)
+	bob := string(bobWallet.GetAddress())
+	bc := newTestChain(t, alice)
+	utxoSet := &core.UTXOSet{Blockchain: bc}
+
+	secret := []byte("atomic swap secret")
+	hash := sha256.Sum256(secret)
+	htlc := core.HTLC{
+		SecretHash: hash[:],
+		Recipient:  wallet.HashPubKey(bobWallet.PublicKey),
+		Refund:     wallet.HashPubKey(aliceWallet.PublicKey),
+		LockTime:   4,
+	}
+	if got := htlc.Script().Class(); got != core.HTLCClass {
+		t.Fatalf("Class() = %s, want %s", got, core.HTLCClass)
+	}
+	parsed, err := core.ParseHTLCScript(htlc.Script())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.LockTime != htlc.LockTime || string(parsed.SecretHash) != string(htlc.SecretHash) {
+		t.Errorf("ParseHTLCScript() = %+v, want %+v", parsed, htlc)
+	}
+
+	// alice locks 3 in a contract for bob and 4 in another one, in block 1
+	claimed, err := core.NewUTXOTransactionToScript(aliceWallet, htlc.Script(), 3, 0, utxoSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mine(t, bc, newCoinbase(t, bc, alice, 0), claimed)
+	refunded, err := core.NewUTXOTransactionToScript(aliceWallet, htlc.Script(), 4, 0, utxoSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mine(t, bc, newCoinbase(t, bc, alice, 0), refunded)
+	claimedOut := core.OutPoint{Txid: claimed.ID, Index: 0}
+	refundedOut := core.OutPoint{Txid: refunded.ID, Index: 0}
+
+	// only bob can claim, with the secret
+	if _, err = core.NewHTLCClaimTx(bobWallet, claimedOut, []byte("wrong"), 0, utxoSet); !errors.Is(err, core.ErrBadSecret) {
+		t.Errorf("NewHTLCClaimTx() error = %v, want %v", err, core.ErrBadSecret)
+	}
+	if _, err = core.NewHTLCClaimTx(aliceWallet, claimedOut, secret, 0, utxoSet); !errors.Is(err, core.ErrNotHTLCParty) {
+		t.Errorf("NewHTLCClaimTx() error = %v, want %v", err, core.ErrNotHTLCParty)
+	}
+	claim, err := core.NewHTLCClaimTx(bobWallet, claimedOut, secret, 1, utxoSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mine(t, bc, newCoinbase(t, bc, alice, 1), claim)
+	if got := balance(t, bc, bob); got != 2 {
+		t.Errorf("bob balance = %d, want 2", got)
+	}
+	if got, ok := core.HTLCSecret(claim); !ok || string(got) != string(secret) {
+		t.Errorf("HTLCSecret() = %q, %t, want %q", got, ok, secret)
+	}
+
+	// alice can only take the other contract back from block 5
+	if _, err = core.NewHTLCRefundTx(bobWallet, refundedOut, 0, utxoSet); !errors.Is(err, core.ErrNotHTLCParty) {
+		t.Errorf("NewHTLCRefundTx() error = %v, want %v", err, core.ErrNotHTLCParty)
+	}
+	refund, err := core.NewHTLCRefundTx(aliceWallet, refundedOut, 0, utxoSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = bc.AcceptTransaction(refund); !errors.Is(err, core.ErrNonFinalTx) {
+		t.Errorf("AcceptTransaction() error = %v, want %v", err, core.ErrNonFinalTx)
+	}
+	mine(t, bc, newCoinbase(t, bc, alice, 0))
+	if err = bc.AcceptTransaction(refund); err != nil {
+		t.Fatal(err)
+	}
+	aliceBalance := balance(t, bc, alice)
+	mine(t, bc, newCoinbase(t, bc, bob, 0), refund)
+	if got := balance(t, bc, alice); got != aliceBalance+4 {
+		t.Errorf("alice balance = %d, want %d", got, aliceBalance+4)
+	}
+}
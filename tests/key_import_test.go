@@ -0,0 +1,91 @@
+package tests
+
+import (
+	"blockchain-from-scratch/core"
+	"blockchain-from-scratch/core/wallet"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestPrivateKeyEncoding(t *testing.T) {
+	w := newWallet(t)
+	key := w.EncodePrivateKey()
+	decoded, err := wallet.DecodePrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded.GetAddress()) != string(w.GetAddress()) || decoded.PrivateKey.D.Cmp(w.PrivateKey.D) != 0 {
+		t.Errorf("DecodePrivateKey() = %s, want %s", decoded.GetAddress(), w.GetAddress())
+	}
+
+	typo := []byte(key)
+	typo[10] ^= 1
+	if _, err = wallet.DecodePrivateKey(string(typo)); !errors.Is(err, wallet.ErrInvalidPrivateKey) {
+		t.Errorf("DecodePrivateKey() error = %v, want %v", err, wallet.ErrInvalidPrivateKey)
+	}
+	wallet.SetPrivateKeyVersion(core.TestNetParams.PrivateKeyVersion)
+	defer wallet.SetPrivateKeyVersion(core.MainNetParams.PrivateKeyVersion)
+	if _, err = wallet.DecodePrivateKey(key); !errors.Is(err, wallet.ErrInvalidPrivateKey) {
+		t.Errorf("DecodePrivateKey() of a main network key error = %v, want %v", err, wallet.ErrInvalidPrivateKey)
+	}
+}
+
+func TestImportKeys(t *testing.T) {
+	const nodeID = "import_test"
+	t.Cleanup(func() { os.Remove("wallet_" + nodeID + ".dat") })
+	aliceWallet := newWallet(t)
+	alice := string(aliceWallet.GetAddress())
+	bob := string(newWallet(t).GetAddress())
+	bc := newTestChain(t, alice)
+	utxoSet := &core.UTXOSet{Blockchain: bc}
+
+	// the public key of alice is watched first, its coins cannot be spent
+	wallets := &wallet.Wallets{Wallets: map[string]*wallet.Wallet{}}
+	if _, err := wallets.ImportPubKey([]byte{1, 2, 3, 4}); !errors.Is(err, wallet.ErrInvalidPublicKey) {
+		t.Errorf("ImportPubKey() error = %v, want %v", err, wallet.ErrInvalidPublicKey)
+	}
+	address, err := wallets.ImportPubKey(aliceWallet.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address != alice {
+		t.Errorf("ImportPubKey() = %s, want %s", address, alice)
+	}
+	if err = wallets.SaveToFile(nodeID); err != nil {
+		t.Fatal(err)
+	}
+	if wallets, err = wallet.NewWallets(nodeID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := wallets.WatchOnly[alice]; !ok {
+		t.Errorf("WatchOnly = %v, want %s", wallets.WatchOnly, alice)
+	}
+	if _, err = wallets.GetWallet(alice); !errors.Is(err, wallet.ErrWalletNotFound) {
+		t.Errorf("GetWallet() error = %v, want %v", err, wallet.ErrWalletNotFound)
+	}
+
+	// importing the private key exported by another node makes them spendable
+	imported, err := wallet.DecodePrivateKey(aliceWallet.EncodePrivateKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address, err = wallets.ImportWallet(imported); err != nil || address != alice {
+		t.Fatalf("ImportWallet() = %s, %v, want %s", address, err, alice)
+	}
+	if _, ok := wallets.WatchOnly[alice]; ok {
+		t.Errorf("imported address is still watch-only")
+	}
+	w, err := wallets.GetWallet(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := core.NewUTXOTransaction(&w, bob, 3, 0, utxoSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mine(t, bc, newCoinbase(t, bc, alice, 0), tx)
+	if got := balance(t, bc, bob); got != 3 {
+		t.Errorf("bob balance = %d, want 3", got)
+	}
+}
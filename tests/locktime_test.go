@@ -0,0 +1,181 @@
+package tests
+
+import (
+	"blockchain-from-scratch/core"
+	"blockchain-from-scratch/core/wallet"
+	"errors"
+	"testing"
+)
+
+func TestIsFinal(t *testing.T) {
+	const now = int64(core.LockTimeThreshold + 1000)
+	tests := []struct {
+		lockTime uint32
+		sequence uint32
+		height   int
+		final    bool
+	}{
+		{0, 0, 1, true},
+		{5, 0, 5, false},
+		{5, 0, 6, true},
+		{5, core.SequenceFinal, 1, true},
+		{core.LockTimeThreshold + 1000, 0, 1000000, false},
+		{core.LockTimeThreshold + 999, 0, 1, true},
+	}
+	for _, tt := range tests {
+		tx := core.Transaction{Vin: []core.TxInput{{Sequence: tt.sequence}}, LockTime: tt.lockTime}
+		if got := tx.IsFinal(tt.height, now); got != tt.final {
+			t.Errorf("IsFinal(%d) with lock time %d and sequence %x = %t, want %t",
+				tt.height, tt.lockTime, tt.sequence, got, tt.final)
+		}
+	}
+}
+
+func TestTransactionLockTime(t *testing.T) {
+	aliceWallet := newWallet(t)
+	alice := string(aliceWallet.GetAddress())
+	bob := string(newWallet(t).GetAddress())
+	bc := newTestChain(t, alice)
+
+	genesis, err := bc.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the transaction cannot be mined before block 3
+	tx := &core.Transaction{
+		Vin:      []core.TxInput{{Txid: genesis.Transactions[0].ID, Vout: 0, Sequence: core.SequenceFinal - 1}},
+		VOut:     []core.TxOutput{*newOutput(t, 10, bob)},
+		LockTime: 2,
+	}
+	tx.ID = txID(t, tx)
+	if err = bc.SignTransaction(tx, aliceWallet.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = bc.AcceptTransaction(tx); !errors.Is(err, core.ErrNonFinalTx) {
+		t.Errorf("AcceptTransaction() error = %v, want %v", err, core.ErrNonFinalTx)
+	}
+	if bc.Mempool.Has(tx.ID) {
+		t.Errorf("mempool holds a non final transaction")
+	}
+	if _, err = bc.MineBlock([]*core.Transaction{newCoinbase(t, bc, alice, 0), tx}); !errors.Is(err, core.ErrNonFinalTx) {
+		t.Errorf("MineBlock() error = %v, want %v", err, core.ErrNonFinalTx)
+	}
+
+	mine(t, bc, newCoinbase(t, bc, alice, 0))
+	mine(t, bc, newCoinbase(t, bc, alice, 0))
+	if err = bc.AcceptTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	mine(t, bc, newCoinbase(t, bc, alice, 0), tx)
+	if got := balance(t, bc, bob); got != 10 {
+		t.Errorf("bob balance = %d, want 10", got)
+	}
+}
+
+func TestTimeLockedOutputs(t *testing.T) {
+	aliceWallet := newWallet(t)
+	alice := string(aliceWallet.GetAddress())
+	bobWallet := newWallet(t)
+	bobHash := wallet.HashPubKey(bobWallet.PublicKey)
+	bc := newTestChain(t, alice)
+	utxoSet := &core.UTXOSet{Blockchain: bc}
+
+	// bob gets 4 he can spend from block 4 on and 6 he can spend 3 blocks after they are mined, in block 1
+	for _, lock := range []struct {
+		script core.Script
+		amount int
+	}{
+		{core.LockTimeScript(3, bobHash), 4},
+		{core.SequenceLockScript(3, bobHash), 6},
+	} {
+		tx, err := core.NewUTXOTransactionToScript(aliceWallet, lock.script, lock.amount, 0, utxoSet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = bc.AcceptTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+		mine(t, bc, newCoinbase(t, bc, alice, 0), tx)
+	}
+	if got, want := core.LockTimeScript(3, bobHash).Class(), core.LockTimeClass; got != want {
+		t.Errorf("Class() = %s, want %s", got, want)
+	}
+
+	// at height 2, the outputs are locked
+	want := core.Balance{Confirmed: 10, Locked: 10}
+	if got := getBalance(t, *utxoSet, bobHash); got != want {
+		t.Fatalf("GetBalance() = %+v, want %+v", got, want)
+	}
+	if _, err := core.NewUTXOTransaction(bobWallet, alice, 1, 0, utxoSet); !errors.Is(err, core.ErrInsufficientFunds) {
+		t.Errorf("NewUTXOTransaction() error = %v, want %v", err, core.ErrInsufficientFunds)
+	}
+
+	// the output of block 1 locked until height 3 can be spent in block 4
+	mine(t, bc, newCoinbase(t, bc, alice, 0))
+	want = core.Balance{Confirmed: 10, Locked: 6, Spendable: 4}
+	if got := getBalance(t, *utxoSet, bobHash); got != want {
+		t.Fatalf("GetBalance() = %+v, want %+v", got, want)
+	}
+	tx := send(t, bc, bobWallet, alice, 4)
+	if tx.LockTime != 3 {
+		t.Errorf("LockTime = %d, want 3", tx.LockTime)
+	}
+
+	// the lock time of the transaction must satisfy OP_CHECKLOCKTIMEVERIFY
+	early := tx.TrimmedCopy()
+	early.LockTime = 2
+	early.ID = txID(t, &early)
+	if err := bc.SignTransaction(&early, bobWallet.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.VerifyTransaction(&early); !errors.Is(err, core.ErrScriptFailed) {
+		t.Errorf("VerifyTransaction() error = %v, want %v", err, core.ErrScriptFailed)
+	}
+	mine(t, bc, newCoinbase(t, bc, alice, 0), tx)
+
+	// the output of block 2 locked for 3 blocks can be spent in block 5
+	want = core.Balance{Confirmed: 6, Spendable: 6}
+	if got := getBalance(t, *utxoSet, bobHash); got != want {
+		t.Fatalf("GetBalance() = %+v, want %+v", got, want)
+	}
+	tx = send(t, bc, bobWallet, alice, 6)
+	if tx.Vin[0].Sequence != 3 {
+		t.Errorf("Sequence = %d, want 3", tx.Vin[0].Sequence)
+	}
+	mine(t, bc, newCoinbase(t, bc, alice, 0), tx)
+	if got := balance(t, bc, string(bobWallet.GetAddress())); got != 0 {
+		t.Errorf("bob balance = %d, want 0", got)
+	}
+}
+
+func TestSequenceLock(t *testing.T) {
+	aliceWallet := newWallet(t)
+	alice := string(aliceWallet.GetAddress())
+	bob := string(newWallet(t).GetAddress())
+	bc := newTestChain(t, alice)
+
+	// the genesis reward can only be spent 3 blocks after the genesis block
+	genesis, err := bc.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := &core.Transaction{
+		Vin:  []core.TxInput{{Txid: genesis.Transactions[0].ID, Vout: 0, Sequence: 3}},
+		VOut: []core.TxOutput{*newOutput(t, 10, bob)},
+	}
+	tx.ID = txID(t, tx)
+	if err = bc.SignTransaction(tx, aliceWallet.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+
+	mine(t, bc, newCoinbase(t, bc, alice, 0))
+	if err = bc.AcceptTransaction(tx); !errors.Is(err, core.ErrSequenceLocked) {
+		t.Errorf("AcceptTransaction() error = %v, want %v", err, core.ErrSequenceLocked)
+	}
+	if err = bc.VerifyTransaction(tx); !errors.Is(err, core.ErrSequenceLocked) {
+		t.Errorf("VerifyTransaction() error = %v, want %v", err, core.ErrSequenceLocked)
+	}
+	mine(t, bc, newCoinbase(t, bc, alice, 0))
+	mine(t, bc, newCoinbase(t, bc, alice, 0), tx)
+}
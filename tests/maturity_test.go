@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"blockchain-from-scratch/core"
+	"blockchain-from-scratch/core/wallet"
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestCoinbaseMaturity(t *testing.T) {
+	aliceWallet := newWallet(t)
+	alice := string(aliceWallet.GetAddress())
+	bob := string(newWallet(t).GetAddress())
+
+	params := testParams
+	params.CoinbaseMaturity = 2
+	bc, err := core.CreateBlockchainWithStore(core.NewMemoryStore(), &params, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+	utxoSet := core.UTXOSet{Blockchain: bc}
+	pubKeyHash := wallet.HashPubKey(aliceWallet.PublicKey)
+
+	// the genesis reward cannot be spent in block 1
+	if _, err = core.NewUTXOTransaction(aliceWallet, bob, 1, 0, &utxoSet); !errors.Is(err, core.ErrInsufficientFunds) {
+		t.Fatalf("NewUTXOTransaction() error = %v, want %v", err, core.ErrInsufficientFunds)
+	}
+	genesis, err := bc.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	early := &core.Transaction{
+		Vin:  []core.TxInput{{Txid: genesis.Transactions[0].ID, Vout: 0}},
+		VOut: []core.TxOutput{*newOutput(t, 10, bob)},
+	}
+	early.ID = txID(t, early)
+	if err = bc.SignTransaction(early, aliceWallet.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	if err = bc.VerifyTransaction(early); !errors.Is(err, core.ErrImmatureSpend) {
+		t.Fatalf("VerifyTransaction() error = %v, want %v", err, core.ErrImmatureSpend)
+	}
+
+	b1 := mine(t, bc, newCoinbase(t, bc, alice, 0))
+	want := core.Balance{Confirmed: 20, Immature: 10, Spendable: 10}
+	if got := getBalance(t, utxoSet, pubKeyHash); got != want {
+		t.Fatalf("GetBalance() = %+v, want %+v", got, want)
+	}
+
+	// block 2 can spend the genesis reward but not the reward of block 1
+	if err = bc.VerifyTransaction(early); err != nil {
+		t.Fatal(err)
+	}
+	tx := send(t, bc, aliceWallet, bob, 10)
+	if len(tx.Vin) != 1 || bytes.Equal(tx.Vin[0].Txid, b1.Transactions[0].ID) {
+		t.Fatalf("NewUTXOTransaction() selected an immature output")
+	}
+	// the reward of block 1 is now mature, the one of block 2 is not
+	mine(t, bc, newCoinbase(t, bc, alice, 0), tx)
+	if got := getBalance(t, utxoSet, pubKeyHash); got != want {
+		t.Errorf("GetBalance() = %+v, want %+v", got, want)
+	}
+}
+
+func getBalance(t *testing.T, utxoSet core.UTXOSet, pubKeyHash []byte) core.Balance {
+	balance, err := utxoSet.GetBalance(pubKeyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return balance
+}
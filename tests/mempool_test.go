@@ -0,0 +1,96 @@
+package tests
+
+import (
+	"blockchain-from-scratch/core"
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestSelectTransactions(t *testing.T) {
+	aliceWallet := newWallet(t)
+	alice := string(aliceWallet.GetAddress())
+	bobWallet := newWallet(t)
+	bob := string(bobWallet.GetAddress())
+	carol := string(newWallet(t).GetAddress())
+	bc := newTestChain(t, alice)
+	utxoSet := &core.UTXOSet{Blockchain: bc}
+
+	// both spend the genesis reward of alice, the overspend is invalid
+	toBob, err := core.NewUTXOTransaction(aliceWallet, bob, 3, 1, utxoSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	toCarol, err := core.NewUTXOTransaction(aliceWallet, carol, 4, 2, utxoSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	overspend, err := core.NewUTXOTransaction(aliceWallet, carol, 1, 0, utxoSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	overspend.VOut[0].Value = 100
+	for i := range overspend.Vin {
+		overspend.Vin[i].ScriptSig = nil
+	}
+	overspend.ID = txID(t, overspend)
+	if err = bc.SignTransaction(overspend, aliceWallet.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	for _, tx := range []*core.Transaction{toBob, toCarol, overspend} {
+		if err = bc.AcceptTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	txs, fees, err := bc.SelectTransactions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 1 || !bytes.Equal(txs[0].ID, toBob.ID) && !bytes.Equal(txs[0].ID, toCarol.ID) {
+		t.Fatalf("SelectTransactions() = %d transactions, want one of the conflicting spends", len(txs))
+	}
+	picked, dropped, wantFee := toBob, toCarol, 1
+	if bytes.Equal(txs[0].ID, toCarol.ID) {
+		picked, dropped, wantFee = toCarol, toBob, 2
+	}
+	if fees != wantFee {
+		t.Errorf("SelectTransactions() fees = %d, want %d", fees, wantFee)
+	}
+	if bc.Mempool.Len() != 1 || !bc.Mempool.Has(picked.ID) || bc.Mempool.Has(dropped.ID) || bc.Mempool.Has(overspend.ID) {
+		t.Errorf("mempool holds %d transactions, want only the picked one", bc.Mempool.Len())
+	}
+	mine(t, bc, append([]*core.Transaction{newCoinbase(t, bc, alice, fees)}, txs...)...)
+	if bc.Mempool.Len() != 0 {
+		t.Errorf("mempool holds %d transactions after mining, want 0", bc.Mempool.Len())
+	}
+
+	// a chain of unconfirmed transactions is picked parent first
+	carolBalance := balance(t, bc, carol)
+	parent := send(t, bc, aliceWallet, bob, 2)
+	child := &core.Transaction{
+		Vin:  []core.TxInput{{Txid: parent.ID, Vout: 0, Sequence: core.SequenceFinal}},
+		VOut: []core.TxOutput{*newOutput(t, 1, carol)},
+	}
+	child.ID = txID(t, child)
+	err = child.Sign(bobWallet.PrivateKey, map[string]core.Transaction{hex.EncodeToString(parent.ID): *parent})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tx := range []*core.Transaction{child, parent} {
+		if err = bc.AcceptTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	txs, fees, err = bc.SelectTransactions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 2 || !bytes.Equal(txs[0].ID, parent.ID) || !bytes.Equal(txs[1].ID, child.ID) || fees != 1 {
+		t.Fatalf("SelectTransactions() = %d transactions with fees %d, want the parent then the child with fees 1", len(txs), fees)
+	}
+	mine(t, bc, append([]*core.Transaction{newCoinbase(t, bc, alice, fees)}, txs...)...)
+	if got := balance(t, bc, carol); got != carolBalance+1 {
+		t.Errorf("carol balance = %d, want %d", got, carolBalance+1)
+	}
+}
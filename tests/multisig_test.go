@@ -0,0 +1,87 @@
+package tests
+
+import (
+	"blockchain-from-scratch/core"
+	"blockchain-from-scratch/core/wallet"
+	"errors"
+	"testing"
+)
+
+func TestMultiSigSpend(t *testing.T) {
+	aliceWallet := newWallet(t)
+	alice := string(aliceWallet.GetAddress())
+	bob := string(newWallet(t).GetAddress())
+	bc := newTestChain(t, alice)
+
+	// the key of the first operator is pushed on 64 bytes even though its X has a leading zero byte
+	operators := []*wallet.Wallet{shortCoordinateWallet(t), newWallet(t), newWallet(t)}
+	var pubKeys [][]byte
+	for _, w := range operators {
+		pubKeys = append(pubKeys, w.PublicKey)
+	}
+	if _, err := core.MultiSigScript(4, pubKeys); !errors.Is(err, core.ErrBadScript) {
+		t.Errorf("MultiSigScript(4 of 3) error = %v, want %v", err, core.ErrBadScript)
+	}
+	if _, err := core.MultiSigScript(1, [][]byte{pubKeys[0][1:]}); !errors.Is(err, core.ErrBadScript) {
+		t.Errorf("MultiSigScript(63 bytes key) error = %v, want %v", err, core.ErrBadScript)
+	}
+	redeemScript, err := core.MultiSigScript(2, pubKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := redeemScript.Class(); got != core.MultiSigClass {
+		t.Errorf("Class() = %s, want %s", got, core.MultiSigClass)
+	}
+
+	// alice funds the treasury through its script address
+	treasury := wallet.ScriptAddress(redeemScript.Hash())
+	hash, isScript, err := wallet.DecodeAddress(treasury)
+	if err != nil || !isScript {
+		t.Fatalf("DecodeAddress(%s) = %x, %v, %v", treasury, hash, isScript, err)
+	}
+	mine(t, bc, newCoinbase(t, bc, alice, 0), send(t, bc, aliceWallet, treasury, 8))
+	utxoSet := &core.UTXOSet{Blockchain: bc}
+	if got, err := utxoSet.GetScriptBalance(hash); err != nil || got.Spendable != 8 {
+		t.Fatalf("GetScriptBalance() = %+v, %v, want 8 spendable", got, err)
+	}
+
+	m, err := core.NewMultiSigTx(redeemScript, bob, 5, 1, utxoSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Sign(aliceWallet.PrivateKey); !errors.Is(err, core.ErrNotCosigner) {
+		t.Errorf("Sign() error = %v, want %v", err, core.ErrNotCosigner)
+	}
+	if err = m.Sign(operators[2].PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Finalize(); !errors.Is(err, core.ErrNotEnoughSignatures) {
+		t.Errorf("Finalize() error = %v, want %v", err, core.ErrNotEnoughSignatures)
+	}
+	if err = m.Sign(operators[0].PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	if count, required, err := m.SignatureCount(); err != nil || count != 2 || required != 2 {
+		t.Errorf("SignatureCount() = %d, %d, %v, want 2, 2", count, required, err)
+	}
+
+	tx, err := m.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fee, err := bc.TxFee(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fee != 1 {
+		t.Errorf("TxFee() = %d, want 1", fee)
+	}
+	mine(t, bc, newCoinbase(t, bc, alice, fee), tx)
+
+	if got := balance(t, bc, bob); got != 5 {
+		t.Errorf("bob balance = %d, want 5", got)
+	}
+	if got, err := utxoSet.GetScriptBalance(hash); err != nil || got.Spendable != 2 {
+		t.Errorf("GetScriptBalance() = %+v, %v, want 2 spendable change", got, err)
+	}
+}
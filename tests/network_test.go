@@ -0,0 +1,49 @@
+package tests
+
+import (
+	"blockchain-from-scratch/core"
+	"blockchain-from-scratch/core/wallet"
+	"errors"
+	"testing"
+)
+
+func TestNetworks(t *testing.T) {
+	for _, name := range []string{"main", "test", "regtest", "custom"} {
+		params, err := core.ParamsByName(name)
+		if err != nil || params.Name != name {
+			t.Errorf("ParamsByName(%q) = %v, %v", name, params, err)
+		}
+	}
+	if _, err := core.ParamsByName("unknown"); !errors.Is(err, core.ErrUnknownNetwork) {
+		t.Errorf("ParamsByName() error = %v, want %v", err, core.ErrUnknownNetwork)
+	}
+	if seeds := core.TestNetParams.SeedAddresses(); len(seeds) != 1 || seeds[0] != "localhost:13000" {
+		t.Errorf("SeedAddresses() = %v, want the seed on the default port", seeds)
+	}
+
+	// a chain cannot be opened with the parameters of another network
+	store := core.NewMemoryStore()
+	alice := string(newWallet(t).GetAddress())
+	if _, err := core.CreateBlockchainWithStore(store, &core.CustomNetParams, alice); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := core.NewBlockchainWithStore(store, &core.TestNetParams); !errors.Is(err, core.ErrWrongNetwork) {
+		t.Errorf("NewBlockchainWithStore() error = %v, want %v", err, core.ErrWrongNetwork)
+	}
+	if _, err := core.NewBlockchainWithStore(store, &core.CustomNetParams); err != nil {
+		t.Errorf("NewBlockchainWithStore() error = %v", err)
+	}
+
+	// addresses carry the version byte of their network
+	wallet.SetAddressVersion(core.RegTestParams.AddressVersion)
+	defer wallet.SetAddressVersion(core.MainNetParams.AddressVersion)
+	w := newWallet(t)
+	regtestAddress := string(w.GetAddress())
+	if !wallet.ValidateAddress(regtestAddress) {
+		t.Errorf("ValidateAddress(%s) = false, want true", regtestAddress)
+	}
+	wallet.SetAddressVersion(core.MainNetParams.AddressVersion)
+	if wallet.ValidateAddress(regtestAddress) {
+		t.Errorf("ValidateAddress(%s) = true on the main network, want false", regtestAddress)
+	}
+}
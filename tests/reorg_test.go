@@ -0,0 +1,289 @@
+package tests
+
+import (
+	"blockchain-from-scratch/core"
+	"blockchain-from-scratch/core/wallet"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// testParams are the custom network parameters without coinbase maturity, so rewards can be spent right away.
+// The genesis block of a custom network pays the address the chain is created with.
+var testParams = func() core.ChainParams {
+	params := core.CustomNetParams
+	params.CoinbaseMaturity = 0
+	return params
+}()
+
+// newTestChain creates a fresh blockchain kept in memory
+func newTestChain(t *testing.T, address string) *core.Blockchain {
+	params := testParams
+	bc, err := core.CreateBlockchainWithStore(core.NewMemoryStore(), &params, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bc.Close() })
+	return bc
+}
+
+func tipHash(t *testing.T, bc *core.Blockchain) []byte {
+	hashes, err := bc.GetBlockHashes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hashes[0]
+}
+
+func bestHeight(t *testing.T, bc *core.Blockchain) int {
+	height, err := bc.GetBestHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return height
+}
+
+// send creates a signed transaction spending the outputs of the wallet
+func send(t *testing.T, bc *core.Blockchain, from *wallet.Wallet, to string, amount int) *core.Transaction {
+	tx, err := core.NewUTXOTransaction(from, to, amount, 0, &core.UTXOSet{Blockchain: bc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+// newCoinbase creates the coinbase of the next block mined on the tip
+func newCoinbase(t *testing.T, bc *core.Blockchain, to string, fees int) *core.Transaction {
+	tx, err := bc.NewCoinbaseTx(to, fees)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func mine(t *testing.T, bc *core.Blockchain, txs ...*core.Transaction) *core.Block {
+	block, err := bc.MineBlock(txs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func mineOn(t *testing.T, bc *core.Blockchain, parent *core.Block, address string) *core.Block {
+	bits, err := bc.CalcNextRequiredBits(&parent.BlockHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase := coinbaseTx(t, address, "", bc.Params.CalcBlockSubsidy(parent.Height+1))
+	return newBlock(t, []*core.Transaction{coinbase}, parent.Hash, parent.Height+1, bits)
+}
+
+func newWallet(t *testing.T) *wallet.Wallet {
+	w, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+// coinbaseTx creates a coinbase paying reward, unlike newCoinbase it does not depend on the tip
+func coinbaseTx(t *testing.T, to, data string, reward int) *core.Transaction {
+	tx, err := core.NewCoinbaseTx(to, data, reward)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func newBlock(t *testing.T, txs []*core.Transaction, prevBlockHash []byte, height int, bits uint32) *core.Block {
+	block, err := core.NewBlock(txs, prevBlockHash, height, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func newOutput(t *testing.T, value int, address string) *core.TxOutput {
+	out, err := core.NewTXOutput(value, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// txID returns the hash of a transaction built by hand, to set as its ID
+func txID(t *testing.T, tx *core.Transaction) []byte {
+	hash, err := tx.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func balance(t *testing.T, bc *core.Blockchain, address string) int {
+	utxoSet := core.UTXOSet{Blockchain: bc}
+	pubKeyHash := wallet.Base58Decode([]byte(address))
+	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
+
+	utxos, err := utxoSet.FindUTXO(pubKeyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, out := range utxos {
+		total += out.Value
+	}
+	return total
+}
+
+func TestReorganizeToMostWork(t *testing.T) {
+	alice := string(newWallet(t).GetAddress())
+	bob := string(newWallet(t).GetAddress())
+	bc := newTestChain(t, alice)
+
+	genesis, err := bc.GetBlock(tipHash(t, bc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// main chain: genesis <- a1
+	a1 := mineOn(t, bc, &genesis, alice)
+	if err = bc.AddBlock(a1); err != nil {
+		t.Fatal(err)
+	}
+	aliceBalance := balance(t, bc, alice)
+
+	// side branch: genesis <- b1 has the same work as a1, so the tip does not move
+	b1 := mineOn(t, bc, &genesis, bob)
+	if err = bc.AddBlock(b1); err != nil {
+		t.Fatal(err)
+	}
+	if tip := tipHash(t, bc); !bytes.Equal(tip, a1.Hash) {
+		t.Fatalf("tip = %x, want %x", tip, a1.Hash)
+	}
+
+	// genesis <- b1 <- b2 has more work, the chain reorganizes
+	b2 := mineOn(t, bc, b1, bob)
+	if err = bc.AddBlock(b2); err != nil {
+		t.Fatal(err)
+	}
+	if tip := tipHash(t, bc); !bytes.Equal(tip, b2.Hash) {
+		t.Fatalf("tip = %x, want %x", tip, b2.Hash)
+	}
+	if height := bestHeight(t, bc); height != 2 {
+		t.Fatalf("best height = %d, want 2", height)
+	}
+
+	if got := balance(t, bc, alice); got >= aliceBalance {
+		t.Errorf("alice balance = %d after reorg, want the a1 reward removed", got)
+	}
+	if got := balance(t, bc, bob); got == 0 {
+		t.Errorf("bob balance = 0 after reorg, want the b1 and b2 rewards")
+	}
+}
+
+func TestReorganizeInBlockSpend(t *testing.T) {
+	aliceWallet := newWallet(t)
+	alice := string(aliceWallet.GetAddress())
+	bobWallet := newWallet(t)
+	bob := string(bobWallet.GetAddress())
+	carol := string(newWallet(t).GetAddress())
+	bc := newTestChain(t, alice)
+
+	genesis, err := bc.GetBlock(tipHash(t, bc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliceBalance := balance(t, bc, alice)
+
+	// a1 holds a transaction to bob and the one of bob spending it
+	toBob := send(t, bc, aliceWallet, bob, 3)
+	toCarol := &core.Transaction{
+		Vin:  []core.TxInput{{Txid: toBob.ID, Vout: 0, Sequence: core.SequenceFinal}},
+		VOut: []core.TxOutput{*newOutput(t, 3, carol)},
+	}
+	toCarol.ID = txID(t, toCarol)
+	err = toCarol.Sign(bobWallet.PrivateKey, map[string]core.Transaction{hex.EncodeToString(toBob.ID): *toBob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mine(t, bc, newCoinbase(t, bc, alice, 0), toBob, toCarol)
+
+	// genesis <- b1 <- b2 disconnects a1
+	b1 := mineOn(t, bc, &genesis, carol)
+	b2 := mineOn(t, bc, b1, carol)
+	for _, block := range []*core.Block{b1, b2} {
+		if err = bc.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tip := tipHash(t, bc); !bytes.Equal(tip, b2.Hash) {
+		t.Fatalf("tip = %x, want %x", tip, b2.Hash)
+	}
+
+	// the output created and spent in a1 is not brought back
+	utxoSet := core.UTXOSet{Blockchain: bc}
+	outPoint := core.OutPoint{Txid: toBob.ID, Index: 0}
+	if _, err = utxoSet.GetUTXO(outPoint); !errors.Is(err, core.ErrUTXONotFound) {
+		t.Errorf("GetUTXO(%s) error = %v, want %v", outPoint, err, core.ErrUTXONotFound)
+	}
+	if got := balance(t, bc, bob); got != 0 {
+		t.Errorf("bob balance = %d after reorg, want 0", got)
+	}
+	if got := balance(t, bc, alice); got != aliceBalance {
+		t.Errorf("alice balance = %d after reorg, want %d", got, aliceBalance)
+	}
+}
+
+func TestAddBlockRejectsOrphan(t *testing.T) {
+	alice := string(newWallet(t).GetAddress())
+	bc := newTestChain(t, alice)
+
+	orphan := newBlock(t, []*core.Transaction{coinbaseTx(t, alice, "", 10)}, []byte("unknown parent"), 1, 0x20010000)
+	if err := bc.AddBlock(orphan); err == nil {
+		t.Fatal("AddBlock accepted a block whose parent is unknown")
+	}
+}
+
+func TestHeadersBeforeBodies(t *testing.T) {
+	alice := string(newWallet(t).GetAddress())
+	bc := newTestChain(t, alice)
+
+	genesis, err := bc.GetBlock(tipHash(t, bc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b1 := mineOn(t, bc, &genesis, alice)
+	b2 := mineOn(t, bc, b1, alice)
+
+	// a header whose parent header is unknown is rejected
+	if err = bc.AddHeader(&b2.BlockHeader, b2.Hash); !errors.Is(err, core.ErrOrphanBlock) {
+		t.Fatalf("AddHeader() error = %v, want %v", err, core.ErrOrphanBlock)
+	}
+
+	// a header that does not hash to the announced hash is rejected
+	tampered := b1.BlockHeader
+	tampered.Nonce++
+	if err = bc.AddHeader(&tampered, b1.Hash); !errors.Is(err, core.ErrBadBlockHash) {
+		t.Fatalf("AddHeader() error = %v, want %v", err, core.ErrBadBlockHash)
+	}
+
+	for _, block := range []*core.Block{b1, b2} {
+		if err = bc.AddHeader(&block.BlockHeader, block.Hash); err != nil {
+			t.Fatal(err)
+		}
+		if found, err := bc.HasBlock(block.Hash); err != nil || found {
+			t.Fatalf("block %x has a body before it was downloaded", block.Hash)
+		}
+	}
+
+	for _, block := range []*core.Block{b1, b2} {
+		if err = bc.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if height := bestHeight(t, bc); height != 2 {
+		t.Fatalf("best height = %d, want 2", height)
+	}
+}
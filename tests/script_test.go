@@ -0,0 +1,162 @@
+package tests
+
+import (
+	"blockchain-from-scratch/core"
+	"blockchain-from-scratch/core/wallet"
+	"errors"
+	"testing"
+)
+
+func TestScriptTemplates(t *testing.T) {
+	w := newWallet(t)
+	pubKeyHash := wallet.HashPubKey(w.PublicKey)
+
+	tests := []struct {
+		script     core.Script
+		class      core.ScriptClass
+		pubKeyHash []byte
+	}{
+		{core.PayToPubKeyHashScript(pubKeyHash), core.PubKeyHashClass, pubKeyHash},
+		{core.PayToPubKeyScript(w.PublicKey), core.PubKeyClass, pubKeyHash},
+		{core.NullDataScript([]byte("hello")), core.NullDataClass, nil},
+		{core.NewScriptBuilder().AddOp(core.OpDup).Script(), core.NonStandardClass, nil},
+		{core.Script{0x05, 0x01}, core.NonStandardClass, nil},
+	}
+	for _, tt := range tests {
+		if got := tt.script.Class(); got != tt.class {
+			t.Errorf("%s Class() = %s, want %s", tt.script, got, tt.class)
+		}
+		if got := tt.script.PubKeyHash(); string(got) != string(tt.pubKeyHash) {
+			t.Errorf("%s PubKeyHash() = %x, want %x", tt.script, got, tt.pubKeyHash)
+		}
+	}
+
+	if got, want := core.NullDataScript([]byte("hi")).String(), "OP_RETURN 6869"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestScriptSpend(t *testing.T) {
+	aliceWallet := newWallet(t)
+	alice := string(aliceWallet.GetAddress())
+	bobWallet := newWallet(t)
+	bc := newTestChain(t, alice)
+
+	// alice pays 6 to the public key of bob and burns 4 in a data output
+	genesis, err := bc.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	toBob := &core.Transaction{
+		Vin: []core.TxInput{{Txid: genesis.Transactions[0].ID, Vout: 0}},
+		VOut: []core.TxOutput{
+			{Value: 6, ScriptPubKey: core.PayToPubKeyScript(bobWallet.PublicKey)},
+			{Value: 4, ScriptPubKey: core.NullDataScript([]byte("burn"))},
+		},
+	}
+	toBob.ID = txID(t, toBob)
+	if err = bc.SignTransaction(toBob, aliceWallet.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	mine(t, bc, newCoinbase(t, bc, alice, 0), toBob)
+	if got := balance(t, bc, string(bobWallet.GetAddress())); got != 6 {
+		t.Fatalf("bob balance = %d, want 6", got)
+	}
+
+	spend := func(vout int, key *wallet.Wallet) *core.Transaction {
+		tx := &core.Transaction{
+			Vin:  []core.TxInput{{Txid: toBob.ID, Vout: vout}},
+			VOut: []core.TxOutput{*newOutput(t, 1, alice)},
+		}
+		tx.ID = txID(t, tx)
+		if err := bc.SignTransaction(tx, key.PrivateKey); err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+
+	// only bob can unlock his output
+	if err = bc.VerifyTransaction(spend(0, aliceWallet)); !errors.Is(err, core.ErrScriptFailed) {
+		t.Errorf("VerifyTransaction() error = %v, want %v", err, core.ErrScriptFailed)
+	}
+	// the signature does not cover the new output value
+	tampered := spend(0, bobWallet)
+	scriptSig := tampered.Vin[0].ScriptSig
+	tampered.Vin[0].ScriptSig = nil
+	tampered.VOut[0].Value = 2
+	tampered.ID = txID(t, tampered)
+	tampered.Vin[0].ScriptSig = scriptSig
+	if err = bc.VerifyTransaction(tampered); !errors.Is(err, core.ErrScriptFailed) {
+		t.Errorf("VerifyTransaction() error = %v, want %v", err, core.ErrScriptFailed)
+	}
+	notPushOnly := spend(0, bobWallet)
+	notPushOnly.Vin[0].ScriptSig = append(notPushOnly.Vin[0].ScriptSig, core.OpDup)
+	if err = bc.VerifyTransaction(notPushOnly); !errors.Is(err, core.ErrBadScript) {
+		t.Errorf("VerifyTransaction() error = %v, want %v", err, core.ErrBadScript)
+	}
+
+	// a data output can never be spent, it is not even in the chainstate
+	burnt := &core.Transaction{
+		Vin:  []core.TxInput{{Txid: toBob.ID, Vout: 1}},
+		VOut: []core.TxOutput{*newOutput(t, 1, alice)},
+	}
+	burnt.ID = txID(t, burnt)
+	if err = bc.VerifyTransaction(burnt); !errors.Is(err, core.ErrMissingTxOut) {
+		t.Errorf("VerifyTransaction() error = %v, want %v", err, core.ErrMissingTxOut)
+	}
+
+	mine(t, bc, newCoinbase(t, bc, alice, 5), spend(0, bobWallet))
+	if got := balance(t, bc, string(bobWallet.GetAddress())); got != 0 {
+		t.Errorf("bob balance = %d, want 0", got)
+	}
+}
+
+// shortCoordinateWallet returns a key whose X has a leading zero byte, about 1 key in 256
+func shortCoordinateWallet(t *testing.T) *wallet.Wallet {
+	w := newWallet(t)
+	for w.PrivateKey.X.BitLen() > 248 {
+		w = newWallet(t)
+	}
+	return w
+}
+
+// TestShortCoordinateKey spends with a key whose X has a leading zero byte, the unlocking script
+// must push it on 64 bytes like the address hashes it
+func TestShortCoordinateKey(t *testing.T) {
+	aliceWallet := shortCoordinateWallet(t)
+	alice := string(aliceWallet.GetAddress())
+	bob := string(newWallet(t).GetAddress())
+	bc := newTestChain(t, alice)
+
+	tx := send(t, bc, aliceWallet, bob, 3)
+	if err := bc.VerifyTransaction(tx); err != nil {
+		t.Fatalf("VerifyTransaction() error = %v", err)
+	}
+	mine(t, bc, newCoinbase(t, bc, alice, 0), tx)
+	if got := balance(t, bc, bob); got != 3 {
+		t.Errorf("bob balance = %d, want 3", got)
+	}
+
+	// a key of 63 bytes is rejected, even by a pay-to-pubkey output locked to it
+	shortKey := append(aliceWallet.PrivateKey.X.Bytes(), aliceWallet.PrivateKey.Y.FillBytes(make([]byte, 32))...)
+	toShortKey := &core.Transaction{
+		Vin:  []core.TxInput{{Txid: tx.ID, Vout: 1}},
+		VOut: []core.TxOutput{{Value: 7, ScriptPubKey: core.PayToPubKeyScript(shortKey)}},
+	}
+	toShortKey.ID = txID(t, toShortKey)
+	if err := bc.SignTransaction(toShortKey, aliceWallet.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	mine(t, bc, newCoinbase(t, bc, alice, 0), toShortKey)
+	spend := &core.Transaction{
+		Vin:  []core.TxInput{{Txid: toShortKey.ID, Vout: 0}},
+		VOut: []core.TxOutput{*newOutput(t, 7, bob)},
+	}
+	spend.ID = txID(t, spend)
+	if err := bc.SignTransaction(spend, aliceWallet.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.VerifyTransaction(spend); !errors.Is(err, core.ErrScriptFailed) {
+		t.Errorf("VerifyTransaction() error = %v, want %v", err, core.ErrScriptFailed)
+	}
+}
@@ -7,23 +7,40 @@ import (
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"log"
+	"path/filepath"
 	"testing"
 )
 
 func TestInitChain(t *testing.T) {
-	chain := core.NewBlockChain("1")
+	store, err := core.OpenBoltStore(filepath.Join(t.TempDir(), "blockchain_1.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := core.InitBlockchainWithStore(store, &core.MainNetParams)
+	if err != nil {
+		store.Close()
+		t.Fatal(err)
+	}
+	defer chain.Close()
 
 	//chain.MineBlock("Send tx1")
 	//chain.MineBlock("Send tx2")
 
 	iterator := chain.Iterator()
 	for {
-		block := iterator.Next()
+		block, err := iterator.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
 		if len(block.PrevBlockHash) == 0 {
 			break
 		}
 		fmt.Printf("Prev. hash: %x\n", block.PrevBlockHash)
-		fmt.Printf("Data: %s\n", block.HashTransactions())
+		merkleRoot, err := block.HashTransactions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Printf("Data: %x\n", merkleRoot)
 		fmt.Printf("Hash: %x\n", block.Hash)
 		fmt.Println()
 	}
@@ -39,6 +56,6 @@ func Test(t *testing.T) {
 	if err != nil {
 		log.Panic(err)
 	}
-	logrus.Infof("%x", tx.Hash())
-	logrus.Infof("%x", tx.Hash())
+	logrus.Infof("%x", txID(t, &tx))
+	logrus.Infof("%x", txID(t, &tx))
 }
@@ -0,0 +1,76 @@
+package tests
+
+import (
+	"blockchain-from-scratch/core"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestStores(t *testing.T) {
+	boltStore, err := core.OpenBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer boltStore.Close()
+
+	stores := map[string]core.Store{
+		"bolt":   boltStore,
+		"memory": core.NewMemoryStore(),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			testStore(t, store)
+		})
+	}
+}
+
+func testStore(t *testing.T, store core.Store) {
+	err := store.Update(func(tx core.StoreTx) error {
+		b := tx.Bucket("test")
+		for _, k := range []string{"b", "c", "a"} {
+			if err := b.Put([]byte(k), []byte("value "+k)); err != nil {
+				return err
+			}
+		}
+		return b.Delete([]byte("c"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a failed update is rolled back
+	errAbort := errors.New("abort")
+	err = store.Update(func(tx core.StoreTx) error {
+		if err := tx.Bucket("test").Put([]byte("d"), []byte("value d")); err != nil {
+			return err
+		}
+		if err := tx.DeleteBucket("test"); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("Update() error = %v, want %v", err, errAbort)
+	}
+
+	var keys []string
+	err = store.View(func(tx core.StoreTx) error {
+		if v := tx.Bucket("test").Get([]byte("a")); string(v) != "value a" {
+			t.Errorf("Get(a) = %q, want %q", v, "value a")
+		}
+		if v := tx.Bucket("missing").Get([]byte("a")); v != nil {
+			t.Errorf("Get() from a missing bucket = %q, want nil", v)
+		}
+		return tx.Bucket("test").ForEach(func(k, v []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Errorf("keys = %v, want [a b]", keys)
+	}
+}
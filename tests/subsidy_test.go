@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"blockchain-from-scratch/core"
+	"errors"
+	"testing"
+)
+
+// halvingParams halve the subsidy every 2 blocks and run out of coins at height 4
+var halvingParams = func() core.ChainParams {
+	params := core.CustomNetParams
+	params.InitialSubsidy = 8
+	params.SubsidyHalvingInterval = 2
+	params.MaxSupply = 25
+	params.CoinbaseMaturity = 0
+	return params
+}()
+
+func TestCalcBlockSubsidy(t *testing.T) {
+	subsidies := []int{8, 8, 4, 4, 1, 0, 0}
+	supply := 0
+	for height, want := range subsidies {
+		if got := halvingParams.CalcBlockSubsidy(height); got != want {
+			t.Errorf("CalcBlockSubsidy(%d) = %d, want %d", height, got, want)
+		}
+		supply += want
+		if got := halvingParams.CalcSupply(height); got != supply {
+			t.Errorf("CalcSupply(%d) = %d, want %d", height, got, supply)
+		}
+	}
+
+	// the schedules of the presets never go past their max supply
+	for _, params := range []core.ChainParams{core.MainNetParams, core.TestNetParams, core.RegTestParams, core.CustomNetParams} {
+		last := params.SubsidyHalvingInterval * 64
+		if got := params.CalcSupply(last); got != params.MaxSupply {
+			t.Errorf("%s CalcSupply(%d) = %d, want %d", params.Name, last, got, params.MaxSupply)
+		}
+	}
+}
+
+func TestCoinbaseFollowsHalving(t *testing.T) {
+	alice := string(newWallet(t).GetAddress())
+	params := halvingParams
+	bc, err := core.CreateBlockchainWithStore(core.NewMemoryStore(), &params, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+
+	mine(t, bc, newCoinbase(t, bc, alice, 0))
+
+	// height 2 is after the first halving, the old subsidy overpays
+	tip, err := bc.GetBlock(tipHash(t, bc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bits, err := bc.CalcNextRequiredBits(&tip.BlockHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	overpaid := newBlock(t, []*core.Transaction{coinbaseTx(t, alice, "", 8)}, tip.Hash, tip.Height+1, bits)
+	if err = bc.AddBlock(overpaid); !errors.Is(err, core.ErrBadCoinbaseValue) {
+		t.Fatalf("AddBlock() error = %v, want %v", err, core.ErrBadCoinbaseValue)
+	}
+
+	mine(t, bc, newCoinbase(t, bc, alice, 0))
+	if got := balance(t, bc, alice); got != 8+8+4 {
+		t.Errorf("alice balance = %d, want %d", got, 8+8+4)
+	}
+	if supply, err := bc.Supply(); err != nil || supply != 8+8+4 {
+		t.Errorf("Supply() = %d, %v, want %d", supply, err, 8+8+4)
+	}
+}
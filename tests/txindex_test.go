@@ -0,0 +1,67 @@
+package tests
+
+import (
+	"blockchain-from-scratch/core"
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestTxIndex(t *testing.T) {
+	aliceWallet := newWallet(t)
+	alice := string(aliceWallet.GetAddress())
+	bob := string(newWallet(t).GetAddress())
+	bc := newTestChain(t, alice)
+
+	genesis, err := bc.GetBlock(tipHash(t, bc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = bc.ReindexTransactions(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the index is used to sign and verify the spend of the genesis output
+	toBob := send(t, bc, aliceWallet, bob, 4)
+	a1 := mine(t, bc, newCoinbase(t, bc, alice, 0), toBob)
+
+	found, err := bc.FindTransaction(toBob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(found.ID, toBob.ID) {
+		t.Fatalf("FindTransaction() = %x, want %x", found.ID, toBob.ID)
+	}
+
+	// a reorganization removes the transactions of the disconnected block from the index
+	b1 := mineOn(t, bc, &genesis, bob)
+	b2 := mineOn(t, bc, b1, bob)
+	for _, block := range []*core.Block{b1, b2} {
+		if err = bc.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tip := tipHash(t, bc); !bytes.Equal(tip, b2.Hash) {
+		t.Fatalf("tip = %x, want %x", tip, b2.Hash)
+	}
+	if _, err = bc.FindTransaction(toBob.ID); !errors.Is(err, core.ErrTxNotFound) {
+		t.Fatalf("FindTransaction() of a disconnected tx error = %v, want %v", err, core.ErrTxNotFound)
+	}
+	if _, err = bc.FindTransaction(b2.Transactions[0].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// without the index the chain is walked again
+	if err = bc.DropTxIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if enabled, _ := bc.TxIndexEnabled(); enabled {
+		t.Fatal("transaction index is still enabled")
+	}
+	if _, err = bc.FindTransaction(b1.Transactions[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = bc.FindTransaction(a1.Transactions[0].ID); !errors.Is(err, core.ErrTxNotFound) {
+		t.Fatalf("FindTransaction() of a side branch tx error = %v, want %v", err, core.ErrTxNotFound)
+	}
+}
@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"blockchain-from-scratch/core"
+	"testing"
+)
+
+func TestDisconnectRestoresUTXO(t *testing.T) {
+	aliceWallet := newWallet(t)
+	alice := string(aliceWallet.GetAddress())
+	bob := string(newWallet(t).GetAddress())
+	bc := newTestChain(t, alice)
+	utxoSet := core.UTXOSet{Blockchain: bc}
+
+	aliceBefore, bobBefore := balance(t, bc, alice), balance(t, bc, bob)
+
+	tx := send(t, bc, aliceWallet, bob, 4)
+	block := mine(t, bc, newCoinbase(t, bc, bob, 0), tx)
+	if got := balance(t, bc, bob); got != bobBefore+4+10 {
+		t.Fatalf("bob balance = %d, want %d", got, bobBefore+14)
+	}
+
+	if err := utxoSet.Disconnect(block); err != nil {
+		t.Fatal(err)
+	}
+	if got := balance(t, bc, alice); got != aliceBefore {
+		t.Errorf("alice balance after disconnect = %d, want %d", got, aliceBefore)
+	}
+	if got := balance(t, bc, bob); got != bobBefore {
+		t.Errorf("bob balance after disconnect = %d, want %d", got, bobBefore)
+	}
+
+	// connecting the block again gives the same UTXO set
+	if err := utxoSet.Update(block); err != nil {
+		t.Fatal(err)
+	}
+	if got := balance(t, bc, bob); got != bobBefore+14 {
+		t.Errorf("bob balance after reconnect = %d, want %d", got, bobBefore+14)
+	}
+}
+
+func TestSpendingKeepsOutputIndexes(t *testing.T) {
+	aliceWallet := newWallet(t)
+	bobWallet := newWallet(t)
+	alice := string(aliceWallet.GetAddress())
+	bob := string(bobWallet.GetAddress())
+	carol := string(newWallet(t).GetAddress())
+	bc := newTestChain(t, alice)
+	utxoSet := core.UTXOSet{Blockchain: bc}
+
+	// outputs: 0 -> bob 3, 1 -> alice change 7
+	toBob := send(t, bc, aliceWallet, bob, 3)
+	mine(t, bc, newCoinbase(t, bc, carol, 0), toBob)
+
+	// spending output 0 must not move output 1
+	mine(t, bc, newCoinbase(t, bc, carol, 0), send(t, bc, bobWallet, carol, 3))
+	change, err := utxoSet.GetUTXO(core.OutPoint{Txid: toBob.ID, Index: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.Value != 7 || change.Height != 1 {
+		t.Fatalf("change output = %+v, want value 7 at height 1", change)
+	}
+	if _, err = utxoSet.GetUTXO(core.OutPoint{Txid: toBob.ID, Index: 0}); err == nil {
+		t.Fatal("spent output is still in the UTXO set")
+	}
+
+	mine(t, bc, newCoinbase(t, bc, carol, 0), send(t, bc, aliceWallet, carol, 7))
+	if got := balance(t, bc, alice); got != 0 {
+		t.Errorf("alice balance = %d, want 0", got)
+	}
+	if got := balance(t, bc, carol); got != 3+7+30 {
+		t.Errorf("carol balance = %d, want %d", got, 3+7+30)
+	}
+}
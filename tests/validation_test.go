@@ -0,0 +1,88 @@
+package tests
+
+import (
+	"blockchain-from-scratch/core"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestValidateBlock(t *testing.T) {
+	aliceWallet := newWallet(t)
+	alice := string(aliceWallet.GetAddress())
+	bob := string(newWallet(t).GetAddress())
+	carol := string(newWallet(t).GetAddress())
+	bc := newTestChain(t, alice)
+
+	tip, err := bc.GetBlock(tipHash(t, bc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bits, err := bc.CalcNextRequiredBits(&tip.BlockHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	toBob := send(t, bc, aliceWallet, bob, 3)
+	toCarol := send(t, bc, aliceWallet, carol, 4)
+	coinbase := func() *core.Transaction { return coinbaseTx(t, alice, "", 10) }
+
+	// two huge outputs whose sum wraps to a negative value under the inputs
+	overflow := send(t, bc, aliceWallet, carol, 1)
+	overflow.VOut = []core.TxOutput{*newOutput(t, math.MaxInt/2+1, carol), *newOutput(t, math.MaxInt/2+1, carol)}
+	for i := range overflow.Vin {
+		overflow.Vin[i].ScriptSig = nil
+	}
+	overflow.ID = txID(t, overflow)
+	if err = bc.SignTransaction(overflow, aliceWallet.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = overflow.OutputValue(); !errors.Is(err, core.ErrValueOverflow) {
+		t.Errorf("OutputValue() error = %v, want %v", err, core.ErrValueOverflow)
+	}
+	if err = core.CheckTransactionSanity(bc.Params, overflow); !errors.Is(err, core.ErrBadTxOutValue) {
+		t.Errorf("CheckTransactionSanity() error = %v, want %v", err, core.ErrBadTxOutValue)
+	}
+	if err = bc.AcceptTransaction(overflow); !errors.Is(err, core.ErrBadTxOutValue) {
+		t.Errorf("AcceptTransaction() error = %v, want %v", err, core.ErrBadTxOutValue)
+	}
+
+	tampered := newBlock(t, []*core.Transaction{coinbase(), toBob}, tip.Hash, tip.Height+1, bits)
+	tampered.Transactions = tampered.Transactions[:1]
+
+	tests := []struct {
+		name  string
+		block *core.Block
+		want  error
+	}{
+		{"bad height", newBlock(t, []*core.Transaction{coinbase()}, tip.Hash, tip.Height+2, bits), core.ErrBadHeight},
+		{"bad difficulty", newBlock(t, []*core.Transaction{coinbase()}, tip.Hash, tip.Height+1, bits-1), core.ErrBadDifficulty},
+		{"tampered transactions", tampered, core.ErrBadMerkleRoot},
+		{"missing coinbase", newBlock(t, []*core.Transaction{toBob}, tip.Hash, tip.Height+1, bits), core.ErrFirstTxNotCoinbase},
+		{"duplicate transaction", newBlock(t, []*core.Transaction{coinbase(), toBob, toBob}, tip.Hash, tip.Height+1, bits), core.ErrDuplicateTx},
+		{"double spend", newBlock(t, []*core.Transaction{coinbase(), toBob, toCarol}, tip.Hash, tip.Height+1, bits), core.ErrDoubleSpend},
+		{"huge outputs", newBlock(t, []*core.Transaction{coinbase(), overflow}, tip.Hash, tip.Height+1, bits), core.ErrBadTxOutValue},
+		{"coinbase above max supply", newBlock(t, []*core.Transaction{coinbaseTx(t, alice, "", bc.Params.MaxSupply+1)}, tip.Hash, tip.Height+1, bits), core.ErrBadTxOutValue},
+		{"orphan", newBlock(t, []*core.Transaction{coinbase()}, []byte("unknown"), tip.Height+1, bits), core.ErrOrphanBlock},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := bc.AddBlock(test.block)
+			if !errors.Is(err, test.want) {
+				t.Fatalf("AddBlock() error = %v, want %v", err, test.want)
+			}
+			if _, err := bc.GetBlock(test.block.Hash); err == nil {
+				t.Fatal("rejected block is stored")
+			}
+		})
+	}
+
+	valid := newBlock(t, []*core.Transaction{coinbase(), toBob}, tip.Hash, tip.Height+1, bits)
+	if err := bc.AddBlock(valid); err != nil {
+		t.Fatalf("AddBlock() of a valid block error = %v", err)
+	}
+	if got := balance(t, bc, bob); got != 3 {
+		t.Errorf("bob balance = %d, want 3", got)
+	}
+}
@@ -0,0 +1,72 @@
+package tests
+
+import (
+	"blockchain-from-scratch/core"
+	"blockchain-from-scratch/core/wallet"
+	"errors"
+	"testing"
+)
+
+func TestWalletBalance(t *testing.T) {
+	aliceWallet := newWallet(t)
+	alice := string(aliceWallet.GetAddress())
+	bobWallet := newWallet(t)
+	bob := string(bobWallet.GetAddress())
+	bc := newTestChain(t, alice)
+	utxoSet := &core.UTXOSet{Blockchain: bc}
+
+	redeemScript, err := core.MultiSigScript(1, [][]byte{aliceWallet.PublicKey, bobWallet.PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	treasury := wallet.ScriptAddress(redeemScript.Hash())
+
+	// the wallet spends with the key of alice and watches bob and the treasury
+	wallets := &wallet.Wallets{Wallets: map[string]*wallet.Wallet{}}
+	if _, err = wallets.ImportWallet(aliceWallet); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = wallets.ImportPubKey(bobWallet.PublicKey); err != nil {
+		t.Fatal(err)
+	}
+	if err = wallets.ImportAddress(treasury); err != nil {
+		t.Fatal(err)
+	}
+	if err = wallets.ImportAddress("not an address"); !errors.Is(err, wallet.ErrInvalidAddress) {
+		t.Errorf("ImportAddress() error = %v, want %v", err, wallet.ErrInvalidAddress)
+	}
+	addresses := wallets.ListAddresses()
+	if len(addresses) != 3 || addresses[0] != (wallet.WalletAddress{Address: alice}) || !addresses[1].WatchOnly || !addresses[2].WatchOnly {
+		t.Fatalf("ListAddresses() = %+v, want alice then 2 watch-only addresses", addresses)
+	}
+
+	// bob gets 3 locked for 5 blocks, the treasury 4
+	tx, err := core.NewUTXOTransactionToScript(aliceWallet, core.SequenceLockScript(5, wallet.HashPubKey(bobWallet.PublicKey)), 3, 0, utxoSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mine(t, bc, newCoinbase(t, bc, alice, 0), tx)
+	mine(t, bc, newCoinbase(t, bc, alice, 0), send(t, bc, aliceWallet, treasury, 4))
+
+	balances, err := utxoSet.GetBalances([]string{alice, bob, treasury})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]core.Balance{
+		alice:    {Confirmed: 23, Spendable: 23},
+		bob:      {Confirmed: 3, Locked: 3},
+		treasury: {Confirmed: 4, Spendable: 4},
+	}
+	for address, balance := range want {
+		if balances[address] != balance {
+			t.Errorf("balance of %s = %+v, want %+v", address, balances[address], balance)
+		}
+	}
+	var total core.Balance
+	for _, balance := range balances {
+		total.Add(balance)
+	}
+	if total != (core.Balance{Confirmed: 30, Locked: 3, Spendable: 27}) {
+		t.Errorf("total = %+v, want all the coins mined", total)
+	}
+}
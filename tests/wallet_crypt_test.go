@@ -0,0 +1,117 @@
+package tests
+
+import (
+	"blockchain-from-scratch/core/wallet"
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestEncryptedWallet(t *testing.T) {
+	const nodeID = "crypt_test"
+	const walletFile, unlockFile = "wallet_" + nodeID + ".dat", "wallet_" + nodeID + ".unlock"
+	t.Cleanup(func() {
+		os.Remove(walletFile)
+		os.Remove(unlockFile)
+	})
+
+	// an unencrypted wallet file written with the old permissions
+	wallets := &wallet.Wallets{Wallets: map[string]*wallet.Wallet{}}
+	address, err := wallets.CreateWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(walletFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := wallets.SaveToFile(nodeID); err != nil {
+		t.Fatal(err)
+	}
+	checkMode(t, walletFile)
+	wallets, err = wallet.NewWallets(nodeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// it is migrated to an encrypted one
+	if err = wallets.Encrypt("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if err = wallets.Encrypt("correct horse"); !errors.Is(err, wallet.ErrWalletEncrypted) {
+		t.Errorf("Encrypt() error = %v, want %v", err, wallet.ErrWalletEncrypted)
+	}
+	if err = wallets.SaveToFile(nodeID); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(walletFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(content, wallets.Wallets[address].PrivateKey.D.Bytes()) {
+		t.Errorf("encrypted wallet file holds the private key")
+	}
+
+	wallets, err = wallet.NewWallets(nodeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !wallets.IsEncrypted() || !wallets.IsLocked() {
+		t.Fatalf("wallet encrypted %t, locked %t, want both", wallets.IsEncrypted(), wallets.IsLocked())
+	}
+	if _, err = wallets.GetWallet(address); !errors.Is(err, wallet.ErrWalletLocked) {
+		t.Errorf("GetWallet() error = %v, want %v", err, wallet.ErrWalletLocked)
+	}
+	if err = wallets.SaveToFile(nodeID); !errors.Is(err, wallet.ErrWalletLocked) {
+		t.Errorf("SaveToFile() error = %v, want %v", err, wallet.ErrWalletLocked)
+	}
+
+	// the key only unlocks the wallet in memory, a reloaded wallet file is locked
+	if err = wallets.Unlock("wrong"); !errors.Is(err, wallet.ErrBadPassphrase) {
+		t.Errorf("Unlock() error = %v, want %v", err, wallet.ErrBadPassphrase)
+	}
+	if err = wallets.Unlock("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = wallets.GetWallet(address); err != nil {
+		t.Errorf("GetWallet() error = %v", err)
+	}
+	if err = wallets.SaveToFile(nodeID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(unlockFile); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("unlock file is written: %v", err)
+	}
+	reloaded, err := wallet.NewWallets(nodeID)
+	if err != nil || !reloaded.IsLocked() {
+		t.Errorf("NewWallets() locked %t, error = %v, want a locked wallet", reloaded.IsLocked(), err)
+	}
+	if err = wallets.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = wallets.GetWallet(address); !errors.Is(err, wallet.ErrWalletLocked) {
+		t.Errorf("GetWallet() error = %v, want %v", err, wallet.ErrWalletLocked)
+	}
+
+	// the unlock file of an earlier version is removed with the key it holds
+	if err = os.WriteFile(unlockFile, []byte("key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = wallet.NewWallets(nodeID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(unlockFile); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("unlock file of an earlier version is not removed: %v", err)
+	}
+}
+
+func checkMode(t *testing.T, file string) {
+	t.Helper()
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("%s mode = %o, want 600", file, mode)
+	}
+}
@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"blockchain-from-scratch/core"
+	"blockchain-from-scratch/core/wallet"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestWalletHistory(t *testing.T) {
+	aliceWallet := newWallet(t)
+	alice := string(aliceWallet.GetAddress())
+	bobWallet := newWallet(t)
+	bob := string(bobWallet.GetAddress())
+	bc := newTestChain(t, alice)
+	utxoSet := &core.UTXOSet{Blockchain: bc}
+
+	wallets := &wallet.Wallets{Wallets: map[string]*wallet.Wallet{}}
+	if _, err := wallets.ImportWallet(aliceWallet); err != nil {
+		t.Fatal(err)
+	}
+	if err := wallets.SetLabel(bob, "Bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := wallets.SetLabel("not an address", "Nobody"); !errors.Is(err, wallet.ErrInvalidAddress) {
+		t.Errorf("SetLabel() error = %v, want %v", err, wallet.ErrInvalidAddress)
+	}
+
+	// alice pays bob 3 with a fee of 1, bob pays her back 1, then she anchors a hash
+	pay, err := core.NewUTXOTransaction(aliceWallet, bob, 3, 1, utxoSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mine(t, bc, newCoinbase(t, bc, alice, 1), pay)
+	payBack := send(t, bc, bobWallet, alice, 1)
+	block2 := mine(t, bc, newCoinbase(t, bc, alice, 0), payBack)
+	if err = bc.SyncWalletHistory(wallets); err != nil {
+		t.Fatal(err)
+	}
+	anchor, err := core.NewDataTransaction(aliceWallet, []byte("hash"), 0, utxoSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mine(t, bc, newCoinbase(t, bc, alice, 0), anchor)
+	if err = bc.SyncWalletHistory(wallets); err != nil {
+		t.Fatal(err)
+	}
+
+	txs := wallets.ListTransactions("", 100, 3)
+	want := []struct {
+		direction string
+		amount    int
+		fee       int
+		height    int
+	}{
+		{wallet.TxGenerate, 10, 0, 0},
+		{wallet.TxGenerate, 11, 0, 1},
+		{wallet.TxSend, -3, 1, 1},
+		{wallet.TxGenerate, 10, 0, 2},
+		{wallet.TxReceive, 1, 0, 2},
+		{wallet.TxGenerate, 10, 0, 3},
+		{wallet.TxSelf, 0, 0, 3},
+	}
+	if len(txs) != len(want) {
+		t.Fatalf("ListTransactions() = %+v, want %d transactions", txs, len(want))
+	}
+	for i, w := range want {
+		if txs[i].Direction != w.direction || txs[i].Amount != w.amount || txs[i].Fee != w.fee || txs[i].Height != w.height {
+			t.Errorf("transaction %d = %+v, want %+v", i, txs[i], w)
+		}
+		if txs[i].Confirmations != 3-w.height+1 {
+			t.Errorf("transaction %d has %d confirmations, want %d", i, txs[i].Confirmations, 3-w.height+1)
+		}
+	}
+	if txs[2].Txid != hex.EncodeToString(pay.ID) || len(txs[2].Counterparties) != 1 ||
+		txs[2].Counterparties[0] != (wallet.TxAddress{Address: bob, Label: "Bob", Amount: 3}) {
+		t.Errorf("send = %+v, want 3 paid to Bob", txs[2])
+	}
+	if len(txs[4].Counterparties) != 1 || txs[4].Counterparties[0] != (wallet.TxAddress{Address: bob, Label: "Bob", Amount: -1}) {
+		t.Errorf("receive counterparties = %+v, want 1 from Bob", txs[4].Counterparties)
+	}
+	if len(txs[6].Counterparties) != 1 || txs[6].Counterparties[0].Script != core.NullDataClass.String() {
+		t.Errorf("anchor counterparties = %+v, want a null data output", txs[6].Counterparties)
+	}
+
+	// the last ones touching bob
+	txs = wallets.ListTransactions(bob, 1, 3)
+	if len(txs) != 1 || txs[0].Txid != hex.EncodeToString(payBack.ID) {
+		t.Errorf("ListTransactions(bob, 1) = %+v, want the payment of bob", txs)
+	}
+
+	// a reorganization drops the transactions of block 3
+	fork := mineOn(t, bc, block2, bob)
+	if err = bc.AddBlock(fork); err != nil {
+		t.Fatal(err)
+	}
+	if err = bc.AddBlock(mineOn(t, bc, fork, bob)); err != nil {
+		t.Fatal(err)
+	}
+	if err = bc.SyncWalletHistory(wallets); err != nil {
+		t.Fatal(err)
+	}
+	if txs = wallets.ListTransactions("", 100, 4); len(txs) != 5 || wallets.History.TipHeight != 4 {
+		t.Errorf("ListTransactions() after the reorganization = %+v, want 5 transactions", txs)
+	}
+}
@@ -3,24 +3,25 @@ package utils
 import (
 	"bytes"
 	"encoding/gob"
-	"log"
+	"fmt"
 )
 
-func Serialize(v interface{}) []byte {
+func Serialize(v interface{}) ([]byte, error) {
 	var result bytes.Buffer
 	encoder := gob.NewEncoder(&result)
 
 	err := encoder.Encode(v)
 	if err != nil {
-		log.Panic(err)
+		return nil, fmt.Errorf("serialize %T: %w", v, err)
 	}
-	return result.Bytes()
+	return result.Bytes(), nil
 }
 
-func Deserialize(data []byte, v interface{}) {
+func Deserialize(data []byte, v interface{}) error {
 	decoder := gob.NewDecoder(bytes.NewReader(data))
 	err := decoder.Decode(v)
 	if err != nil {
-		log.Panic(err)
+		return fmt.Errorf("deserialize %T: %w", v, err)
 	}
+	return nil
 }
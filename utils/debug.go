@@ -3,7 +3,6 @@ package utils
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"time"
 )
 
@@ -11,7 +10,8 @@ func PrintJsonLog(v interface{}, label string) {
 	fmt.Printf("%s: ==> %s\n", time.Now().Format("2006-01-02 15:04:05.000"), label)
 	prevTXsJSON, err := json.MarshalIndent(v, "", "  ")
 	if err != nil {
-		log.Panic(err)
+		fmt.Printf("%s: cannot print %T: %s\n", label, v, err)
+		return
 	}
 	fmt.Println(string(prevTXsJSON))
 }